@@ -0,0 +1,225 @@
+package plugin_simplecache
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultPurgeLimit is the default maximum number of entries a single
+	// purge request deletes.
+	defaultPurgeLimit = 10000
+	// purgeSampleSize is the number of deleted keys reported by a purge.
+	purgeSampleSize = 10
+	// defaultImportMaxBytes is the default maximum size of a snapshot sent
+	// to the import path.
+	defaultImportMaxBytes = 1 << 30
+)
+
+// serveAdmin serves the management endpoints, reporting whether the request
+// was for one of them.
+func (m *cache) serveAdmin(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case m.cfg.MetricsPath != "" && r.URL.Path == m.cfg.MetricsPath:
+		m.serveMetrics(w)
+	case m.cfg.PurgePath != "" && r.URL.Path == m.cfg.PurgePath:
+		if m.authorizeAdmin(w, r) {
+			m.servePurge(w, r)
+		}
+	case m.cfg.CleanupPath != "" && r.URL.Path == m.cfg.CleanupPath:
+		if m.authorizeAdmin(w, r) {
+			m.serveCleanup(w, r)
+		}
+	case m.cfg.DebugPath != "" && r.URL.Path == m.cfg.DebugPath:
+		m.serveStat(w, r)
+	case m.cfg.ExportPath != "" && r.URL.Path == m.cfg.ExportPath:
+		if m.authorizeAdmin(w, r) {
+			m.serveExport(w, r)
+		}
+	case m.cfg.ImportPath != "" && r.URL.Path == m.cfg.ImportPath:
+		if m.authorizeAdmin(w, r) {
+			m.serveImport(w, r)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+type purgeResult struct {
+	Purged int      `json:"purged"`
+	Keys   []string `json:"keys"`
+}
+
+// servePurge deletes the entries whose path matches the pattern query
+// parameter, as understood by path.Match, optionally restricted to the host
+// query parameter. Alternatively, the key query parameter deletes the entry
+// stored under that key, with all its variants unless it is a variant's.
+func (m *cache) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != "PURGE" {
+		w.Header().Set("Allow", "POST, PURGE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+
+	if key := q.Get("key"); key != "" {
+		writePurgeResult(w, m.cache.PurgeKey(key))
+		return
+	}
+
+	pattern := q.Get("pattern")
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		http.Error(w, "invalid pattern", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPurgeLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		limit = n
+	}
+
+	host := q.Get("host")
+
+	keys := m.cache.Purge(func(meta entryMeta) bool {
+		if host != "" && meta.Host != host {
+			return false
+		}
+
+		ok, _ := path.Match(pattern, meta.Path)
+
+		return ok
+	}, limit)
+
+	writePurgeResult(w, keys)
+}
+
+// writePurgeResult reports the number of deleted entries and a sample of
+// their keys.
+func writePurgeResult(w http.ResponseWriter, keys []string) {
+	res := purgeResult{Purged: len(keys), Keys: keys}
+	if len(res.Keys) > purgeSampleSize {
+		res.Keys = res.Keys[:purgeSampleSize]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("Error writing purge result: %v", err)
+	}
+}
+
+type cleanupResult struct {
+	Removed int `json:"removed"`
+}
+
+// serveCleanup removes the expired entries right away, as the periodic
+// cleanup does.
+func (m *cache) serveCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := cleanupResult{Removed: m.cache.Cleanup(time.Now())}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("Error writing cleanup result: %v", err)
+	}
+}
+
+// serveStat describes the entry stored under the key query parameter,
+// without reading its body.
+func (m *cache) serveStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+
+	stat, ok := m.cache.Stat(key)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(stat); err != nil {
+		log.Printf("Error writing entry stat: %v", err)
+	}
+}
+
+// serveExport streams a snapshot of the cache, to be restored with
+// serveImport.
+func (m *cache) serveExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if _, err := m.cache.Export(w); err != nil {
+		log.Printf("Error writing cache snapshot: %v", err)
+	}
+}
+
+type importResult struct {
+	Imported int `json:"imported"`
+}
+
+// serveImport stores the entries of the snapshot sent as the request body.
+func (m *cache) serveImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := m.cfg.ImportMaxBytes
+	if limit == 0 {
+		limit = defaultImportMaxBytes
+	}
+
+	// No response larger than the buffer budget is ever stored.
+	maxEntry := limit
+	if m.cfg.MaxBufferMemory > 0 && m.cfg.MaxBufferMemory < maxEntry {
+		maxEntry = m.cfg.MaxBufferMemory
+	}
+
+	n, err := m.cache.Import(http.MaxBytesReader(w, r.Body, int64(limit)), maxEntry)
+	if err != nil {
+		log.Printf("Error importing cache snapshot after %d entries: %v", n, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(importResult{Imported: n}); err != nil {
+		log.Printf("Error writing import result: %v", err)
+	}
+}
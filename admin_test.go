@@ -0,0 +1,332 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache_Purge(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, PurgePath: "/_purge"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"/products/1/reviews", "/products/2/reviews?page=2", "/products/2", "/products/1/reviews/3"}
+	for _, p := range paths {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+p, nil))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/_purge?pattern=/products/*/reviews", nil)
+	rw := httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+
+	req = httptest.NewRequest("PURGE", "http://localhost/_purge?pattern=/products/*/reviews", nil)
+	rw = httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	var res purgeResult
+	if err = json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Purged != 2 || len(res.Keys) != 2 {
+		t.Errorf("unexpected purge result: %+v", res)
+	}
+
+	wantStates := map[string]string{
+		"/products/1/reviews":        "miss",
+		"/products/2/reviews?page=2": "miss",
+		"/products/2":                "hit",
+		"/products/1/reviews/3":      "hit",
+	}
+
+	for p, want := range wantStates {
+		rw = httptest.NewRecorder()
+
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+p, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state for %s: want %q, got %q", p, want, state)
+		}
+	}
+}
+
+func TestCache_PurgeVariants(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Vary", "Accept-Language")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: varyModeKey, PurgePath: "/_purge"}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	get := func(lang string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		req.Header.Set("Accept-Language", lang)
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		return rw.Header().Get("Cache-Status")
+	}
+
+	purge := func(q string) purgeResult {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest("PURGE", "http://localhost/_purge?"+q, nil))
+
+		var res purgeResult
+		if err := json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	for _, lang := range []string{"en", "fr", "de"} {
+		get(lang)
+	}
+
+	key := "GETlocalhost/page"
+
+	if res := purge("key=" + url.QueryEscape(key+variantSep+"Accept-Language=fr")); res.Purged != 1 {
+		t.Errorf("unexpected variant purge result: %+v", res)
+	}
+
+	for lang, want := range map[string]string{"en": "hit", "fr": "miss", "de": "hit"} {
+		if state := get(lang); state != want {
+			t.Errorf("unexpected cache state for %s: want %q, got %q", lang, want, state)
+		}
+	}
+
+	// The marker and the three variants.
+	if res := purge("pattern=/page"); res.Purged != 4 {
+		t.Errorf("unexpected purge result: %+v", res)
+	}
+
+	if _, err = os.Stat(keyPath(dir, key) + variantsSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the variants directory to be removed, got: %v", err)
+	}
+
+	for _, lang := range []string{"en", "fr"} {
+		if state := get(lang); state != "miss" {
+			t.Errorf("unexpected cache state for %s: want %q, got %q", lang, "miss", state)
+		}
+	}
+
+	if res := purge("key=" + url.QueryEscape(key)); res.Purged != 3 {
+		t.Errorf("unexpected key purge result: %+v", res)
+	}
+}
+
+func TestCache_PurgeInvalidPattern(t *testing.T) {
+	dir := createTempDir(t)
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, PurgePath: "/_purge"}
+
+	c, err := New(context.Background(), nil, cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"", "?pattern=[", "?pattern=/a&limit=0"} {
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost/_purge"+q, nil))
+
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status for %q: want %d, got %d", q, http.StatusBadRequest, rw.Code)
+		}
+	}
+}
+
+func TestCache_Stat(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNonAuthoritativeInfo)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, DebugPath: "/_cache"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/_cache?key=GETlocalhost/page", nil))
+
+	var stat entryStat
+	if err = json.Unmarshal(rw.Body.Bytes(), &stat); err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Status != http.StatusNonAuthoritativeInfo || stat.Path != "/page" || stat.Size == 0 {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/_cache?key=GETlocalhost/missing", nil))
+
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusNotFound, rw.Code)
+	}
+}
+
+func TestCache_Cleanup(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, CleanupPath: "/_cleanup"}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	for _, key := range []string{"expired1", "expired2"} {
+		if err = c.cache.Set(key, []byte("{}"), -time.Second, entryMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = c.cache.Set("fresh", []byte("{}"), time.Minute, entryMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/_cleanup", nil))
+
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost/_cleanup", nil))
+
+	var res cleanupResult
+	if err = json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Removed != 2 {
+		t.Errorf("unexpected cleanup result: %+v", res)
+	}
+
+	if _, ok := c.cache.Stat("fresh"); !ok {
+		t.Error("expected fresh entry to be kept")
+	}
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {}
+
+	src, err := New(context.Background(), http.HandlerFunc(next), &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, ExportPath: "/_export", InvalidationSecret: "s3cret"}, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := New(context.Background(), http.HandlerFunc(next), &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, ImportPath: "/_import", InvalidationSecret: "s3cret"}, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = src.(*cache).cache.Set("key", []byte("{}"), time.Minute, entryMeta{Path: "/some/path"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	src.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/_export", nil))
+
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status for an unauthenticated export: %d", rw.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/_export", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rw = httptest.NewRecorder()
+	src.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("unexpected export status: %d", rw.Code)
+	}
+
+	snapshot := rw.Body.Bytes()
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/_import", bytes.NewReader(snapshot))
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rw = httptest.NewRecorder()
+	dst.ServeHTTP(rw, req)
+
+	var res importResult
+	if err = json.Unmarshal(rw.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Imported != 1 {
+		t.Errorf("unexpected import result: %+v", res)
+	}
+
+	if stat, ok := dst.(*cache).cache.Stat("key"); !ok || stat.Path != "/some/path" {
+		t.Errorf("expected the entry to be imported, got %+v", stat)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/_import", strings.NewReader("garbage"))
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rw = httptest.NewRecorder()
+	dst.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status for an invalid snapshot: %d", rw.Code)
+	}
+
+	dst.(*cache).cfg.ImportMaxBytes = len(snapshot) - 1
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/_import", bytes.NewReader(snapshot))
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rw = httptest.NewRecorder()
+	dst.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status for a snapshot over importMaxBytes: %d", rw.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package plugin_simplecache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminSignatureHeader is the request header carrying the HMAC signature of
+// a management request, see signAdminRequest.
+const adminSignatureHeader = "X-Cache-Signature"
+
+// adminSignatureMaxSkew is how far from now the time of a signature may be,
+// so that a captured signed request can't be replayed for long.
+const adminSignatureMaxSkew = 5 * time.Minute
+
+// authorizeAdmin reports whether r may use the invalidation and data
+// endpoints, rejecting it otherwise. With InvalidationSecret set, r must carry
+// the secret as a bearer token, or an HMAC signature made with it.
+func (m *cache) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	secret := m.cfg.InvalidationSecret
+	if secret == "" {
+		return true
+	}
+
+	token, sig := bearerToken(r), r.Header.Get(adminSignatureHeader)
+
+	switch {
+	case token == "" && sig == "":
+		w.Header().Set("WWW-Authenticate", `Bearer realm="simplecache"`)
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return false
+	case token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1:
+		return true
+	case sig != "" && validAdminSignature(secret, r, sig, time.Now()):
+		return true
+	}
+
+	http.Error(w, "invalid token", http.StatusForbidden)
+
+	return false
+}
+
+// bearerToken returns the bearer token of r, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[7:])
+}
+
+// signAdminRequest returns the signature of a management request with the
+// given method and request URI made at t: the unix time, a dot, and the hex
+// HMAC-SHA256 with secret of the method, the request URI and the unix time,
+// separated by newlines. The query is signed along with the path, so a
+// signature for a purge can't be reused for another.
+func signAdminRequest(secret, method, uri string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(method + "\n" + uri + "\n" + ts))
+
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validAdminSignature(secret string, r *http.Request, sig string, now time.Time) bool {
+	i := strings.IndexByte(sig, '.')
+	if i < 0 {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(sig[:i], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	t := time.Unix(ts, 0)
+	if t.Before(now.Add(-adminSignatureMaxSkew)) || t.After(now.Add(adminSignatureMaxSkew)) {
+		return false
+	}
+
+	want := signAdminRequest(secret, r.Method, r.URL.RequestURI(), t)
+
+	return hmac.Equal([]byte(sig), []byte(want))
+}
@@ -0,0 +1,71 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_InvalidationSecret(t *testing.T) {
+	const secret = "s3cret"
+
+	cfg := &Config{
+		Backend:            backendMemory,
+		MaxExpiry:          10,
+		Cleanup:            20,
+		PurgePath:          "/_purge",
+		CleanupPath:        "/_cleanup",
+		MetricsPath:        "/_metrics",
+		InvalidationSecret: secret,
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const target = "/_purge?pattern=/products/*"
+
+	tests := []struct {
+		name     string
+		target   string
+		auth     string
+		sig      string
+		wantCode int
+	}{
+		{name: "missing token", target: target, wantCode: http.StatusUnauthorized},
+		{name: "invalid bearer token", target: target, auth: "Bearer guess", wantCode: http.StatusForbidden},
+		{name: "other scheme", target: target, auth: "Basic " + secret, wantCode: http.StatusUnauthorized},
+		{name: "valid bearer token", target: target, auth: "Bearer " + secret, wantCode: http.StatusOK},
+		{name: "valid signature", target: target, sig: signAdminRequest(secret, http.MethodPost, target, time.Now()), wantCode: http.StatusOK},
+		{name: "signature of another purge", target: "/_purge?pattern=/*", sig: signAdminRequest(secret, http.MethodPost, target, time.Now()), wantCode: http.StatusForbidden},
+		{name: "signature with another secret", target: target, sig: signAdminRequest("guess", http.MethodPost, target, time.Now()), wantCode: http.StatusForbidden},
+		{name: "expired signature", target: target, sig: signAdminRequest(secret, http.MethodPost, target, time.Now().Add(-time.Hour)), wantCode: http.StatusForbidden},
+		{name: "malformed signature", target: target, sig: "deadbeef", wantCode: http.StatusForbidden},
+		{name: "cleanup", target: "/_cleanup", wantCode: http.StatusUnauthorized},
+		{name: "metrics stay open", target: "/_metrics", wantCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+test.target, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		if test.sig != "" {
+			req.Header.Set(adminSignatureHeader, test.sig)
+		}
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if rw.Code != test.wantCode {
+			t.Errorf("%s: unexpected status: want %d, got %d", test.name, test.wantCode, rw.Code)
+		}
+
+		if rw.Code == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", test.name)
+		}
+	}
+}
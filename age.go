@@ -0,0 +1,44 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// correctedInitialAge returns the age of a response when it was received,
+// following the age calculation of RFC 7234 section 4.2.3. requestTime is
+// when the request was sent and responseTime when the response was received.
+func correctedInitialAge(h http.Header, requestTime, responseTime time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		apparentAge = responseTime.Sub(date)
+		if apparentAge < 0 {
+			apparentAge = 0
+		}
+	}
+
+	var ageValue time.Duration
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+
+	responseDelay := responseTime.Sub(requestTime)
+	correctedAgeValue := ageValue + responseDelay
+
+	if apparentAge > correctedAgeValue {
+		return apparentAge
+	}
+
+	return correctedAgeValue
+}
+
+// currentAge returns the age of a stored response at now.
+func currentAge(data *cacheData, now time.Time) time.Duration {
+	residentTime := now.Sub(data.ResponseTime)
+	if residentTime < 0 {
+		residentTime = 0
+	}
+
+	return data.InitialAge + residentTime
+}
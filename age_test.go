@@ -0,0 +1,147 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCorrectedInitialAge(t *testing.T) {
+	requestTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	responseTime := requestTime.Add(2 * time.Second)
+
+	tests := []struct {
+		name string
+		date time.Time
+		age  string
+		want time.Duration
+	}{
+		{
+			name: "fresh from origin only accounts for the response delay",
+			date: responseTime,
+			want: 2 * time.Second,
+		},
+		{
+			name: "upstream age is corrected by the response delay",
+			date: responseTime,
+			age:  "60",
+			want: 62 * time.Second,
+		},
+		{
+			name: "apparent age wins when the Date is older",
+			date: responseTime.Add(-5 * time.Minute),
+			age:  "60",
+			want: 5 * time.Minute,
+		},
+		{
+			name: "Date in the future gives no apparent age",
+			date: responseTime.Add(time.Hour),
+			want: 2 * time.Second,
+		},
+		{
+			name: "invalid Age is ignored",
+			date: responseTime,
+			age:  "abc",
+			want: 2 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set("Date", test.date.Format(http.TimeFormat))
+
+			if test.age != "" {
+				h.Set("Age", test.age)
+			}
+
+			if got := correctedInitialAge(h, requestTime, responseTime); got != test.want {
+				t.Errorf("unexpected age: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCurrentAge(t *testing.T) {
+	responseTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	data := &cacheData{ResponseTime: responseTime, InitialAge: 62 * time.Second}
+
+	if got := currentAge(data, responseTime.Add(time.Minute)); got != 122*time.Second {
+		t.Errorf("unexpected age: want %v, got %v", 122*time.Second, got)
+	}
+}
+
+func TestCache_ServeHTTP_Age(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		rw.Header().Set("Age", "30")
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if age := rw.Header().Get("Age"); age != "30" && age != "31" {
+		t.Errorf("unexpected Age: want 30, got %q", age)
+	}
+}
+
+func TestCache_ServeHTTP_MaxServeAge(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxServeAge int
+		age         string
+		wantState   string
+		wantStored  bool
+	}{
+		{name: "fresh", age: "10", wantState: "hit", wantStored: true},
+		{name: "older than maxExpiry", age: "400", wantState: "hit", wantStored: true},
+		{name: "within maxServeAge", maxServeAge: 60, age: "10", wantState: "hit", wantStored: true},
+		{name: "older than maxServeAge", maxServeAge: 60, age: "100", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Age", test.age)
+				_, _ = rw.Write([]byte("body"))
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 300, MaxServeAge: test.maxServeAge, Cleanup: 20, AddStatusHeader: true}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+
+			if stored := len(c.(*cache).cache.(*fileCache).index.Metas()) > 0; stored != test.wantStored {
+				t.Errorf("unexpected stored: want %t, got %t", test.wantStored, stored)
+			}
+		})
+	}
+}
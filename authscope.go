@@ -0,0 +1,82 @@
+package plugin_simplecache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// authScope returns the normalized value of the configured claim of the JWT
+// bearer token of r, reporting false if r carries an Authorization header it
+// can't be read from. The token signature is not verified, and hits never
+// reach the origin to be authorized, so this is only safe behind a middleware
+// that verifies the token first.
+func (m *cache) authScope(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", true
+	}
+
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSpace(auth[7:]), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", false
+	}
+
+	var claims map[string]interface{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", false
+	}
+
+	scope := claimValue(claims[m.cfg.AuthScopeClaim])
+
+	// An empty scope would share the entries of anonymous requests.
+	return scope, scope != ""
+}
+
+// claimValue returns the scopes held by a claim, either a space-delimited
+// string or an array, sorted and deduplicated so that equivalent claims
+// select the same entry.
+func claimValue(v interface{}) string {
+	var scopes []string
+
+	switch v := v.(type) {
+	case string:
+		scopes = strings.Fields(v)
+	case float64:
+		scopes = []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		scopes = []string{strconv.FormatBool(v)}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return ""
+			}
+
+			scopes = append(scopes, strings.Fields(s)...)
+		}
+	}
+
+	sort.Strings(scopes)
+
+	uniq := scopes[:0]
+	for i, s := range scopes {
+		if i == 0 || s != scopes[i-1] {
+			uniq = append(uniq, s)
+		}
+	}
+
+	return strings.Join(uniq, " ")
+}
@@ -0,0 +1,108 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testJWT returns an unsigned bearer token with the given JSON payload.
+func testJWT(payload string) string {
+	enc := base64.RawURLEncoding
+
+	return "Bearer " + enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+func TestCache_AuthScope(t *testing.T) {
+	m := &cache{cfg: &Config{AuthScopeClaim: "scope"}}
+
+	tests := []struct {
+		name   string
+		auth   string
+		want   string
+		wantOK bool
+	}{
+		{name: "no header", wantOK: true},
+		{name: "string claim", auth: testJWT(`{"sub":"1","scope":"write read"}`), want: "read write", wantOK: true},
+		{name: "array claim", auth: testJWT(`{"sub":"2","scope":["write","read","read"]}`), want: "read write", wantOK: true},
+		{name: "lowercase scheme", auth: "bearer " + testJWT(`{"scope":"read"}`)[7:], want: "read", wantOK: true},
+		{name: "missing claim", auth: testJWT(`{"sub":"1"}`)},
+		{name: "empty claim", auth: testJWT(`{"scope":""}`)},
+		{name: "object claim", auth: testJWT(`{"scope":{"read":true}}`)},
+		{name: "basic auth", auth: "Basic dXNlcjpwYXNz"},
+		{name: "opaque token", auth: "Bearer abcdef"},
+		{name: "invalid payload", auth: "Bearer a.!!!.c"},
+		{name: "non-JSON payload", auth: "Bearer a." + base64.RawURLEncoding.EncodeToString([]byte("scope")) + ".c"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/api", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+
+		got, ok := m.authScope(req)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: unexpected scope: want %q (%t), got %q (%t)", test.name, test.want, test.wantOK, got, ok)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_AuthScopeClaim(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Backend:            backendMemory,
+		MaxExpiry:          10,
+		Cleanup:            20,
+		AddStatusHeader:    true,
+		BypassOnAuthHeader: true,
+		AuthScopeClaim:     "scope",
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	tests := []struct {
+		name      string
+		auth      string
+		wantState string
+	}{
+		{name: "first reader", auth: testJWT(`{"sub":"alice","scope":"read"}`), wantState: "miss"},
+		{name: "same scope", auth: testJWT(`{"sub":"bob","scope":"read"}`), wantState: "hit"},
+		{name: "other scope", auth: testJWT(`{"sub":"carol","scope":"read write"}`), wantState: "miss"},
+		{name: "anonymous", wantState: "miss"},
+		{name: "anonymous again", wantState: "hit"},
+		{name: "opaque token", auth: "Bearer abcdef", wantState: "miss"},
+		{name: "opaque token again", auth: "Bearer abcdef", wantState: "miss"},
+		{name: "no claim", auth: testJWT(`{"sub":"dave"}`), wantState: "miss"},
+		{name: "no claim again", auth: testJWT(`{"sub":"dave"}`), wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/api", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("%s: unexpected cache state: want %q, got %q", test.name, test.wantState, state)
+		}
+	}
+
+	// The read scope, the read write scope and anonymous requests.
+	if n := len(c.cache.(*memoryCache).values); n != 3 {
+		t.Errorf("unexpected number of entries: want 3, got %d", n)
+	}
+}
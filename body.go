@@ -0,0 +1,179 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxKeyBodySize is the largest request body hashed into a cache key. Requests
+// with larger bodies are not cached.
+const maxKeyBodySize = 1 << 20
+
+const (
+	// getBodyBypass doesn't cache requests with a safe method, such as GET,
+	// sent with a body.
+	getBodyBypass = "bypass"
+	// getBodyKey keys requests with a safe method sent with a body on the
+	// body, as those with an unsafe method.
+	getBodyKey = "key"
+)
+
+// safeMethod reports whether method is safe, i.e. its responses never depend
+// on a request body.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateCacheMethods makes sure unsafe methods are only cached when their
+// request bodies are part of the cache key.
+func validateCacheMethods(cfg *Config) error {
+	for _, method := range cfg.CacheMethods {
+		if method == "" {
+			return fmt.Errorf("invalid cache method %q", method)
+		}
+
+		if !safeMethod(method) && !cfg.BodyHashKey && len(cfg.POSTKeyFields) == 0 {
+			return fmt.Errorf("caching unsafe method %s requires bodyHashKey or postKeyFields", method)
+		}
+	}
+
+	switch cfg.GETBodyPolicy {
+	case "", getBodyBypass, getBodyKey:
+	default:
+		return fmt.Errorf("invalid getBodyPolicy %q", cfg.GETBodyPolicy)
+	}
+
+	return nil
+}
+
+// hasBody reports whether r has a non-empty body, leaving it readable by
+// next.
+func hasBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return false
+	}
+
+	if r.ContentLength > 0 {
+		return true
+	}
+
+	// The length is unknown, e.g. for chunked bodies.
+	var b [1]byte
+	n, _ := io.ReadFull(r.Body, b[:])
+
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(b[:n]), r.Body), Closer: r.Body}
+
+	return n > 0
+}
+
+// bodyKey returns the part of the key of a request with an unsafe method
+// identifying its body, leaving the body readable by next. It reports false
+// if the body is too large or can't be read.
+func (m *cache) bodyKey(r *http.Request) (string, bool) {
+	b, ok := readBody(r)
+	if !ok {
+		return "", false
+	}
+
+	if len(m.cfg.POSTKeyFields) > 0 && m.postKeyPath(r.URL.Path) {
+		if fields, ok := projectFields(b, m.cfg.POSTKeyFields); ok {
+			return "fields=" + hashBytes(fields), true
+		}
+	}
+
+	return "body=" + hashBytes(b), true
+}
+
+// postKeyPath reports whether requests to path are keyed on the configured
+// body fields only, which is the case of all paths when no prefixes are
+// configured.
+func (m *cache) postKeyPath(path string) bool {
+	if len(m.cfg.POSTKeyPaths) == 0 {
+		return true
+	}
+
+	for _, prefix := range m.cfg.POSTKeyPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readBody reads the request body, leaving it readable by next. It reports
+// false if the body is too large or can't be read.
+func readBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxKeyBodySize+1))
+
+	// Whatever was read is put back in front of the rest of the body.
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(b), r.Body), Closer: r.Body}
+
+	if err != nil || len(b) > maxKeyBodySize {
+		return nil, false
+	}
+
+	return b, true
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// projectFields returns the canonical JSON encoding of the values of the
+// given dot-separated fields of a JSON object body, a missing field being
+// null. It reports false if the body isn't a JSON object.
+func projectFields(body []byte, fields []string) ([]byte, bool) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+		return nil, false
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		var v interface{} = doc
+		for _, name := range strings.Split(field, ".") {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+
+			v = obj[name]
+		}
+
+		values[i] = v
+	}
+
+	// Objects are encoded with sorted keys, so the order of the keys in the
+	// body doesn't matter.
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
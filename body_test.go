@@ -0,0 +1,176 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("payload"))
+
+	b, ok := readBody(req)
+	if !ok || string(b) != "payload" {
+		t.Fatalf("expected the body, got %q %t", b, ok)
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil || string(b) != "payload" {
+		t.Errorf("expected the body to be restored, got %q: %v", b, err)
+	}
+
+	large := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(strings.Repeat("x", maxKeyBodySize+10)))
+	if _, ok = readBody(large); ok {
+		t.Error("expected large bodies not to be hashed")
+	}
+
+	if b, _ = ioutil.ReadAll(large.Body); len(b) != maxKeyBodySize+10 {
+		t.Errorf("expected the large body to be restored, got %d bytes", len(b))
+	}
+}
+
+func TestCache_ServeHTTP_BodyHashKey(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		_, _ = rw.Write(b)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, CacheMethods: []string{"POST"}, BodyHashKey: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body      string
+		wantState string
+	}{
+		{body: "a", wantState: "miss"},
+		{body: "b", wantState: "miss"},
+		{body: "a", wantState: "hit"},
+	}
+
+	for _, test := range tests {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost/search", strings.NewReader(test.body)))
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %q: want %q, got %q", test.body, test.wantState, state)
+		}
+
+		if rw.Body.String() != test.body {
+			t.Errorf("unexpected body: want %q, got %q", test.body, rw.Body.String())
+		}
+	}
+}
+
+func TestHasBody(t *testing.T) {
+	if hasBody(httptest.NewRequest(http.MethodGet, "http://localhost/", nil)) {
+		t.Error("expected no body")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", strings.NewReader("a"))
+	if !hasBody(req) {
+		t.Error("expected a body")
+	}
+
+	// A body of unknown length is peeked at, and left whole.
+	req.ContentLength = -1
+	if !hasBody(req) {
+		t.Error("expected a body of unknown length")
+	}
+
+	if b, _ := ioutil.ReadAll(req.Body); string(b) != "a" {
+		t.Errorf("unexpected body left: %q", b)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/", strings.NewReader(""))
+	req.ContentLength = -1
+	if hasBody(req) {
+		t.Error("expected an empty body of unknown length to be no body")
+	}
+}
+
+func TestCache_ServeHTTP_GETBody(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantStates []string
+	}{
+		{policy: "", wantStates: []string{"miss", "miss", "miss", "miss", "hit"}},
+		{policy: getBodyKey, wantStates: []string{"miss", "miss", "hit", "miss", "hit"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				b, _ := ioutil.ReadAll(req.Body)
+				_, _ = rw.Write(b)
+			}
+
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, GETBodyPolicy: test.policy}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, body := range []string{"a", "b", "a", "", ""} {
+				rw := httptest.NewRecorder()
+				h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/search", strings.NewReader(body)))
+
+				if state := rw.Header().Get("Cache-Status"); state != test.wantStates[i] {
+					t.Errorf("request %d: unexpected cache state for %q: want %q, got %q", i, body, test.wantStates[i], state)
+				}
+
+				if rw.Body.String() != body {
+					t.Errorf("request %d: unexpected body: want %q, got %q", i, body, rw.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCache_bodyKey_POSTKeyFields(t *testing.T) {
+	m := &cache{cfg: &Config{POSTKeyFields: []string{"query", "filters.category"}, POSTKeyPaths: []string{"/search"}}}
+
+	key := func(target, body string) string {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+target, strings.NewReader(body))
+
+		k, ok := m.bodyKey(req)
+		if !ok {
+			t.Fatalf("unexpected body key failure for %q", body)
+		}
+
+		if b, _ := ioutil.ReadAll(req.Body); string(b) != body {
+			t.Errorf("expected the body to be restored, got %q", b)
+		}
+
+		return k
+	}
+
+	a := key("/search", `{"query":"shoes","requestId":"1","filters":{"category":"men","size":42}}`)
+	b := key("/search", `{"filters":{"size":40,"category":"men"},"requestId":"2","query":"shoes"}`)
+	c := key("/search", `{"query":"shoes","filters":{"category":"women"}}`)
+
+	if a != b {
+		t.Errorf("expected reordered bodies with the same fields to share a key: %q, %q", a, b)
+	}
+
+	if a == c {
+		t.Errorf("expected different fields to have distinct keys: %q", a)
+	}
+
+	if !strings.HasPrefix(key("/search", "not json"), "body=") {
+		t.Error("expected non JSON bodies to be keyed on the whole body")
+	}
+
+	if key("/orders", `{"query":"shoes","requestId":"1"}`) == key("/orders", `{"query":"shoes","requestId":"2"}`) {
+		t.Error("expected other paths to be keyed on the whole body")
+	}
+}
@@ -0,0 +1,49 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bucketSep introduces the A/B test bucket in a key.
+const bucketSep = "|bucket="
+
+func validateBucket(cfg *Config) error {
+	if (cfg.BucketCookie == "") != (cfg.BucketHeader == "") {
+		return errors.New("bucketCookie and bucketHeader must be set together")
+	}
+
+	return nil
+}
+
+// requestBucket returns the A/B test bucket r was assigned, empty if none
+// yet.
+func (m *cache) requestBucket(r *http.Request) string {
+	c, err := r.Cookie(m.cfg.BucketCookie)
+	if err != nil {
+		return ""
+	}
+
+	return c.Value
+}
+
+// responseBucketKey returns the key to store the response to r with the
+// headers h under, moving it to the bucket named by the response if any. The
+// origin may assign a bucket to a request that has none yet, or another one
+// than it asked for.
+func (m *cache) responseBucketKey(key string, r *http.Request, h http.Header) string {
+	if m.cfg.BucketHeader == "" {
+		return key
+	}
+
+	bucket := strings.TrimSpace(h.Get(m.cfg.BucketHeader))
+	if bucket == "" {
+		return key
+	}
+
+	key = strings.TrimSuffix(key, bucketSep+url.QueryEscape(m.requestBucket(r)))
+
+	return key + bucketSep + url.QueryEscape(bucket)
+}
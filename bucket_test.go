@@ -0,0 +1,82 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTP_Buckets(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+
+		// New visitors are assigned bucket A.
+		bucket := "A"
+		if c, err := req.Cookie("ab"); err == nil {
+			bucket = c.Value
+		}
+
+		rw.Header().Set("X-Cache-Bucket", bucket)
+		_, _ = rw.Write([]byte("variant " + bucket))
+	}
+
+	cfg := &Config{
+		Backend:         backendMemory,
+		MaxExpiry:       10,
+		Cleanup:         20,
+		AddStatusHeader: true,
+		BucketCookie:    "ab",
+		BucketHeader:    "X-Cache-Bucket",
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	tests := []struct {
+		bucket    string
+		wantState string
+		wantBody  string
+	}{
+		{bucket: "A", wantState: "miss", wantBody: "variant A"},
+		{bucket: "B", wantState: "miss", wantBody: "variant B"},
+		{bucket: "A", wantState: "hit", wantBody: "variant A"},
+		{bucket: "B", wantState: "hit", wantBody: "variant B"},
+		// Unassigned visitors go to the origin, whose response is stored in
+		// the bucket it assigned.
+		{wantState: "miss", wantBody: "variant A"},
+		{wantState: "miss", wantBody: "variant A"},
+		{bucket: "A", wantState: "hit", wantBody: "variant A"},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/landing", nil)
+		if test.bucket != "" {
+			req.AddCookie(&http.Cookie{Name: "ab", Value: test.bucket})
+		}
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("request %d: unexpected cache state: want %q, got %q", i, test.wantState, state)
+		}
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("request %d: unexpected body: want %q, got %q", i, test.wantBody, body)
+		}
+	}
+
+	if calls != 4 {
+		t.Errorf("unexpected origin calls: want 4, got %d", calls)
+	}
+
+	if n := len(c.cache.(*memoryCache).values); n != 2 {
+		t.Errorf("unexpected number of entries: want 2, got %d", n)
+	}
+}
@@ -2,31 +2,206 @@
 package plugin_simplecache
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Config configures the middleware.
 type Config struct {
-	Path            string `json:"path" yaml:"path" toml:"path"`
-	MaxExpiry       int    `json:"maxExpiry" yaml:"maxExpiry" toml:"maxExpiry"`
-	Cleanup         int    `json:"cleanup" yaml:"cleanup" toml:"cleanup"`
-	AddStatusHeader bool   `json:"addStatusHeader" yaml:"addStatusHeader" toml:"addStatusHeader"`
+	Backend          string   `json:"backend" yaml:"backend" toml:"backend"`
+	Path             string   `json:"path" yaml:"path" toml:"path"`
+	Hosts            []string `json:"hosts" yaml:"hosts" toml:"hosts"`
+	MaxExpiry        int      `json:"maxExpiry" yaml:"maxExpiry" toml:"maxExpiry"`
+	MaxServeAge      int      `json:"maxServeAge" yaml:"maxServeAge" toml:"maxServeAge"`
+	Cleanup          int      `json:"cleanup" yaml:"cleanup" toml:"cleanup"`
+	CleanupBatchSize int      `json:"cleanupBatchSize" yaml:"cleanupBatchSize" toml:"cleanupBatchSize"`
+	PerHostMaxBytes  int      `json:"perHostMaxBytes" yaml:"perHostMaxBytes" toml:"perHostMaxBytes"`
+	MemoryMaxBytes   int      `json:"memoryMaxBytes" yaml:"memoryMaxBytes" toml:"memoryMaxBytes"`
+	MinFreeDiskBytes int      `json:"minFreeDiskBytes" yaml:"minFreeDiskBytes" toml:"minFreeDiskBytes"`
+	EvictOnLowDisk   bool     `json:"evictOnLowDisk" yaml:"evictOnLowDisk" toml:"evictOnLowDisk"`
+	CacheMethods     []string `json:"cacheMethods" yaml:"cacheMethods" toml:"cacheMethods"`
+	BodyHashKey      bool     `json:"bodyHashKey" yaml:"bodyHashKey" toml:"bodyHashKey"`
+	POSTKeyFields    []string `json:"postKeyFields" yaml:"postKeyFields" toml:"postKeyFields"`
+	POSTKeyPaths     []string `json:"postKeyPaths" yaml:"postKeyPaths" toml:"postKeyPaths"`
+	GETBodyPolicy    string   `json:"getBodyPolicy" yaml:"getBodyPolicy" toml:"getBodyPolicy"`
+
+	BypassOnAuthHeader bool     `json:"bypassOnAuthHeader" yaml:"bypassOnAuthHeader" toml:"bypassOnAuthHeader"`
+	BypassCookies      []string `json:"bypassCookies" yaml:"bypassCookies" toml:"bypassCookies"`
+	AuthScopeClaim     string   `json:"authScopeClaim" yaml:"authScopeClaim" toml:"authScopeClaim"`
+
+	SkipCacheWithCookies bool `json:"skipCacheWithCookies" yaml:"skipCacheWithCookies" toml:"skipCacheWithCookies"`
+
+	HitDelayMs  int  `json:"hitDelayMs" yaml:"hitDelayMs" toml:"hitDelayMs"`
+	DebugHeader bool `json:"debugHeader" yaml:"debugHeader" toml:"debugHeader"`
+	HeadFromGet bool `json:"headFromGet" yaml:"headFromGet" toml:"headFromGet"`
+
+	CacheStatuses []int          `json:"cacheStatuses" yaml:"cacheStatuses" toml:"cacheStatuses"`
+	StatusTTLs    map[string]int `json:"statusTTLs" yaml:"statusTTLs" toml:"statusTTLs"`
+	SizeTTLRules  []SizeTTLRule  `json:"sizeTTLRules" yaml:"sizeTTLRules" toml:"sizeTTLRules"`
+	// CachePermanentRedirects caches 301 and 308 responses the origin gave
+	// no freshness for RedirectTTL seconds, maxExpiry by default.
+	CachePermanentRedirects bool `json:"cachePermanentRedirects" yaml:"cachePermanentRedirects" toml:"cachePermanentRedirects"`
+	RedirectTTL             int  `json:"redirectTTL" yaml:"redirectTTL" toml:"redirectTTL"`
+	// ContentTypeTTLs gives responses cached by default the TTL in seconds of
+	// their content type.
+	ContentTypeTTLs map[string]int `json:"contentTypeTTLs" yaml:"contentTypeTTLs" toml:"contentTypeTTLs"`
+	// HeuristicMaxTTL caps, in seconds, the TTL of responses cached by
+	// default rather than by a directive.
+	HeuristicMaxTTL int `json:"heuristicMaxTTL" yaml:"heuristicMaxTTL" toml:"heuristicMaxTTL"`
+
+	AddStatusHeader       bool     `json:"addStatusHeader" yaml:"addStatusHeader" toml:"addStatusHeader"`
+	StatusHeaderMode      string   `json:"statusHeaderMode" yaml:"statusHeaderMode" toml:"statusHeaderMode"`
+	StatusHeaderOnHitOnly bool     `json:"statusHeaderOnHitOnly" yaml:"statusHeaderOnHitOnly" toml:"statusHeaderOnHitOnly"`
+	StatusHeaderFormat    string   `json:"statusHeaderFormat" yaml:"statusHeaderFormat" toml:"statusHeaderFormat"`
+	StatusHeaderName      string   `json:"statusHeaderName" yaml:"statusHeaderName" toml:"statusHeaderName"`
+	VaryMode              string   `json:"varyMode" yaml:"varyMode" toml:"varyMode"`
+	VaryCookies           []string `json:"varyCookies" yaml:"varyCookies" toml:"varyCookies"`
+	VaryBlocklist         []string `json:"varyBlocklist" yaml:"varyBlocklist" toml:"varyBlocklist"`
+	VaryBlockPolicy       string   `json:"varyBlockPolicy" yaml:"varyBlockPolicy" toml:"varyBlockPolicy"`
+	MaxVariantsPerKey     int      `json:"maxVariantsPerKey" yaml:"maxVariantsPerKey" toml:"maxVariantsPerKey"`
+	VariantOverflowPolicy string   `json:"variantOverflowPolicy" yaml:"variantOverflowPolicy" toml:"variantOverflowPolicy"`
+	FileMode              string   `json:"fileMode" yaml:"fileMode" toml:"fileMode"`
+	DirMode               string   `json:"dirMode" yaml:"dirMode" toml:"dirMode"`
+
+	Prefetch            []PrefetchRule `json:"prefetch" yaml:"prefetch" toml:"prefetch"`
+	PrefetchConcurrency int            `json:"prefetchConcurrency" yaml:"prefetchConcurrency" toml:"prefetchConcurrency"`
+	RefreshAheadWindow  int            `json:"refreshAheadWindow" yaml:"refreshAheadWindow" toml:"refreshAheadWindow"`
+
+	RawQueryKey       bool       `json:"rawQueryKey" yaml:"rawQueryKey" toml:"rawQueryKey"`
+	IncludeScheme     bool       `json:"includeScheme" yaml:"includeScheme" toml:"includeScheme"`
+	KeySources        []string   `json:"keySources" yaml:"keySources" toml:"keySources"`
+	StripMatrixParams []string   `json:"stripMatrixParams" yaml:"stripMatrixParams" toml:"stripMatrixParams"`
+	PathRules         []PathRule `json:"pathRules" yaml:"pathRules" toml:"pathRules"`
+
+	CanonicalURLKey       bool `json:"canonicalURLKey" yaml:"canonicalURLKey" toml:"canonicalURLKey"`
+	CanonicalMergeSlashes bool `json:"canonicalMergeSlashes" yaml:"canonicalMergeSlashes" toml:"canonicalMergeSlashes"`
+
+	MaxQueryParams            int    `json:"maxQueryParams" yaml:"maxQueryParams" toml:"maxQueryParams"`
+	QueryParamsOverflowPolicy string `json:"queryParamsOverflowPolicy" yaml:"queryParamsOverflowPolicy" toml:"queryParamsOverflowPolicy"`
+
+	BucketCookie string `json:"bucketCookie" yaml:"bucketCookie" toml:"bucketCookie"`
+	BucketHeader string `json:"bucketHeader" yaml:"bucketHeader" toml:"bucketHeader"`
+
+	VaryAcceptLanguage bool         `json:"varyAcceptLanguage" yaml:"varyAcceptLanguage" toml:"varyAcceptLanguage"`
+	VaryOrigin         bool         `json:"varyOrigin" yaml:"varyOrigin" toml:"varyOrigin"`
+	VaryByDevice       bool         `json:"varyByDevice" yaml:"varyByDevice" toml:"varyByDevice"`
+	DeviceRules        []DeviceRule `json:"deviceRules" yaml:"deviceRules" toml:"deviceRules"`
+	ServedTypes        []string     `json:"servedTypes" yaml:"servedTypes" toml:"servedTypes"`
+
+	Compress         bool   `json:"compress" yaml:"compress" toml:"compress"`
+	CompressMinBytes int    `json:"compressMinBytes" yaml:"compressMinBytes" toml:"compressMinBytes"`
+	CompressionAlgo  string `json:"compressionAlgo" yaml:"compressionAlgo" toml:"compressionAlgo"`
+
+	MaxOriginConcurrency int `json:"maxOriginConcurrency" yaml:"maxOriginConcurrency" toml:"maxOriginConcurrency"`
+	OriginQueueTimeoutMs int `json:"originQueueTimeoutMs" yaml:"originQueueTimeoutMs" toml:"originQueueTimeoutMs"`
+	StoreTimeoutMs       int `json:"storeTimeoutMs" yaml:"storeTimeoutMs" toml:"storeTimeoutMs"`
+
+	MaxBufferMemory int    `json:"maxBufferMemory" yaml:"maxBufferMemory" toml:"maxBufferMemory"`
+	MetricsPath     string `json:"metricsPath" yaml:"metricsPath" toml:"metricsPath"`
+	PersistMetrics  bool   `json:"persistMetrics" yaml:"persistMetrics" toml:"persistMetrics"`
+	PurgePath       string `json:"purgePath" yaml:"purgePath" toml:"purgePath"`
+	CleanupPath     string `json:"cleanupPath" yaml:"cleanupPath" toml:"cleanupPath"`
+	DebugPath       string `json:"debugPath" yaml:"debugPath" toml:"debugPath"`
+	ExportPath      string `json:"exportPath" yaml:"exportPath" toml:"exportPath"`
+	ImportPath      string `json:"importPath" yaml:"importPath" toml:"importPath"`
+	ImportMaxBytes  int    `json:"importMaxBytes" yaml:"importMaxBytes" toml:"importMaxBytes"`
+	EventWebhook    string `json:"eventWebhook" yaml:"eventWebhook" toml:"eventWebhook"`
+	// InvalidationSecret protects the purge, cleanup, export and import
+	// paths, see authorizeAdmin.
+	InvalidationSecret string `json:"invalidationSecret" yaml:"invalidationSecret" toml:"invalidationSecret"`
+
+	TrustOriginCacheHeader bool   `json:"trustOriginCacheHeader" yaml:"trustOriginCacheHeader" toml:"trustOriginCacheHeader"`
+	OriginCacheHeader      string `json:"originCacheHeader" yaml:"originCacheHeader" toml:"originCacheHeader"`
+
+	TTLHeader       string `json:"ttlHeader" yaml:"ttlHeader" toml:"ttlHeader"`
+	TTLHeaderSecret string `json:"ttlHeaderSecret" yaml:"ttlHeaderSecret" toml:"ttlHeaderSecret"`
+
+	InvalidationHeader string `json:"invalidationHeader" yaml:"invalidationHeader" toml:"invalidationHeader"`
+
+	RequireHeader      string `json:"requireHeader" yaml:"requireHeader" toml:"requireHeader"`
+	RequireHeaderValue string `json:"requireHeaderValue" yaml:"requireHeaderValue" toml:"requireHeaderValue"`
+
+	BodyReplacements     []BodyReplacement `json:"bodyReplacements" yaml:"bodyReplacements" toml:"bodyReplacements"`
+	BodyReplacementTypes []string          `json:"bodyReplacementTypes" yaml:"bodyReplacementTypes" toml:"bodyReplacementTypes"`
+	StripResponseHeaders []string          `json:"stripResponseHeaders" yaml:"stripResponseHeaders" toml:"stripResponseHeaders"`
+
+	OnErrorBehavior string   `json:"onErrorBehavior" yaml:"onErrorBehavior" toml:"onErrorBehavior"`
+	FallbackPaths   []string `json:"fallbackPaths" yaml:"fallbackPaths" toml:"fallbackPaths"`
+	FallbackStatus  int      `json:"fallbackStatus" yaml:"fallbackStatus" toml:"fallbackStatus"`
+	FallbackBody    string   `json:"fallbackBody" yaml:"fallbackBody" toml:"fallbackBody"`
+
+	StaleIfError    int   `json:"staleIfError" yaml:"staleIfError" toml:"staleIfError"`
+	StaleStatus     int   `json:"staleStatus" yaml:"staleStatus" toml:"staleStatus"`
+	StaleOnStatuses []int `json:"staleOnStatuses" yaml:"staleOnStatuses" toml:"staleOnStatuses"`
+
+	PlaceholderPaths      []string `json:"placeholderPaths" yaml:"placeholderPaths" toml:"placeholderPaths"`
+	PlaceholderStatus     int      `json:"placeholderStatus" yaml:"placeholderStatus" toml:"placeholderStatus"`
+	PlaceholderBody       string   `json:"placeholderBody" yaml:"placeholderBody" toml:"placeholderBody"`
+	PlaceholderRetryAfter int      `json:"placeholderRetryAfter" yaml:"placeholderRetryAfter" toml:"placeholderRetryAfter"`
+
+	FallbackOrigin        string `json:"fallbackOrigin" yaml:"fallbackOrigin" toml:"fallbackOrigin"`
+	FallbackOriginTimeout int    `json:"fallbackOriginTimeout" yaml:"fallbackOriginTimeout" toml:"fallbackOriginTimeout"`
+
+	OfflineResponseFile string `json:"offlineResponseFile" yaml:"offlineResponseFile" toml:"offlineResponseFile"`
+	OfflineStatus       int    `json:"offlineStatus" yaml:"offlineStatus" toml:"offlineStatus"`
+	OfflineContentType  string `json:"offlineContentType" yaml:"offlineContentType" toml:"offlineContentType"`
+
+	// ClientCacheControl replaces the Cache-Control header of the responses
+	// served from or stored in the cache, leaving their TTL alone.
+	ClientCacheControl string `json:"clientCacheControl" yaml:"clientCacheControl" toml:"clientCacheControl"`
 }
 
 // CreateConfig returns a config instance.
 func CreateConfig() *Config {
 	return &Config{
-		MaxExpiry:       int((5 * time.Minute).Seconds()),
-		Cleanup:         int((5 * time.Minute).Seconds()),
-		AddStatusHeader: true,
+		Backend:          backendFile,
+		MaxExpiry:        int((5 * time.Minute).Seconds()),
+		Cleanup:          int((5 * time.Minute).Seconds()),
+		CacheMethods:     []string{http.MethodGet, http.MethodHead},
+		AddStatusHeader:  true,
+		StatusHeaderMode: statusHeaderAlways,
+		VaryMode:         varyModeBypass,
+		VaryBlockPolicy:  varyBlockBypass,
+		GETBodyPolicy:    getBodyBypass,
+		FileMode:         "0600",
+		DirMode:          "0700",
+
+		StatusHeaderFormat: statusFormatSimple,
+		StatusHeaderName:   defaultStatusHeaderName,
+
+		PrefetchConcurrency: 4,
+
+		MaxQueryParams:            defaultMaxQueryParams,
+		QueryParamsOverflowPolicy: queryOverflowBypass,
+
+		CompressMinBytes: defaultCompressMinBytes,
+
+		OriginCacheHeader: defaultOriginCacheHeader,
+
+		BodyReplacementTypes: []string{"text/html"},
+
+		OnErrorBehavior: onErrorPassthrough,
+		FallbackStatus:  http.StatusServiceUnavailable,
+
+		PlaceholderStatus:     http.StatusAccepted,
+		PlaceholderRetryAfter: 5,
 	}
 }
 
@@ -37,11 +212,37 @@ const (
 	cacheErrorStatus = "error"
 )
 
+var defaultCacheMethods = []string{http.MethodGet, http.MethodHead}
+
+const (
+	statusHeaderAlways      = "always"
+	statusHeaderManagedOnly = "managed-only"
+)
+
 type cache struct {
 	name  string
-	cache *fileCache
+	cache store
 	cfg   *Config
 	next  http.Handler
+
+	inflight *inflight
+	// coldWarms holds the placeholder keys whose last warm stored nothing.
+	coldWarms *keySet
+	origin    *originLimiter
+	warmSem   chan struct{}
+	budget    *bufferBudget
+	metrics   *metrics
+	events    eventSink
+	fallback  *fallbackOrigin
+	offline   *offlinePage
+
+	statusTTLs      map[int]time.Duration
+	contentTypeTTLs map[string]time.Duration
+	keySources      []keySource
+	deviceRules     []DeviceRule
+
+	// bg tracks background fetches.
+	bg sync.WaitGroup
 }
 
 // New returns a plugin instance.
@@ -54,147 +255,1539 @@ func New(_ context.Context, next http.Handler, cfg *Config, name string) (http.H
 		return nil, errors.New("cleanup must be greater or equal to 1")
 	}
 
-	fc, err := newFileCache(cfg.Path, time.Duration(cfg.Cleanup)*time.Second)
+	if cfg.MaxServeAge < 0 {
+		return nil, errors.New("maxServeAge must be greater or equal to 0")
+	}
+
+	if cfg.HitDelayMs < 0 {
+		return nil, errors.New("hitDelayMs must be greater or equal to 0")
+	}
+
+	if cfg.StoreTimeoutMs < 0 {
+		return nil, errors.New("storeTimeoutMs must be greater or equal to 0")
+	}
+
+	if cfg.HeuristicMaxTTL < 0 {
+		return nil, errors.New("heuristicMaxTTL must be greater or equal to 0")
+	}
+
+	if cfg.CleanupBatchSize < 0 {
+		return nil, errors.New("cleanupBatchSize must be greater or equal to 0")
+	}
+
+	if cfg.StaleIfError < 0 {
+		return nil, errors.New("staleIfError must be greater or equal to 0")
+	}
+
+	if cfg.StaleStatus != 0 && (cfg.StaleStatus < 100 || cfg.StaleStatus > 599) {
+		return nil, fmt.Errorf("invalid staleStatus: invalid status code %d", cfg.StaleStatus)
+	}
+
+	for _, status := range cfg.StaleOnStatuses {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid staleOnStatuses: invalid status code %d", status)
+		}
+	}
+
+	if cfg.CompressMinBytes < 0 {
+		return nil, errors.New("compressMinBytes must be greater or equal to 0")
+	}
+
+	if err := validateCompressionAlgo(cfg.CompressionAlgo); err != nil {
+		return nil, err
+	}
+
+	if cfg.PerHostMaxBytes < 0 {
+		return nil, errors.New("perHostMaxBytes must be greater or equal to 0")
+	}
+
+	if cfg.MemoryMaxBytes < 0 {
+		return nil, errors.New("memoryMaxBytes must be greater or equal to 0")
+	}
+
+	if cfg.MinFreeDiskBytes < 0 {
+		return nil, errors.New("minFreeDiskBytes must be greater or equal to 0")
+	}
+
+	switch cfg.StatusHeaderMode {
+	case "", statusHeaderAlways, statusHeaderManagedOnly:
+	default:
+		return nil, fmt.Errorf("invalid statusHeaderMode %q", cfg.StatusHeaderMode)
+	}
+
+	switch cfg.VaryMode {
+	case "", varyModeIgnore, varyModeBypass, varyModeKey:
+	default:
+		return nil, fmt.Errorf("invalid varyMode %q", cfg.VaryMode)
+	}
+
+	switch cfg.VaryBlockPolicy {
+	case "", varyBlockBypass, varyBlockIgnore:
+	default:
+		return nil, fmt.Errorf("invalid varyBlockPolicy %q", cfg.VaryBlockPolicy)
+	}
+
+	if err := validateVariantLimit(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateQueryLimit(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateBucket(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateStatusHeader(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateRedirectTTL(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateClientCacheControl(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxBufferMemory < 0 {
+		return nil, errors.New("maxBufferMemory must be greater or equal to 0")
+	}
+
+	for i, rep := range cfg.BodyReplacements {
+		if rep.From == "" {
+			return nil, fmt.Errorf("body replacement %d: from is required", i)
+		}
+	}
+
+	// Unlike purges, exports and imports expose and replace the cached
+	// responses themselves.
+	if (cfg.ExportPath != "" || cfg.ImportPath != "") && cfg.InvalidationSecret == "" {
+		return nil, errors.New("exportPath and importPath require invalidationSecret")
+	}
+
+	if cfg.ImportMaxBytes < 0 {
+		return nil, errors.New("importMaxBytes must be greater or equal to 0")
+	}
+
+	if err := validateEventWebhook(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validatePathRules(cfg.PathRules); err != nil {
+		return nil, fmt.Errorf("invalid pathRules: %w", err)
+	}
+
+	// The boundary replaces the computed TTL, so it must fit in maxExpiry,
+	// and the age ceiling would stop serving entries before it.
+	for i, rule := range cfg.PathRules {
+		if rule.ExpireEvery+rule.ExpireJitter > cfg.MaxExpiry {
+			return nil, fmt.Errorf("invalid pathRules: rule %d: expireEvery plus expireJitter must be less or equal to maxExpiry", i)
+		}
+
+		if cfg.MaxServeAge > 0 && rule.ExpireEvery+rule.ExpireJitter > cfg.MaxServeAge {
+			return nil, fmt.Errorf("invalid pathRules: rule %d: expireEvery plus expireJitter must be less or equal to maxServeAge", i)
+		}
+	}
+
+	if err := validateHosts(cfg.Hosts); err != nil {
+		return nil, fmt.Errorf("invalid hosts: %w", err)
+	}
+
+	if err := validateFallback(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validatePlaceholder(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateRefreshAhead(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateOriginLimit(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateCacheMethods(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validatePrefetch(cfg); err != nil {
+		return nil, err
+	}
+
+	for _, typ := range cfg.ServedTypes {
+		if i := strings.Index(typ, "/"); i <= 0 || i == len(typ)-1 {
+			return nil, fmt.Errorf("invalid servedTypes: invalid media type %q", typ)
+		}
+	}
+
+	for _, status := range cfg.CacheStatuses {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid cacheStatuses: invalid status code %d", status)
+		}
+	}
+
+	statusTTLs, err := parseStatusTTLs(cfg.StatusTTLs, cfg.MaxExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statusTTLs: %w", err)
+	}
+
+	contentTypeTTLs, err := parseContentTypeTTLs(cfg.ContentTypeTTLs, cfg.MaxExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contentTypeTTLs: %w", err)
+	}
+
+	keySources, err := parseKeySources(cfg.KeySources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keySources: %w", err)
+	}
+
+	deviceRules, err := parseDeviceRules(cfg.DeviceRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deviceRules: %w", err)
+	}
+
+	if err := validateSizeTTLRules(cfg.SizeTTLRules, cfg.MaxExpiry); err != nil {
+		return nil, fmt.Errorf("invalid sizeTTLRules: %w", err)
+	}
+
+	fileMode, err := parseFileMode(cfg.FileMode, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fileMode: %w", err)
+	}
+
+	dirMode, err := parseFileMode(cfg.DirMode, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dirMode: %w", err)
+	}
+
+	fallback, err := newFallbackOrigin(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	offline, err := newOfflinePage(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	var (
+		st   store
+		path string
+	)
+
+	vacuum := time.Duration(cfg.Cleanup) * time.Second
+
+	switch cfg.Backend {
+	case "", backendFile:
+		if cfg.MemoryMaxBytes > 0 {
+			return nil, errors.New("memoryMaxBytes requires the memory backend")
+		}
+
+		path = cfg.Path
+		if path == "" {
+			// Never fall back to the working directory.
+			if path, err = defaultPath(name, dirMode); err != nil {
+				return nil, err
+			}
+		}
+
+		fc, err := newFileCache(path, vacuum, cfg.CleanupBatchSize, fileMode, dirMode)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.MinFreeDiskBytes > 0 {
+			if diskFree == nil {
+				return nil, errors.New("invalid minFreeDiskBytes: free disk space unavailable on this platform")
+			}
+
+			if _, err = diskFree(path); err != nil {
+				return nil, fmt.Errorf("invalid minFreeDiskBytes: %w", err)
+			}
+
+			fc.guard = &diskGuard{minFree: uint64(cfg.MinFreeDiskBytes), evict: cfg.EvictOnLowDisk, free: diskFree}
+		}
+
+		st = fc
+	case backendMemory:
+		if cfg.PersistMetrics {
+			return nil, errors.New("persistMetrics requires the file backend")
+		}
+
+		st = newMemoryCache(vacuum, cfg.CleanupBatchSize, cfg.MemoryMaxBytes)
+	default:
+		return nil, fmt.Errorf("invalid backend: unknown backend %q", cfg.Backend)
+	}
+
 	m := &cache{
-		name:  name,
-		cache: fc,
-		cfg:   cfg,
-		next:  next,
+		name:      name,
+		cache:     st,
+		cfg:       cfg,
+		next:      next,
+		inflight:  &inflight{keys: map[string]struct{}{}},
+		coldWarms: &keySet{keys: map[string]struct{}{}, max: maxColdWarms},
+		origin:    newOriginLimiter(cfg.MaxOriginConcurrency, cfg.OriginQueueTimeoutMs),
+		warmSem:   make(chan struct{}, cfg.PrefetchConcurrency),
+		budget:    &bufferBudget{limit: int64(cfg.MaxBufferMemory)},
+		metrics:   &metrics{},
+		events:    nopSink{},
+		fallback:  fallback,
+		offline:   offline,
+
+		statusTTLs:      statusTTLs,
+		contentTypeTTLs: contentTypeTTLs,
+		keySources:      keySources,
+		deviceRules:     deviceRules,
+	}
+
+	if cfg.EventWebhook != "" {
+		m.events = newWebhookSink(cfg.EventWebhook)
+	}
+
+	st.OnEvict(func(key string, size int) {
+		atomic.AddUint64(&m.metrics.evictions, 1)
+		m.events.OnEvict(newEvent(eventEvict, key, size, time.Now()))
+	})
+
+	if cfg.PersistMetrics {
+		file := metricsFile(path, name)
+		m.metrics.load(file)
+
+		go m.metrics.persist(file, fileMode, metricsPersistInterval)
 	}
 
 	return m, nil
 }
 
+// parseFileMode parses an octal permission string such as "0600", returning
+// def when s is empty.
+func parseFileMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	if mode > 0777 {
+		return 0, fmt.Errorf("%q is not a permission mode", s)
+	}
+
+	return os.FileMode(mode), nil
+}
+
 type cacheData struct {
+	// Status is the status code of the response. Its reason phrase isn't
+	// stored: net/http gives handlers no way to read or set it, and always
+	// sends the standard one, or none for unknown codes.
 	Status  int
 	Headers map[string][]string
 	Body    []byte
+
+	// Vary is only set on the marker stored under the primary key of a
+	// response that is stored per variant.
+	Vary []string `json:",omitempty"`
+
+	// ResponseTime is when the response was received and InitialAge its
+	// age at that time.
+	ResponseTime time.Time
+	InitialAge   time.Duration
+
+	// BodyEncoding is the encoding Body is stored with, if compressed.
+	BodyEncoding string `json:",omitempty"`
+
+	// Expires is set when the entry is kept past its expiry to be served
+	// stale, see staleIfError.
+	Expires time.Time `json:",omitempty"`
+
+	// key is the key the entry was read from, that of its variant if any.
+	key string
 }
 
 // ServeHTTP serves an HTTP request.
 func (m *cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.serveAdmin(w, r) {
+		return
+	}
+
+	r, status := m.overrideTTL(r)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if reason := m.bypass(r); reason != "" {
+		m.debugReason(w, r, reason)
+		m.fetch(w, r, "", cacheMissStatus)
+		return
+	}
+
 	cs := cacheMissStatus
 
-	key := cacheKey(r)
-
-	b, err := m.cache.Get(key)
-	if err == nil {
-		var data cacheData
-
-		if err := json.Unmarshal(b, &data); err != nil {
-			log.Printf("Error unmarshaling cache data: %v", err)
-			cs = cacheErrorStatus
-		} else {
-			// Restore headers from cache
-			for key, vals := range data.Headers {
-				for _, val := range vals {
-					w.Header().Add(key, val)
-				}
-			}
-			if m.cfg.AddStatusHeader {
-				w.Header().Set(cacheHeader, cacheHitStatus)
-			}
-			w.WriteHeader(data.Status)
-			if _, err := w.Write(data.Body); err != nil {
-				log.Printf("Error writing cached response body: %v", err)
-			}
+	key := m.cacheKey(r)
+
+	// Responses to unsafe methods depend on the request body, and so may
+	// those to safe methods sent with one.
+	keyBody := !safeMethod(r.Method)
+	if !keyBody && hasBody(r) {
+		if m.cfg.GETBodyPolicy != getBodyKey {
+			m.debugReason(w, r, "request body with method "+r.Method)
+			m.fetch(w, r, "", cacheMissStatus)
 			return
 		}
+
+		keyBody = true
 	}
 
-	if m.cfg.AddStatusHeader {
-		w.Header().Set(cacheHeader, cs)
+	if keyBody {
+		bodyKey, ok := m.bodyKey(r)
+		if !ok {
+			m.debugReason(w, r, "request body too large")
+			m.fetch(w, r, "", cacheMissStatus)
+			return
+		}
+
+		key += "|" + bodyKey
 	}
 
-	rw := &responseWriter{ResponseWriter: w}
-	m.next.ServeHTTP(rw, r)
+	start := time.Now()
 
-	expiry, ok := m.cacheable(r, w, rw.status)
-	if !ok {
-		return
+	data, err := m.lookup(key, r)
+	if errors.Is(err, errCacheMiss) && m.headFromGet(r) {
+		data, err = m.lookup(m.getKey(r), r)
 	}
 
-	data := cacheData{
-		Status:  rw.status,
-		Headers: w.Header(),
-		Body:    rw.body,
+	// A stale entry is kept to be served if the origin fails.
+	var stale *cacheData
+	if errors.Is(err, errStale) {
+		stale = data
 	}
 
-	b, err = json.Marshal(data)
-	if err != nil {
-		log.Printf("Error serializing cache item: %v", err)
+	switch {
+	case err == nil:
+		atomic.AddUint64(&m.metrics.hits, 1)
+		m.events.OnHit(newEvent(eventHit, key, len(data.Body), start))
+		m.debugHits(w, r, m.cache.Hit(data.key))
+		m.refreshAhead(r, key, data)
+		if !m.hitDelay(r) {
+			return
+		}
+		m.serveCached(w, r, data)
 		return
+	case !errors.Is(err, errCacheMiss):
+		log.Printf("Error reading cache item: %v", err)
+		atomic.AddUint64(&m.metrics.errors, 1)
+		m.events.OnError(newEvent(eventError, key, 0, start))
+		if m.serveFallback(w, r) {
+			return
+		}
+		cs = cacheErrorStatus
+	default:
+		atomic.AddUint64(&m.metrics.misses, 1)
+		m.events.OnMiss(newEvent(eventMiss, key, 0, start))
+		if stale == nil && m.servePlaceholder(w, r, key) {
+			return
+		}
 	}
 
-	if err = m.cache.Set(key, b, expiry); err != nil {
-		log.Printf("Error setting cache item: %v", err)
+	if !m.origin.Acquire(r.Context()) {
+		m.serveOriginBusy(w, r, stale)
+		return
 	}
-}
+	defer m.origin.Release()
 
-func (m *cache) cacheable(r *http.Request, w http.ResponseWriter, status int) (time.Duration, bool) {
-	// Don't cache error responses
-	if status < 200 || status >= 400 {
-		return 0, false
+	if m.inflight.Acquire(key) {
+		defer m.inflight.Release(key)
 	}
 
-	// Instead of checking cache headers, always cache for maxExpiry duration
-	maxExpiry := time.Duration(m.cfg.MaxExpiry) * time.Second
-	return maxExpiry, true
+	if m.fetchFrom(m.next, m.fallback, w, r, key, cs, stale) {
+		m.coldWarms.Delete(key)
+		m.prefetch(r)
+	}
+}
 
+// fetch serves the request from next and stores the response under key if it
+// is cacheable, reporting whether it was stored. An empty key means the
+// response must not be stored.
+func (m *cache) fetch(w http.ResponseWriter, r *http.Request, key, cs string) bool {
+	return m.fetchFrom(m.next, m.fallback, w, r, key, cs, nil)
 }
 
-func cacheKey(r *http.Request) string {
-	// Base key with method, host and path
-	key := r.Method + r.Host + r.URL.Path
+// fetchFrom serves the request from next as fetch does, retrying it on the
+// fallback origin, if any, when next fails, then serving the stale entry, if
+// any.
+func (m *cache) fetchFrom(next http.Handler, fallback *fallbackOrigin, w http.ResponseWriter, r *http.Request, key, cs string, stale *cacheData) bool {
+	rw := &responseWriter{ResponseWriter: w, budget: m.budget, client: r.Context()}
+	defer rw.release()
+
+	// The origin request is cancelled along with the client's, unless the
+	// response is being stored, see detach.
+	origin, stop := r, func() {}
+	if key != "" {
+		origin, stop = m.originRequest(r, rw)
+	}
+	defer stop()
+
+	requestTime := time.Now()
 
-	// Handle query parameters in a sorted, consistent way
-	if len(r.URL.Query()) > 0 {
-		// Get all query parameter keys
-		params := make([]string, 0, len(r.URL.Query()))
-		for param := range r.URL.Query() {
-			params = append(params, param)
+	var responseTime time.Time
+
+	rw.onHeader = func(status int) {
+		responseTime = time.Now()
+
+		if m.cfg.InvalidationHeader != "" {
+			m.invalidate(r, w.Header())
 		}
 
-		// Sort the parameter keys
-		sort.Strings(params)
+		// Hold the failed response back, it is only sent if the fallback
+		// origin fails too and there is neither a stale entry nor an offline
+		// page to serve.
+		if key != "" && (fallback.fails(r, status) || m.failsStale(stale, status) || m.failsOffline(stale, status)) {
+			rw.failed = true
+			rw.hold = true
+			return
+		}
 
-		var queryParts []string
-		for _, param := range params {
-			values := r.URL.Query()[param]
-			sort.Strings(values)
+		if key != "" {
+			var reason string
+			rw.expiry, rw.defaultExpiry, reason = m.cacheDecision(r, w, status)
 
-			for _, value := range values {
-				queryParts = append(queryParts, url.QueryEscape(param)+"="+url.QueryEscape(value))
+			// A response already past the age ceiling would never be
+			// served, only rewritten on every request.
+			if ceiling := m.maxServeAge(); reason == "" && ceiling > 0 && correctedInitialAge(w.Header(), requestTime, responseTime) > ceiling {
+				reason = "older than maxServeAge"
 			}
+			rw.cacheable = reason == ""
+			if rw.cacheable {
+				rw.detach()
+			}
+			m.debugReason(w, r, reason)
 		}
 
-		// Join all parameters with &
-		key += "?" + strings.Join(queryParts, "&")
-	}
+		if m.cfg.TrustOriginCacheHeader {
+			w.Header().Del(m.originCacheHeader())
+		}
 
-	return key
-}
+		if m.cfg.RequireHeader != "" {
+			w.Header().Del(m.cfg.RequireHeader)
+		}
 
-type responseWriter struct {
-	http.ResponseWriter
-	status int
-	body   []byte
-}
+		// The TTL is already decided, the client only gets the override.
+		if rw.cacheable && m.cfg.ClientCacheControl != "" {
+			rw.cacheControl = m.setClientCacheControl(w.Header())
+		}
+
+		if m.addStatusHeader(cs, rw.cacheable) {
+			member := statusMember{fwd: fwdReason(key, stale), fwdStatus: status, stored: rw.cacheable, key: key}
+			if cs == cacheErrorStatus {
+				member.detail = cacheErrorStatus
+			}
+
+			m.setStatusHeader(w, r, cs, member)
+		}
+
+		// Responses to transform are held back until the whole body is
+		// available.
+		rw.hold = rw.cacheable && m.transformable(w.Header())
+	}
+
+	next.ServeHTTP(rw, origin)
+
+	// Nothing more can be written to a hijacked connection.
+	if rw.hijacked {
+		return false
+	}
+
+	// A response cut off by detachedFetchTimeout must not be stored as
+	// complete.
+	if origin.Context().Err() != nil {
+		rw.cacheable = false
+	}
+
+	// The handler may return without writing anything, in which case
+	// net/http sends an implicit 200.
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.failed {
+		if fallback == nil {
+			m.serveFailed(rw, w, r, stale)
+			return false
+		}
+
+		return m.fetchFallback(fallback, rw, w, r, key, cs, stale)
+	}
+
+	// A body of another length than declared was cut off, e.g. by the origin
+	// dropping the connection, and must not be stored as complete.
+	if rw.cacheable && r.Method != http.MethodHead && !declaredLength(w.Header(), len(rw.body)) {
+		log.Printf("Not caching %s: body of %d bytes does not match Content-Length %s", r.URL.Path, len(rw.body), w.Header().Get("Content-Length"))
+		rw.cacheable = false
+	}
+
+	if rw.hold {
+		rw.body = m.transform(rw.body)
+		w.Header().Set("Content-Length", strconv.Itoa(len(rw.body)))
+		rw.flushHeld()
+	}
+
+	if !rw.cacheable {
+		return false
+	}
+
+	headers := m.storedHeaders(w.Header())
+	if m.cfg.ClientCacheControl != "" {
+		headers = originCacheControl(headers, rw.cacheControl)
+	}
+
+	data := &cacheData{
+		Status:       rw.status,
+		Headers:      headers,
+		Body:         rw.body,
+		ResponseTime: responseTime,
+		InitialAge:   correctedInitialAge(w.Header(), requestTime, responseTime),
+	}
+
+	expiry := rw.expiry
+	if rw.defaultExpiry {
+		if ttl, ok := m.sizeTTL(len(rw.body)); ok {
+			expiry = ttl
+		}
+
+		// Guessed TTLs are riskier than the ones the origin gave.
+		if limit := time.Duration(m.cfg.HeuristicMaxTTL) * time.Second; limit > 0 && expiry > limit {
+			expiry = limit
+		}
+	}
+
+	if window := m.staleIfError(); window > 0 {
+		data.Expires = responseTime.Add(expiry)
+		expiry += window
+	}
+
+	m.store(m.responseBucketKey(key, r, w.Header()), r, data, expiry)
+
+	return true
+}
+
+var (
+	// detachedFetchTimeout bounds the time a response being stored is still
+	// received for once the client went away.
+	detachedFetchTimeout = 30 * time.Second
+	// maxDetachedBytes bounds the body buffered once the client went away,
+	// e.g. for an event stream that never ends.
+	maxDetachedBytes = 16 << 20
+)
+
+var errClientGone = errors.New("client went away")
+
+// originRequest returns the request to send to the origin for r, with a
+// context cancelled along with the client's, unless onHeader decided to store
+// the response, in which case the origin is given detachedFetchTimeout more to
+// complete it. stop must be called once the origin returned.
+func (m *cache) originRequest(r *http.Request, rw *responseWriter) (*http.Request, func()) {
+	ctx, cancel := context.WithCancel(detachedContext{parent: r.Context()})
+	done := make(chan struct{})
+	timeout := detachedFetchTimeout
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+		}
+
+		if rw.detached() {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+
+			select {
+			case <-done:
+			case <-timer.C:
+			}
+		}
+
+		cancel()
+	}()
+
+	return r.WithContext(ctx), func() {
+		close(done)
+		cancel()
+	}
+}
+
+// detachedContext keeps the values of its parent, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// declaredLength reports whether a body of n bytes has the length declared by
+// the Content-Length header, if any.
+func declaredLength(h http.Header, n int) bool {
+	v := h.Get("Content-Length")
+	if v == "" {
+		return true
+	}
+
+	length, err := strconv.Atoi(v)
+
+	return err != nil || length == n
+}
+
+// hitDelay waits for the configured hit delay, a testing aid, reporting false
+// if the client went away meanwhile.
+func (m *cache) hitDelay(r *http.Request) bool {
+	if m.cfg.HitDelayMs <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(time.Duration(m.cfg.HitDelayMs) * time.Millisecond)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (m *cache) serveCached(w http.ResponseWriter, r *http.Request, data *cacheData) {
+	m.serveEntry(w, r, data, cacheHitStatus)
+}
+
+// serveEntry serves a stored response with the given cache status.
+func (m *cache) serveEntry(w http.ResponseWriter, r *http.Request, data *cacheData, cs string) {
+	// Restore headers from cache, replacing any set before so that e.g. the
+	// stored Content-Type is replayed exactly.
+	for key, vals := range data.Headers {
+		w.Header()[key] = append([]string(nil), vals...)
+	}
+	w.Header().Set("Age", strconv.Itoa(int(currentAge(data, time.Now()).Seconds())))
+	if m.cfg.ClientCacheControl != "" {
+		m.setClientCacheControl(w.Header())
+	}
+	if m.cfg.AddStatusHeader {
+		member := statusMember{hit: cs == cacheHitStatus, entry: data, key: data.key}
+		if cs == cacheStaleStatus {
+			member.fwd = "stale"
+		}
+
+		m.setStatusHeader(w, r, cs, member)
+	}
+	if r.Method == http.MethodHead {
+		// A stored GET response announces the length of its body, as
+		// stored, so with the stored Content-Encoding. Stored HEAD
+		// responses have no body and keep their own Content-Length.
+		if len(data.Body) > 0 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data.Body)))
+		}
+		w.WriteHeader(data.Status)
+		return
+	}
+	w.WriteHeader(data.Status)
+	if _, err := w.Write(data.Body); err != nil {
+		log.Printf("Error writing cached response body: %v", err)
+	}
+}
+
+// lookup returns the cached response for the request, following the variant
+// marker stored under key if the response was stored per variant.
+func (m *cache) lookup(key string, r *http.Request) (*cacheData, error) {
+	data, err := m.get(r.Context(), key)
+	if err == nil && data.Status == 0 && len(data.Vary) > 0 {
+		data, err = m.get(r.Context(), m.variantKey(key, data.Vary, r))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Never serve an encoding the client forbade, which an entry stored
+	// without keying on Accept-Encoding may have.
+	if !acceptsEncodings(r.Header, http.Header(data.Headers)) {
+		return nil, errCacheMiss
+	}
+
+	// Whatever the stored expiry, never serve a response older than the
+	// age ceiling, if any, not even stale.
+	if ceiling := m.maxServeAge(); ceiling > 0 && currentAge(data, time.Now()) > ceiling {
+		return nil, errCacheMiss
+	}
+
+	// Entries are kept past their expiry to be served if the origin fails.
+	if !data.Expires.IsZero() && time.Now().After(data.Expires) {
+		return data, errStale
+	}
+
+	return data, nil
+}
+
+// maxServeAge returns the age above which cached responses are not served,
+// 0 if there is no such ceiling.
+func (m *cache) maxServeAge() time.Duration {
+	return time.Duration(m.cfg.MaxServeAge) * time.Second
+}
+
+func (m *cache) get(ctx context.Context, key string) (*cacheData, error) {
+	b, err := m.storeGet(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := cacheData{key: key}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("error unmarshaling cache data: %w", err)
+	}
+
+	// The response may have been received again, unchanged, since the value
+	// was written.
+	if stat, ok := m.cache.Stat(key); ok && stat.fetch.Received != 0 {
+		data.setFetch(stat.fetch)
+	}
+
+	if err = decompress(&data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (m *cache) store(key string, r *http.Request, data *cacheData, expiry time.Duration) {
+	if m.cfg.VaryMode == varyModeKey {
+		if names := m.variantNames(data.Headers); len(names) > 0 {
+			variant := m.variantKey(key, names, r)
+			if !m.admitVariant(key, variant) {
+				return
+			}
+
+			m.set(key, r, &cacheData{Vary: names}, expiry)
+			key = variant
+		}
+	}
+
+	m.set(key, r, data, expiry)
+}
+
+func (m *cache) set(key string, r *http.Request, data *cacheData, expiry time.Duration) {
+	start := time.Now()
+
+	stored, err := m.compressed(data)
+	if err != nil {
+		log.Printf("Error serializing cache item: %v", err)
+		return
+	}
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		log.Printf("Error serializing cache item: %v", err)
+		return
+	}
+
+	// The response is stored even if the client went away meanwhile.
+	meta := entryMeta{Host: r.Host, Path: r.URL.Path, Status: data.Status, Hash: contentHash(stored), Fetch: data.fetch()}
+	if err = m.storeSet(context.Background(), key, b, expiry, meta); err != nil {
+		// Counted by the disk guard, logging each one would flood the logs.
+		if !errors.Is(err, errLowDiskSpace) {
+			log.Printf("Error setting cache item: %v", err)
+		}
+		m.events.OnError(newEvent(eventError, key, len(b), start))
+		return
+	}
+
+	atomic.AddUint64(&m.metrics.stores, 1)
+	m.events.OnStore(newEvent(eventStore, key, len(b), start))
+
+	// Keep each host within its share of the cache.
+	if m.cfg.PerHostMaxBytes > 0 {
+		m.cache.Trim(r.Host, m.cfg.PerHostMaxBytes)
+	}
+}
+
+// contentHash hashes the stored response, leaving out what changes each time
+// it is received, see entryFetch, so that it is the same for a response
+// received again unchanged.
+func contentHash(data *cacheData) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%q\n", data.Status, data.BodyEncoding, data.Vary)
+
+	names := make([]string, 0, len(data.Headers))
+	for name := range data.Headers {
+		if name != "Date" && name != "Age" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %q\n", name, data.Headers[name])
+	}
+
+	_, _ = h.Write(data.Body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fetch returns what changes each time the response is received.
+func (d *cacheData) fetch() entryFetch {
+	f := entryFetch{InitialAge: int64(d.InitialAge), Date: http.Header(d.Headers).Get("Date")}
+	if !d.ResponseTime.IsZero() {
+		f.Received = d.ResponseTime.UnixNano()
+	}
+	if !d.Expires.IsZero() {
+		f.StaleAt = d.Expires.UnixNano()
+	}
+
+	return f
+}
+
+// setFetch replaces what changes each time the response is received with f.
+func (d *cacheData) setFetch(f entryFetch) {
+	d.ResponseTime = time.Unix(0, f.Received)
+	d.InitialAge = time.Duration(f.InitialAge)
+
+	d.Expires = time.Time{}
+	if f.StaleAt != 0 {
+		d.Expires = time.Unix(0, f.StaleAt)
+	}
+
+	if f.Date != "" && d.Headers != nil {
+		d.Headers["Date"] = []string{f.Date}
+	}
+}
+
+// addStatusHeader reports whether a response served from the origin gets the
+// cache status cs.
+func (m *cache) addStatusHeader(cs string, cacheable bool) bool {
+	if !m.cfg.AddStatusHeader {
+		return false
+	}
+
+	if m.cfg.StatusHeaderOnHitOnly && cs == cacheMissStatus {
+		return false
+	}
+
+	return cacheable || m.cfg.StatusHeaderMode != statusHeaderManagedOnly
+}
+
+func (m *cache) cacheable(r *http.Request, w http.ResponseWriter, status int) (time.Duration, bool) {
+	expiry, _, reason := m.cacheDecision(r, w, status)
+
+	return expiry, reason == ""
+}
+
+// defaultCacheStatuses are the statuses cached by default: those of the
+// heuristically cacheable statuses (RFC 7231 6.1, RFC 7538) that aren't
+// partial or error responses. Temporary redirects such as 302 and 307 aren't
+// cached unless given a TTL.
+var defaultCacheStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// fetchFallback serves the request from the fallback origin after next
+// failed, storing the response if it is cacheable. The failed response is
+// sent instead if the fallback origin can't be reached.
+func (m *cache) fetchFallback(fallback *fallbackOrigin, rw *responseWriter, w http.ResponseWriter, r *http.Request, key, cs string, stale *cacheData) bool {
+	resp, err := fallback.Do(r)
+	if err != nil {
+		log.Printf("Error fetching from fallback origin: %v", err)
+		if m.serveFailed(rw, w, r, stale) {
+			return false
+		}
+		if m.addStatusHeader(cs, false) {
+			m.setStatusHeader(w, r, cs, statusMember{fwd: fwdReason(key, stale), fwdStatus: rw.status, key: key})
+		}
+		rw.flushHeld()
+		return false
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	rw.release()
+
+	// Drop the headers of the failed response.
+	for k := range w.Header() {
+		delete(w.Header(), k)
+	}
+
+	return m.fetchFrom(responseHandler(resp), nil, w, r, key, cs, stale)
+}
+
+// cacheStatus reports whether responses with status may be cached.
+func (m *cache) cacheStatus(status int) bool {
+	if len(m.cfg.CacheStatuses) == 0 {
+		return defaultCacheStatuses[status]
+	}
+
+	for _, s := range m.cfg.CacheStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheDecision returns the expiry of the response and whether it is the
+// default one, or the reason the response is not cacheable.
+func (m *cache) cacheDecision(r *http.Request, w http.ResponseWriter, status int) (time.Duration, bool, string) {
+	// Only cache the allowed statuses, unless their status has an explicit
+	// TTL.
+	statusTTL, explicit := m.statusTTLs[status]
+	if !explicit && !m.cacheStatus(status) && !m.permanentRedirect(status) {
+		return 0, false, fmt.Sprintf("status %d is not cacheable", status)
+	}
+
+	// A partial response only holds part of the resource and must never be
+	// stored under the full resource key.
+	if status == http.StatusPartialContent {
+		return 0, false, "partial content"
+	}
+
+	// Nor does a 304 to a conditional request, which has no body at all,
+	// whatever cacheStatuses or statusTTLs say.
+	if status == http.StatusNotModified {
+		return 0, false, "not modified"
+	}
+
+	if !understoodStatuses[status] && mustUnderstand(w.Header()) {
+		return 0, false, fmt.Sprintf("must-understand with status %d", status)
+	}
+
+	// Entries are encoded as JSON, which would replace invalid bytes and
+	// replay altered headers.
+	if name, ok := invalidHeader(w.Header()); ok {
+		return 0, false, "header " + name + " is not valid UTF-8"
+	}
+
+	if m.cfg.RequireHeader != "" && !m.blessed(w.Header()) {
+		return 0, false, m.cfg.RequireHeader + " header missing"
+	}
+
+	if !m.varyCacheable(w.Header()) {
+		return 0, false, "vary header not cacheable"
+	}
+
+	// A trusted caller's TTL overrides whatever the origin says.
+	if ttl, ok := requestTTL(r); ok {
+		return ttl, false, ""
+	}
+
+	// Instead of checking cache headers, always cache for maxExpiry duration
+	maxExpiry := time.Duration(m.cfg.MaxExpiry) * time.Second
+
+	expiry, ok, byDefault := maxExpiry, true, !explicit
+
+	trusted := m.cfg.TrustOriginCacheHeader && w.Header().Get(m.originCacheHeader()) != ""
+
+	// Only the header addressed to this cache overrides an Expires in the
+	// past.
+	if !trusted && expired(w.Header()) {
+		return 0, false, "expires in the past"
+	}
+
+	switch {
+	case trusted:
+		byDefault = false
+		if expiry, ok = proxyExpiry(w.Header().Get(m.originCacheHeader()), maxExpiry); !ok {
+			return 0, false, m.originCacheHeader() + " forbids caching"
+		}
+	case r.Method == http.MethodOptions:
+		byDefault = false
+		if expiry, ok = preflightExpiry(w.Header(), maxExpiry); !ok {
+			return 0, false, "Access-Control-Max-Age forbids caching"
+		}
+	}
+
+	// An explicit status TTL overrides the computed one, but not a refusal
+	// to cache the response.
+	if explicit {
+		expiry = statusTTL
+	}
+
+	// As do the redirect TTL and the TTL of the content type, for responses
+	// otherwise cached by default.
+	if m.permanentRedirect(status) && byDefault {
+		expiry, byDefault = m.redirectTTL(), false
+	}
+
+	if ttl, ok := m.contentTypeTTL(w.Header().Get("Content-Type")); ok && byDefault {
+		expiry, byDefault = ttl, false
+	}
+
+	// Entries of paths expiring on a clock boundary all expire at once,
+	// whenever they were stored, unless their status has an explicit TTL.
+	p, _ := keyURL(r)
+	if rule, ok := m.pathRule(m.keyPath(p)); ok && rule.ExpireEvery > 0 && !explicit {
+		every := time.Duration(rule.ExpireEvery) * time.Second
+		expiry, byDefault = boundaryExpiry(time.Now(), every, time.Duration(rule.ExpireJitter)*time.Second), false
+	}
+
+	// Don't retry a rate limited or unavailable origin before it advised.
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		if d, ok := retryAfter(w.Header().Get("Retry-After"), time.Now()); ok {
+			expiry, byDefault = d, false
+			if expiry > maxExpiry {
+				expiry = maxExpiry
+			}
+		}
+	}
+
+	return expiry, byDefault, ""
+}
+
+// bypass returns the reason the request must skip the cache entirely, being
+// neither looked up nor stored, if any.
+func (m *cache) bypass(r *http.Request) string {
+	if !m.cachedHost(r) {
+		return "host " + r.Host + " is not cached"
+	}
+
+	if !m.cacheMethod(r.Method) {
+		return "method " + r.Method + " is not cached"
+	}
+
+	if p, rawQuery := keyURL(r); rawQuery != "" {
+		rule, ok := m.pathRule(m.keyPath(p))
+		if ok && rule.CacheOnlyWhenNoQuery {
+			return "query string"
+		}
+
+		if !rule.IgnoreQuery && m.cfg.QueryParamsOverflowPolicy != queryOverflowDrop && m.tooManyQueryParams(rawQuery) {
+			return "too many query parameters"
+		}
+	}
+
+	if m.cfg.SkipCacheWithCookies && r.Header.Get("Cookie") != "" {
+		return "cookie header"
+	}
+
+	if m.cfg.AuthScopeClaim != "" {
+		if _, ok := m.authScope(r); !ok {
+			return "authorization without " + m.cfg.AuthScopeClaim + " claim"
+		}
+	}
+
+	if m.cfg.BypassOnAuthHeader {
+		// Tokens keyed by their scope claim are cached.
+		if r.Header.Get("Authorization") != "" && m.cfg.AuthScopeClaim == "" {
+			return "authorization header"
+		}
+
+		for _, name := range m.cfg.BypassCookies {
+			if _, err := r.Cookie(name); err == nil {
+				return "cookie " + name
+			}
+		}
+	}
+
+	return ""
+}
+
+// cacheMethod reports whether requests with the given method are cached.
+func (m *cache) cacheMethod(method string) bool {
+	methods := m.cfg.CacheMethods
+	if len(methods) == 0 {
+		methods = defaultCacheMethods
+	}
+
+	for _, meth := range methods {
+		if strings.EqualFold(meth, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *cache) cacheKey(r *http.Request) string {
+	p, rawQuery := keyURL(r)
+	p = m.keyPath(p)
+
+	if rule, ok := m.pathRule(p); ok && rule.IgnoreQuery {
+		rawQuery = ""
+	}
+
+	rawQuery = m.limitQuery(rawQuery)
+
+	// Base key with method, host and path
+	scheme := requestScheme(r)
+	host := m.keyHost(r.Host, scheme)
+
+	key := r.Method + host + p
+	if m.cfg.IncludeScheme {
+		key = r.Method + scheme + "://" + host + p
+	}
+
+	var query string
+	if m.cfg.RawQueryKey {
+		query = rawQueryKey(rawQuery)
+	} else {
+		values, _ := url.ParseQuery(rawQuery)
+		query = queryKey(values)
+	}
+
+	if query != "" {
+		key += "?" + query
+	}
+
+	if src := m.sourceKey(r); src != "" {
+		key += "|" + src
+	}
+
+	if m.cfg.AuthScopeClaim != "" {
+		scope, _ := m.authScope(r)
+		key += "|scope=" + url.QueryEscape(scope)
+	}
+
+	if m.cfg.VaryAcceptLanguage {
+		key += "|lang=" + primaryLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	if m.cfg.VaryByDevice {
+		key += "|device=" + url.QueryEscape(classifyDevice(r.Header.Get("User-Agent"), m.deviceRules))
+	}
+
+	// CORS responses may allow the requesting origin only.
+	if m.cfg.VaryOrigin {
+		key += "|origin=" + url.QueryEscape(r.Header.Get("Origin"))
+	}
+
+	if len(m.cfg.ServedTypes) > 0 {
+		key += "|type=" + negotiateType(r.Header.Get("Accept"), m.cfg.ServedTypes)
+	}
+
+	// Preflight responses depend on the method and headers being asked for.
+	if r.Method == http.MethodOptions {
+		key += "|" + preflightKey(r)
+	}
+
+	// Kept last, see responseBucketKey.
+	if m.cfg.BucketCookie != "" {
+		key += bucketSep + url.QueryEscape(m.requestBucket(r))
+	}
+
+	return key
+}
+
+// keyURL returns the path and raw query of r to key on. Clients must not
+// send fragments, but some do, and net/http leaves them in the path or query.
+// They never select another resource, so they are stripped.
+func keyURL(r *http.Request) (string, string) {
+	i := strings.IndexByte(r.RequestURI, '#')
+	if i < 0 {
+		return r.URL.Path, r.URL.RawQuery
+	}
+
+	u, err := url.ParseRequestURI(r.RequestURI[:i])
+	if err != nil {
+		return r.URL.Path, r.URL.RawQuery
+	}
+
+	return u.Path, u.RawQuery
+}
+
+// requestScheme returns the scheme the client used. Server-side requests
+// rarely have a URL scheme, so it falls back to the forwarded scheme, then to
+// the connection.
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// queryKey returns the decoded query parameters in a sorted, consistent way.
+func queryKey(query url.Values) string {
+	// Get all query parameter keys
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+
+	// Sort the parameter keys
+	sort.Strings(params)
+
+	var queryParts []string
+	for _, param := range params {
+		values := query[param]
+		sort.Strings(values)
+
+		for _, value := range values {
+			queryParts = append(queryParts, url.QueryEscape(param)+"="+url.QueryEscape(value))
+		}
+	}
+
+	// Join all parameters with &
+	return strings.Join(queryParts, "&")
+}
+
+// rawQueryKey returns the query parameters sorted but as sent by the client,
+// so that differences in percent-encoding are preserved. A valueless
+// parameter is keyed as if it had an empty value, as in queryKey.
+func rawQueryKey(rawQuery string) string {
+	var queryParts []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "=") {
+			part += "="
+		}
+
+		queryParts = append(queryParts, part)
+	}
+
+	sort.Strings(queryParts)
+
+	return strings.Join(queryParts, "&")
+}
+
+var errHijackNotSupported = errors.New("hijacking not supported")
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+
+	// onHeader is called once with the response status, right before the
+	// headers are sent.
+	onHeader  func(status int)
+	cacheable bool
+	expiry    time.Duration
+	// defaultExpiry is set when expiry is the default TTL, which size rules
+	// replace once the whole body is known.
+	defaultExpiry bool
+
+	budget   *bufferBudget
+	reserved int64
+
+	// hold withholds the response from the client until flushHeld is
+	// called.
+	hold bool
+	// failed is set when next failed and the response is held back for the
+	// fallback origin.
+	failed bool
+	// hijacked is set once next took over the connection.
+	hijacked bool
+	// cacheControl holds the Cache-Control values of the origin, replaced by
+	// clientCacheControl in the response sent.
+	cacheControl []string
+	// clientGone is set once writing to the client failed, e.g. because it
+	// disconnected. The rest of a cacheable response is still received from
+	// next, to be stored, up to maxDetachedBytes.
+	clientGone bool
+	// client is the context of the client request.
+	client context.Context
+	// stored is set once onHeader decided to store the response, for the
+	// origin request to outlive the client's, see originRequest.
+	stored int32
+}
+
+// detach lets the origin request outlive the client's.
+func (rw *responseWriter) detach() {
+	atomic.StoreInt32(&rw.stored, 1)
+}
+
+func (rw *responseWriter) detached() bool {
+	return atomic.LoadInt32(&rw.stored) == 1
+}
+
+// gone reports whether the client went away.
+func (rw *responseWriter) gone() bool {
+	return rw.clientGone || (rw.client != nil && rw.client.Err() != nil)
+}
 
 func (rw *responseWriter) Header() http.Header {
 	return rw.ResponseWriter.Header()
 }
 
 func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	// Nobody is waiting for the rest of a response too large to be stored.
+	if rw.cacheable && rw.gone() && len(rw.body)+len(p) > maxDetachedBytes {
+		rw.cacheable = false
+		rw.body = nil
+		rw.release()
+		return 0, errClientGone
+	}
+
+	if rw.cacheable || rw.failed {
+		rw.buffer(p)
+	}
+
+	if rw.hold || (rw.clientGone && rw.cacheable) {
+		return len(p), nil
+	}
+
+	if rw.clientGone {
+		return 0, errClientGone
+	}
+
+	n, err := rw.ResponseWriter.Write(p)
+
+	// Hide the failure from next, which would stop sending a response that
+	// is still worth storing.
+	if err != nil && rw.cacheable {
+		rw.clientGone = true
+		return len(p), nil
+	}
+
+	return n, err
+}
+
+// buffer keeps a copy of p for storage, giving up on caching the response
+// if the global buffer budget is exhausted.
+func (rw *responseWriter) buffer(p []byte) {
+	if !rw.budget.Reserve(int64(len(p))) {
+		atomic.AddUint64(&rw.budget.skips, 1)
+
+		// Send what was held back and stream the rest.
+		if rw.hold {
+			rw.flushHeld()
+		}
+
+		rw.cacheable = false
+		rw.failed = false
+		rw.body = nil
+		rw.release()
+		return
+	}
+
+	rw.reserved += int64(len(p))
 	rw.body = append(rw.body, p...)
-	return rw.ResponseWriter.Write(p)
+}
+
+// flushHeld sends the held back response to the client.
+func (rw *responseWriter) flushHeld() {
+	rw.hold = false
+	rw.ResponseWriter.WriteHeader(rw.status)
+
+	if _, err := rw.ResponseWriter.Write(rw.body); err != nil {
+		log.Printf("Error writing response body: %v", err)
+	}
+}
+
+// release returns the buffered bytes to the global budget.
+func (rw *responseWriter) release() {
+	rw.budget.Release(rw.reserved)
+	rw.reserved = 0
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
-	rw.status = s
+	if rw.status == 0 {
+		rw.status = s
+
+		if rw.onHeader != nil {
+			rw.onHeader(s)
+		}
+	}
+
+	if rw.hold {
+		return
+	}
+
 	rw.ResponseWriter.WriteHeader(s)
 }
+
+// Flush sends the buffered data to the client, if the wrapped writer
+// supports it. Held back responses can't be flushed before they are
+// complete.
+func (rw *responseWriter) Flush() {
+	f, ok := rw.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.hold || rw.clientGone {
+		return
+	}
+
+	f.Flush()
+}
+
+// Hijack lets next take over the connection, e.g. for a WebSocket upgrade,
+// if the wrapped writer supports it. The response is then never cached.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rw.hijacked = true
+	rw.cacheable = false
+	rw.failed = false
+	rw.hold = false
+	rw.body = nil
+	rw.release()
+
+	return conn, brw, nil
+}
+
+// ReadFrom copies r to the response, delegating to the wrapped writer when
+// it supports it and the response isn't being buffered.
+func (rw *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok && !rw.cacheable && !rw.failed && !rw.hold {
+		return rf.ReadFrom(r)
+	}
+
+	// Hide ReadFrom from io.Copy, which would call it again.
+	return io.Copy(struct{ io.Writer }{rw}, r)
+}
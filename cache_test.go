@@ -1,12 +1,22 @@
 package plugin_simplecache
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -30,6 +40,196 @@ func TestNew(t *testing.T) {
 			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 1},
 			wantErr: true,
 		},
+		{
+			name:    "should error if eventWebhook is not an http url",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, EventWebhook: "ftp://example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if fallbackOrigin is not an http url",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, FallbackOrigin: "backup:8080"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if onErrorBehavior is unknown",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, OnErrorBehavior: "fail"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a served type is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, ServedTypes: []string{"json"}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a cache status is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CacheStatuses: []int{200, 1000}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a status ttl exceeds maxExpiry",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StatusTTLs: map[string]int{"301": 600}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if an unsafe method is cached without bodyHashKey",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CacheMethods: []string{"GET", "POST"}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if maxServeAge is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MaxServeAge: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if hitDelayMs is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, HitDelayMs: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if cleanupBatchSize is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CleanupBatchSize: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if exportPath is set without invalidationSecret",
+			cfg:     &Config{Backend: backendMemory, MaxExpiry: 300, Cleanup: 600, ExportPath: "/_export"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if importPath is set without invalidationSecret",
+			cfg:     &Config{Backend: backendMemory, MaxExpiry: 300, Cleanup: 600, ImportPath: "/_import"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if memoryMaxBytes is negative",
+			cfg:     &Config{Backend: backendMemory, MaxExpiry: 300, Cleanup: 600, MemoryMaxBytes: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if memoryMaxBytes is set with the file backend",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MemoryMaxBytes: 1024},
+			wantErr: true,
+		},
+		{
+			name:    "should error if statusHeaderMode is unknown",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StatusHeaderMode: "never"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if varyMode is unknown",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, VaryMode: "sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if fileMode is not octal",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, FileMode: "rw-------"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if dirMode is out of range",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, DirMode: "01777"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if staleStatus is out of range",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StaleIfError: 60, StaleStatus: 99},
+			wantErr: true,
+		},
+		{
+			name:    "should error if placeholderStatus is out of range",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, PrefetchConcurrency: 1, PlaceholderPaths: []string{"/reports/"}, PlaceholderStatus: 99},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a key source is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, KeySources: []string{"path:tenant"}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a content type ttl exceeds maxExpiry",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, ContentTypeTTLs: map[string]int{"image/*": 301}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if persistMetrics is set with the memory backend",
+			cfg:     &Config{Backend: backendMemory, MaxExpiry: 300, Cleanup: 600, PersistMetrics: true},
+			wantErr: true,
+		},
+		{
+			name:    "should error if redirectTTL exceeds maxExpiry",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, CachePermanentRedirects: true, RedirectTTL: 301},
+			wantErr: true,
+		},
+		{
+			name:    "should error if storeTimeoutMs is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StoreTimeoutMs: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if heuristicMaxTTL is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, HeuristicMaxTTL: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if offlineResponseFile can't be read",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, OfflineResponseFile: "/nonexistent/offline.html"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if compressionAlgo is unsupported",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, Compress: true, CompressionAlgo: "zstd"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if queryParamsOverflowPolicy is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MaxQueryParams: 10, QueryParamsOverflowPolicy: "truncate"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if minFreeDiskBytes is negative",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, MinFreeDiskBytes: -1},
+			wantErr: true,
+		},
+		{
+			name:    "should error if bucketHeader is set without bucketCookie",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, BucketHeader: "X-Cache-Bucket"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if getBodyPolicy is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, GETBodyPolicy: "ignore"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if statusHeaderFormat is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StatusHeaderFormat: "rfc7234"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if clientCacheControl is invalid",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, ClientCacheControl: "max-age=soon"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if staleOnStatuses has an invalid status",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, StaleIfError: 60, StaleOnStatuses: []int{600}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if sizeTTLRules are not ascending",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, SizeTTLRules: []SizeTTLRule{{MinBytes: 1024, TTL: 60}, {MinBytes: 0, TTL: 30}}},
+			wantErr: true,
+		},
+		{
+			name:    "should error if backend is unknown",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, Backend: "redis"},
+			wantErr: true,
+		},
+		{
+			name:    "should error if a prefetch rule has no param",
+			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600, Prefetch: []PrefetchRule{{Pages: 1}}, PrefetchConcurrency: 1},
+			wantErr: true,
+		},
 		{
 			name:    "should be valid",
 			cfg:     &Config{Path: os.TempDir(), MaxExpiry: 300, Cleanup: 600},
@@ -81,6 +281,1048 @@ func TestCache_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestCache_ServeHTTP_StatusHeaderMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		status     int
+		wantStatus string
+	}{
+		{name: "always on cacheable", mode: "always", status: http.StatusOK, wantStatus: "miss"},
+		{name: "always on non-cacheable", mode: "always", status: http.StatusInternalServerError, wantStatus: "miss"},
+		{name: "managed-only on cacheable", mode: "managed-only", status: http.StatusOK, wantStatus: "miss"},
+		{name: "managed-only on non-cacheable", mode: "managed-only", status: http.StatusInternalServerError, wantStatus: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, StatusHeaderMode: test.mode}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			rw := httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantStatus {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantStatus, state)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_StatusHeaderOnHitOnly(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, StatusHeaderOnHitOnly: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"", "hit"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if values := rw.Header().Values("Cache-Status"); want == "" && len(values) != 0 {
+			t.Errorf("unexpected cache state on a miss: %q", values)
+		} else if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got: %q", want, state)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_PartialContent(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Range") != "" {
+			rw.WriteHeader(http.StatusPartialContent)
+			_, _ = rw.Write([]byte("some"))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	rw := httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusPartialContent {
+		t.Fatalf("unexpected status: want %d, got %d", http.StatusPartialContent, rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	rw = httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "miss" {
+		t.Errorf("unexpected cache state: want \"miss\", got: %q", state)
+	}
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "some content" {
+		t.Errorf("unexpected response: got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+// disconnectingWriter fails writes once limit bytes were written, as when
+// the client disconnects mid-response.
+type disconnectingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *disconnectingWriter) Write(p []byte) (int, error) {
+	if w.Body.Len()+len(p) > w.limit {
+		return 0, errors.New("connection reset by peer")
+	}
+
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestCache_ServeHTTP_ClientDisconnect(t *testing.T) {
+	chunks := []string{"first ", "second ", "third"}
+
+	var writeErr error
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		writeErr = nil
+
+		// As a proxy does, stop sending once the client is gone.
+		for _, chunk := range chunks {
+			if _, writeErr = rw.Write([]byte(chunk)); writeErr != nil {
+				return
+			}
+		}
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &disconnectingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 8}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if writeErr != nil {
+		t.Errorf("unexpected write error reported to the origin: %v", writeErr)
+	}
+
+	if body := w.Body.String(); body != "first " {
+		t.Errorf("unexpected body sent before the disconnect: %q", body)
+	}
+
+	// The whole response was received and stored all the same.
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want %q, got %q", "hit", state)
+	}
+
+	if body := rw.Body.String(); body != "first second third" {
+		t.Errorf("unexpected cached body: %q", body)
+	}
+
+	// Responses that aren't stored still fail, so that the origin stops.
+	w = &disconnectingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 8}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://localhost/page", nil))
+
+	if writeErr == nil {
+		t.Error("expected the write error to be reported to the origin")
+	}
+}
+
+// cancellingWriter cancels the client request once limit bytes were written,
+// as net/http does when the client disconnects, and fails the later writes.
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	limit  int
+	cancel func()
+	gone   bool
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	if w.gone {
+		return 0, errors.New("connection reset by peer")
+	}
+
+	n, err := w.ResponseRecorder.Write(p)
+	if w.Body.Len() >= w.limit {
+		w.gone = true
+		w.cancel()
+	}
+
+	return n, err
+}
+
+func TestCache_ServeHTTP_ClientDisconnectProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		rw.Header().Set("Content-Length", "18")
+		_, _ = rw.Write([]byte("first "))
+		rw.(http.Flusher).Flush()
+
+		// Send the rest once the client is gone.
+		<-ctx.Done()
+		_, _ = rw.Write([]byte("second third"))
+	}))
+	defer origin.Close()
+
+	u, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	h, err := New(context.Background(), httputil.NewSingleHostReverseProxy(u), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 6, cancel: cancel}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil).WithContext(ctx))
+
+	if body := w.Body.String(); body != "first " {
+		t.Errorf("unexpected body sent before the disconnect: %q", body)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want %q, got %q", "hit", state)
+	}
+
+	if body := rw.Body.String(); body != "first second third" {
+		t.Errorf("unexpected cached body: %q", body)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("unexpected origin requests: want 1, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTP_ClientDisconnectStream(t *testing.T) {
+	timeout := detachedFetchTimeout
+	defer func() { detachedFetchTimeout = timeout }()
+
+	tests := []struct {
+		name string
+		// untilCancelled keeps the origin streaming, whatever the write
+		// errors, until its request is cancelled.
+		untilCancelled bool
+		timeout        time.Duration
+	}{
+		{name: "stopped by maxDetachedBytes", timeout: time.Minute},
+		{name: "stopped by detachedFetchTimeout", untilCancelled: true, timeout: 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detachedFetchTimeout = test.timeout
+
+			chunk := []byte(strings.Repeat("x", 64<<10))
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/event-stream")
+
+				for {
+					select {
+					case <-req.Context().Done():
+						return
+					default:
+					}
+
+					if _, err := rw.Write(chunk); err != nil && !test.untilCancelled {
+						return
+					}
+
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), limit: len(chunk), cancel: cancel}
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/events", nil).WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the handler to return once the client went away")
+			}
+
+			if metas := h.(*cache).cache.(*memoryCache).index.Metas(); len(metas) != 0 {
+				t.Errorf("expected the cut off stream not to be stored, got %v", metas)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_ConditionalMiss(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        *Config
+		notChanged bool
+		wantCode   int
+		wantState  string
+	}{
+		{
+			name:      "full response is stored",
+			cfg:       &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true},
+			wantCode:  http.StatusOK,
+			wantState: "hit",
+		},
+		{
+			name:       "not modified is passed through",
+			cfg:        &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true},
+			notChanged: true,
+			wantCode:   http.StatusNotModified,
+			wantState:  "miss",
+		},
+		{
+			name:       "not modified is never stored",
+			cfg:        &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, CacheStatuses: []int{200, 304}, StatusTTLs: map[string]int{"304": 10}},
+			notChanged: true,
+			wantCode:   http.StatusNotModified,
+			wantState:  "miss",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("ETag", `"v1"`)
+
+				if test.notChanged && req.Header.Get("If-None-Match") == `"v1"` {
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				_, _ = rw.Write([]byte("some content"))
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), test.cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("If-None-Match", `"v1"`)
+			req.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status: want %d, got %d", test.wantCode, rw.Code)
+			}
+
+			// An unconditional request must get the full resource.
+			rw = httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+
+			if rw.Code != http.StatusOK || rw.Body.String() != "some content" {
+				t.Errorf("unexpected response: got %d %q", rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_VaryMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		wantState string
+		wantBody  string
+	}{
+		{name: "ignore serves the stored variant", mode: "ignore", wantState: "hit", wantBody: "gzip"},
+		{name: "bypass does not store", mode: "bypass", wantState: "miss", wantBody: "br"},
+		{name: "default bypasses", mode: "", wantState: "miss", wantBody: "br"},
+		{name: "key stores per variant", mode: "key", wantState: "miss", wantBody: "br"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Vary", "Accept-Encoding")
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte(req.Header.Get("Accept-Encoding")))
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: test.mode}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			req = httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "br")
+			rw := httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantState, state)
+			}
+
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got: %q", test.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_VaryKeyHit(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Vary", "Accept-Encoding")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(req.Header.Get("Accept-Encoding")))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: "key"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, enc := range []string{"gzip", "br"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Accept-Encoding", enc)
+
+		c.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, enc := range []string{"gzip", "br"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Accept-Encoding", enc)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != "hit" {
+			t.Errorf("unexpected cache state for %s: want \"hit\", got: %q", enc, state)
+		}
+
+		if body := rw.Body.String(); body != enc {
+			t.Errorf("unexpected body: want %q, got: %q", enc, body)
+		}
+	}
+}
+
+func TestCache_cacheKey_QueryOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		wantSame bool
+	}{
+		{name: "parameters in any order", a: "/p?a=1&b=2", b: "/p?b=2&a=1", wantSame: true},
+		{name: "repeated parameter values in any order", a: "/p?a=1&a=2", b: "/p?a=2&a=1", wantSame: true},
+		{name: "interleaved repeated parameters", a: "/p?a=1&b=2&a=3", b: "/p?a=3&a=1&b=2", wantSame: true},
+		{name: "valueless parameter", a: "/p?flag", b: "/p?flag=", wantSame: true},
+		{name: "valueless parameter in any order", a: "/p?flag&a=1", b: "/p?a=1&flag", wantSame: true},
+		{name: "different values", a: "/p?a=1&b=2", b: "/p?a=2&b=1", wantSame: false},
+		{name: "missing repeated value", a: "/p?a=1&a=2", b: "/p?a=1", wantSame: false},
+		{name: "valueless parameter is not absent", a: "/p?flag", b: "/p", wantSame: false},
+	}
+
+	for _, test := range tests {
+		for _, raw := range []bool{false, true} {
+			m := &cache{cfg: &Config{RawQueryKey: raw}}
+
+			a := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.a, nil))
+			b := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.b, nil))
+
+			if (a == b) != test.wantSame {
+				t.Errorf("%s (raw %t): unexpected keys: %q and %q", test.name, raw, a, b)
+			}
+		}
+	}
+}
+
+func TestCache_cacheKey_IncludeScheme(t *testing.T) {
+	newRequest := func(target, proto string, tls bool) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		// Server-side requests have no URL scheme.
+		req.URL.Scheme = ""
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if !tls {
+			req.TLS = nil
+		}
+
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		include  bool
+		a, b     *http.Request
+		wantSame bool
+	}{
+		{name: "shared by default", a: newRequest("http://localhost/p", "http", false), b: newRequest("https://localhost/p", "https", true), wantSame: true},
+		{name: "forwarded scheme", include: true, a: newRequest("http://localhost/p", "http", false), b: newRequest("http://localhost/p", "https", false), wantSame: false},
+		{name: "same forwarded scheme", include: true, a: newRequest("http://localhost/p", "HTTPS", false), b: newRequest("http://localhost/p", "https, http", false), wantSame: true},
+		{name: "connection scheme", include: true, a: newRequest("http://localhost/p", "", false), b: newRequest("https://localhost/p", "", true), wantSame: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &cache{cfg: &Config{IncludeScheme: test.include}}
+
+			a, b := m.cacheKey(test.a), m.cacheKey(test.b)
+			if (a == b) != test.wantSame {
+				t.Errorf("unexpected keys: %q and %q", a, b)
+			}
+		})
+	}
+}
+
+func TestCache_cacheKey_RawQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      bool
+		a, b     string
+		wantSame bool
+	}{
+		{name: "decoded merges encodings", raw: false, a: "/p?x=%41", b: "/p?x=A", wantSame: true},
+		{name: "raw preserves encodings", raw: true, a: "/p?x=%41", b: "/p?x=A", wantSame: false},
+		{name: "decoded merges plus and space", raw: false, a: "/p?x=a+b", b: "/p?x=a%20b", wantSame: true},
+		{name: "raw preserves plus and space", raw: true, a: "/p?x=a+b", b: "/p?x=a%20b", wantSame: false},
+		{name: "raw sorts parameters", raw: true, a: "/p?b=2&a=1", b: "/p?a=1&b=2", wantSame: true},
+		{name: "raw keys valueless parameters as empty", raw: true, a: "/p?flag", b: "/p?flag=", wantSame: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &cache{cfg: &Config{RawQueryKey: test.raw}}
+
+			a := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.a, nil))
+			b := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.b, nil))
+
+			if (a == b) != test.wantSame {
+				t.Errorf("unexpected keys: %q and %q", a, b)
+			}
+		})
+	}
+}
+
+func TestCache_cacheKey_Fragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		wantSame bool
+	}{
+		{name: "trailing question mark", a: "/page?", b: "/page", wantSame: true},
+		{name: "fragment", a: "/page#frag", b: "/page", wantSame: true},
+		{name: "fragment after empty query", a: "/page?#x", b: "/page", wantSame: true},
+		{name: "fragment after query", a: "/page?a=1#frag", b: "/page?a=1", wantSame: true},
+		{name: "empty value", a: "/page?a", b: "/page?a=", wantSame: true},
+		{name: "encoded hash is not a fragment", a: "/page%23frag", b: "/page", wantSame: false},
+	}
+
+	for _, test := range tests {
+		for _, raw := range []bool{false, true} {
+			m := &cache{cfg: &Config{RawQueryKey: raw}}
+
+			a := m.cacheKey(httptest.NewRequest(http.MethodGet, test.a, nil))
+			b := m.cacheKey(httptest.NewRequest(http.MethodGet, test.b, nil))
+
+			if (a == b) != test.wantSame {
+				t.Errorf("%s (raw %t): unexpected keys: %q and %q", test.name, raw, a, b)
+			}
+		}
+	}
+}
+
+func TestCache_ServeHTTP_VaryCookie(t *testing.T) {
+	for _, mode := range []string{"ignore", "bypass", "key"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Vary", "Cookie")
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte(req.Header.Get("Cookie")))
+			}
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: mode}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+				req.Header.Set("Cookie", "session=alice")
+				rw := httptest.NewRecorder()
+
+				c.ServeHTTP(rw, req)
+
+				if state := rw.Header().Get("Cache-Status"); state != "miss" {
+					t.Errorf("unexpected cache state: want \"miss\", got: %q", state)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_HitDelay(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, HitDelayMs: 50}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/delay", nil))
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/delay", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Fatalf("unexpected cache state: want \"hit\", got %q", state)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the hit to be delayed, took %v", elapsed)
+	}
+}
+
+func TestCache_ServeHTTP_BypassOnAuthHeader(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, BypassOnAuthHeader: true, BypassCookies: []string{"session"}}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		wantState string
+	}{
+		{name: "anonymous", wantState: "hit"},
+		{name: "authorization", header: "Authorization", value: "Bearer token", wantState: "miss"},
+		{name: "session cookie", header: "Cookie", value: "session=abc", wantState: "miss"},
+		{name: "other cookie", header: "Cookie", value: "theme=dark", wantState: "hit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rw *httptest.ResponseRecorder
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/"+t.Name(), nil)
+				if test.header != "" {
+					req.Header.Set(test.header, test.value)
+				}
+
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+		})
+	}
+
+	// Authenticated requests are not served anonymous cached responses.
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/"+t.Name()+"/anonymous", nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "miss" {
+		t.Errorf("expected authenticated request to bypass the cache, got %q", state)
+	}
+}
+
+func TestNew_EmptyPath(t *testing.T) {
+	cfg := &Config{MaxExpiry: 300, Cleanup: 600}
+
+	h, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cfg, "my-cache@file")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := filepath.Join(os.TempDir(), "plugin-simplecache-my-cache_file")
+	if got := h.(*cache).cache.(*fileCache).path; got != want {
+		t.Errorf("unexpected path: want %q, got %q", want, got)
+	}
+
+	if info, err := os.Stat(want); err != nil || !info.IsDir() {
+		t.Errorf("expected the default path to be created: %v", err)
+	}
+}
+
+func TestCache_ServeHTTP_ReplaysContentType(t *testing.T) {
+	dir := createTempDir(t)
+
+	contentType := "application/json; charset=utf-8"
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", contentType)
+		_, _ = rw.Write([]byte(`{"ok":true}`))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/api", nil))
+
+	// The origin changing its Content-Type doesn't affect the stored entry.
+	contentType = "text/html"
+
+	rw := httptest.NewRecorder()
+	// A Content-Type set by a previous handler is replaced.
+	rw.Header().Set("Content-Type", "text/plain")
+
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/api", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Fatalf("unexpected cache state: want \"hit\", got %q", state)
+	}
+
+	if got := rw.Header().Values("Content-Type"); len(got) != 1 || got[0] != "application/json; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", got)
+	}
+}
+
+func TestCache_ServeHTTP_MemoryBackend(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{Backend: backendMemory, Path: "/does/not/exist", MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"miss", "hit"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got: %q", want, state)
+		}
+
+		if body := rw.Body.String(); body != "some content" {
+			t.Errorf("unexpected body: %q", body)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_SkipCacheWithCookies(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, SkipCacheWithCookies: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cookie    string
+		wantState string
+		wantCalls int
+	}{
+		{name: "anonymous", wantState: "hit", wantCalls: 1},
+		{name: "cookie", cookie: "theme=dark", wantState: "miss", wantCalls: 3},
+	}
+
+	for _, test := range tests {
+		var rw *httptest.ResponseRecorder
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			if test.cookie != "" {
+				req.Header.Set("Cookie", test.cookie)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+		}
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %s: want %q, got %q", test.name, test.wantState, state)
+		}
+
+		if calls != test.wantCalls {
+			t.Errorf("unexpected origin calls for %s: want %d, got %d", test.name, test.wantCalls, calls)
+		}
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+
+	conn, _ := net.Pipe()
+
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+func TestResponseWriter_Flush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rec, budget: &bufferBudget{}}
+
+	var _ http.Flusher = rw
+	var _ http.Hijacker = rw
+	var _ io.ReaderFrom = rw
+
+	_, _ = rw.Write([]byte("some content"))
+	rw.Flush()
+
+	if !rec.Flushed {
+		t.Error("expected the wrapped writer to be flushed")
+	}
+
+	if _, _, err := rw.Hijack(); !errors.Is(err, errHijackNotSupported) {
+		t.Errorf("expected hijacking to be unsupported, got: %v", err)
+	}
+}
+
+func TestCache_ServeHTTP_Hijack(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected hijack error: %v", err)
+			return
+		}
+		_ = conn.Close()
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+		c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if !rec.hijacked {
+			t.Fatal("expected the connection to be hijacked")
+		}
+
+		if rec.Body.Len() != 0 || rec.Header().Get("Cache-Status") != "" {
+			t.Errorf("unexpected response written to a hijacked connection: %v", rec.Header())
+		}
+	}
+}
+
+func TestCache_ServeHTTP_ReadFrom(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = io.Copy(rw, strings.NewReader("some content"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"miss", "hit"} {
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got: %q", want, state)
+		}
+
+		if body := rw.Body.String(); body != "some content" {
+			t.Errorf("unexpected body: %q", body)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_TruncatedBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		length    string
+		wantState string
+	}{
+		{name: "complete", length: "10", wantState: "hit"},
+		{name: "truncated", length: "1000", wantState: "miss"},
+		{name: "undeclared", wantState: "hit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.length != "" {
+					rw.Header().Set("Content-Length", test.length)
+				}
+				rw.WriteHeader(http.StatusOK)
+				// The origin drops the connection after 10 bytes.
+				_, _ = rw.Write([]byte("0123456789"))
+			}
+
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantState, state)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_CustomStatusText(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		// The reason phrase can only be carried in a header.
+		rw.Header().Set("X-Status-Text", "Mostly Harmless")
+		rw.WriteHeader(299)
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, CacheStatuses: []int{299}}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"miss", "hit"} {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got: %q", want, state)
+		}
+
+		if rw.Code != 299 {
+			t.Errorf("unexpected status: want 299, got %d", rw.Code)
+		}
+
+		if v := rw.Header().Get("X-Status-Text"); v != "Mostly Harmless" {
+			t.Errorf("unexpected status text header: %q", v)
+		}
+	}
+}
+
 func createTempDir(tb testing.TB) string {
 	tb.Helper()
 
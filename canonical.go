@@ -0,0 +1,91 @@
+package plugin_simplecache
+
+import "strings"
+
+// keyPath returns the request path p as it is keyed and matched against the
+// path rules.
+func (m *cache) keyPath(p string) string {
+	p = m.stripMatrixParams(p)
+
+	if m.cfg.CanonicalURLKey {
+		p = removeDotSegments(p)
+
+		if m.cfg.CanonicalMergeSlashes {
+			p = mergeSlashes(p)
+		}
+	}
+
+	return p
+}
+
+// keyHost returns the host of r as it is keyed.
+func (m *cache) keyHost(host, scheme string) string {
+	if !m.cfg.CanonicalURLKey {
+		return host
+	}
+
+	return canonicalHost(host, scheme)
+}
+
+// removeDotSegments resolves the . and .. segments of the absolute path p as
+// described in RFC 3986 5.2.4. Unlike path.Clean, it keeps empty segments,
+// which some backends tell apart, e.g. /a//b from /a/b, and trailing
+// slashes.
+func removeDotSegments(p string) string {
+	if !strings.HasPrefix(p, "/") || !strings.Contains(p, ".") {
+		return p
+	}
+
+	segments := strings.Split(p[1:], "/")
+
+	kept := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		switch segment {
+		case ".":
+		case "..":
+			if len(kept) > 0 {
+				kept = kept[:len(kept)-1]
+			}
+		default:
+			kept = append(kept, segment)
+			continue
+		}
+
+		// A path ending with a dot segment names a directory.
+		if last {
+			kept = append(kept, "")
+		}
+	}
+
+	return "/" + strings.Join(kept, "/")
+}
+
+// mergeSlashes replaces the runs of slashes of p with a single one.
+func mergeSlashes(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.Replace(p, "//", "/", -1)
+	}
+
+	return p
+}
+
+// canonicalHost returns host lowercased, without the default port of scheme
+// nor the trailing dot of a fully qualified name.
+func canonicalHost(host, scheme string) string {
+	host = strings.ToLower(host)
+
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = strings.TrimSuffix(host, ":443")
+	}
+
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		return strings.TrimSuffix(host[:i], ".") + host[i:]
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
@@ -0,0 +1,125 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoveDotSegments(t *testing.T) {
+	tests := map[string]string{
+		"/":             "/",
+		"/a/b":          "/a/b",
+		"/a/./b":        "/a/b",
+		"/a/b/../c":     "/a/c",
+		"/a/b/..":       "/a/",
+		"/a/b/.":        "/a/b/",
+		"/../a":         "/a",
+		"/a/../../b":    "/b",
+		"/a//b/../c":    "/a//c",
+		"/a/b/":         "/a/b/",
+		"/a/.hidden/..": "/a/",
+		"/a/..b/c":      "/a/..b/c",
+		"/v1.2/x":       "/v1.2/x",
+	}
+
+	for p, want := range tests {
+		if got := removeDotSegments(p); got != want {
+			t.Errorf("%q: want %q, got %q", p, want, got)
+		}
+	}
+}
+
+func TestMergeSlashes(t *testing.T) {
+	for p, want := range map[string]string{"/a//b": "/a/b", "///a/b//": "/a/b/", "/a/b": "/a/b"} {
+		if got := mergeSlashes(p); got != want {
+			t.Errorf("%q: want %q, got %q", p, want, got)
+		}
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		scheme string
+		want   string
+	}{
+		{host: "Example.COM", scheme: "http", want: "example.com"},
+		{host: "example.com:80", scheme: "http", want: "example.com"},
+		{host: "example.com:443", scheme: "https", want: "example.com"},
+		{host: "example.com:443", scheme: "http", want: "example.com:443"},
+		{host: "example.com.", scheme: "http", want: "example.com"},
+		{host: "example.com.:8080", scheme: "http", want: "example.com:8080"},
+		{host: "[::1]:80", scheme: "http", want: "[::1]"},
+		{host: "[::1]:8080", scheme: "http", want: "[::1]:8080"},
+	}
+
+	for _, test := range tests {
+		if got := canonicalHost(test.host, test.scheme); got != test.want {
+			t.Errorf("%s %q: want %q, got %q", test.scheme, test.host, test.want, got)
+		}
+	}
+}
+
+func TestCache_cacheKey_CanonicalURLKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    *Config
+		a, b   string
+		shared bool
+	}{
+		{name: "dot segments", cfg: &Config{CanonicalURLKey: true}, a: "http://localhost/a/b/../c", b: "http://localhost/a/c", shared: true},
+		{name: "host case and default port", cfg: &Config{CanonicalURLKey: true}, a: "http://LocalHost:80/a", b: "http://localhost/a", shared: true},
+		{name: "query order", cfg: &Config{CanonicalURLKey: true}, a: "http://localhost/a?y=2&x=1", b: "http://localhost/a?x=1&y=2", shared: true},
+		{name: "double slashes kept", cfg: &Config{CanonicalURLKey: true}, a: "http://localhost/a//b", b: "http://localhost/a/b"},
+		{name: "double slashes merged", cfg: &Config{CanonicalURLKey: true, CanonicalMergeSlashes: true}, a: "http://localhost/a//b", b: "http://localhost/a/b", shared: true},
+		{name: "trailing slash kept", cfg: &Config{CanonicalURLKey: true}, a: "http://localhost/a/", b: "http://localhost/a"},
+		{name: "disabled", cfg: &Config{}, a: "http://localhost/a/b/../c", b: "http://localhost/a/c"},
+	}
+
+	for _, test := range tests {
+		m := &cache{cfg: test.cfg}
+
+		a := m.cacheKey(httptest.NewRequest(http.MethodGet, test.a, nil))
+		b := m.cacheKey(httptest.NewRequest(http.MethodGet, test.b, nil))
+
+		if (a == b) != test.shared {
+			t.Errorf("%s: unexpected keys %q and %q", test.name, a, b)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_CanonicalURLKeyPathRules(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.RawQuery))
+	}
+
+	cfg := &Config{
+		Backend:         backendMemory,
+		MaxExpiry:       10,
+		Cleanup:         20,
+		AddStatusHeader: true,
+		CanonicalURLKey: true,
+		PathRules:       []PathRule{{PathPrefix: "/static/", IgnoreQuery: true}},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Path rules match the canonical path, so /static/.. escapes them.
+	for _, test := range []struct{ target, wantState string }{
+		{target: "/static/../page?v=1", wantState: "miss"},
+		{target: "/page?v=2", wantState: "miss"},
+		{target: "/page?v=1", wantState: "hit"},
+	} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+test.target, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("%s: unexpected cache state: want %q, got %q", test.target, test.wantState, state)
+		}
+	}
+}
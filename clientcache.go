@@ -0,0 +1,42 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+func validateClientCacheControl(cfg *Config) error {
+	if cfg.ClientCacheControl == "" {
+		return nil
+	}
+
+	if _, err := cacheobject.ParseResponseCacheControl(cfg.ClientCacheControl); err != nil {
+		return fmt.Errorf("invalid clientCacheControl %q: %w", cfg.ClientCacheControl, err)
+	}
+
+	return nil
+}
+
+// setClientCacheControl replaces the Cache-Control header sent to the client
+// with the configured one, returning the replaced values.
+func (m *cache) setClientCacheControl(h http.Header) []string {
+	vals := h.Values("Cache-Control")
+	h.Set("Cache-Control", m.cfg.ClientCacheControl)
+
+	return vals
+}
+
+// originCacheControl returns the headers to store with the Cache-Control
+// values the origin sent, which the client didn't get.
+func originCacheControl(h http.Header, vals []string) http.Header {
+	stored := h.Clone()
+	stored.Del("Cache-Control")
+
+	if len(vals) > 0 {
+		stored["Cache-Control"] = vals
+	}
+
+	return stored
+}
@@ -0,0 +1,86 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_ClientCacheControl(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "private, max-age=5")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("page"))
+	}
+
+	cfg := &Config{
+		Backend:            backendMemory,
+		MaxExpiry:          300,
+		Cleanup:            600,
+		AddStatusHeader:    true,
+		ClientCacheControl: "public, max-age=60",
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	for _, wantState := range []string{"miss", "hit"} {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != wantState {
+			t.Errorf("unexpected cache state: want %q, got %q", wantState, state)
+		}
+
+		if cc := rw.Header().Values("Cache-Control"); len(cc) != 1 || cc[0] != cfg.ClientCacheControl {
+			t.Errorf("%s: unexpected Cache-Control: want %q, got %q", wantState, cfg.ClientCacheControl, cc)
+		}
+	}
+
+	metas := c.cache.(*memoryCache).index.Metas()
+	if len(metas) != 1 {
+		t.Fatalf("unexpected number of entries: want 1, got %d", len(metas))
+	}
+
+	// The entry keeps the TTL and the header of the origin.
+	e, _ := c.cache.(*memoryCache).index.Get(metas[0].Key)
+	if ttl := time.Until(e.expires); ttl < 290*time.Second {
+		t.Errorf("unexpected entry TTL: want about 300s, got %s", ttl)
+	}
+
+	data, err := c.get(context.Background(), metas[0].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cc := http.Header(data.Headers).Get("Cache-Control"); cc != "private, max-age=5" {
+		t.Errorf("unexpected stored Cache-Control: want %q, got %q", "private, max-age=5", cc)
+	}
+}
+
+func TestCache_ServeHTTP_ClientCacheControlNotCacheable(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 300, Cleanup: 600, ClientCacheControl: "public, max-age=60"}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if cc := rw.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("unexpected Cache-Control: want %q, got %q", "no-store", cc)
+	}
+}
@@ -0,0 +1,130 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// defaultCompressMinBytes is the size below which bodies are stored raw, as
+// compressing them costs more than it saves, if anything.
+const defaultCompressMinBytes = 1024
+
+// Compression algorithms, which are also the stored body encodings.
+const (
+	storedEncodingGzip    = "gzip"
+	storedEncodingDeflate = "deflate"
+	compressionNone       = "none"
+)
+
+// validateCompressionAlgo checks compressionAlgo. zstd is rejected: the
+// standard library, all Yaegi lets plugins import, has none, and a zstd
+// encoder bundled here would run interpreted, far slower than compress/flate.
+func validateCompressionAlgo(algo string) error {
+	switch algo {
+	case "", storedEncodingGzip, storedEncodingDeflate, compressionNone:
+		return nil
+	case "zstd":
+		return fmt.Errorf("compressionAlgo %q is not supported by Traefik plugins, which are limited to the Go standard library: use gzip or deflate", algo)
+	default:
+		return fmt.Errorf("unknown compressionAlgo %q", algo)
+	}
+}
+
+func (m *cache) compressionAlgo() string {
+	if m.cfg.CompressionAlgo != "" {
+		return m.cfg.CompressionAlgo
+	}
+
+	return storedEncodingGzip
+}
+
+func (m *cache) compressMinBytes() int {
+	if m.cfg.CompressMinBytes > 0 {
+		return m.cfg.CompressMinBytes
+	}
+
+	return defaultCompressMinBytes
+}
+
+// compressed returns data with its body compressed for storage, or data
+// itself if the body is too small or already encoded by the origin.
+func (m *cache) compressed(data *cacheData) (*cacheData, error) {
+	algo := m.compressionAlgo()
+	if !m.cfg.Compress || algo == compressionNone || len(data.Body) < m.compressMinBytes() {
+		return data, nil
+	}
+
+	for k := range data.Headers {
+		if http.CanonicalHeaderKey(k) == "Content-Encoding" {
+			return data, nil
+		}
+	}
+
+	var (
+		buf bytes.Buffer
+		zw  io.WriteCloser
+		err error
+	)
+
+	switch algo {
+	case storedEncodingDeflate:
+		zw, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, fmt.Errorf("error compressing body: %w", err)
+		}
+	default:
+		zw = gzip.NewWriter(&buf)
+	}
+
+	if _, err = zw.Write(data.Body); err != nil {
+		return nil, fmt.Errorf("error compressing body: %w", err)
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing body: %w", err)
+	}
+
+	c := *data
+	c.Body = buf.Bytes()
+	c.BodyEncoding = algo
+
+	return &c, nil
+}
+
+// decompress restores the body of data as it was received. It follows the
+// encoding stored in the entry rather than the configured algorithm, so that
+// entries written before a change of compressionAlgo are still read.
+func decompress(data *cacheData) error {
+	var zr io.Reader
+
+	switch data.BodyEncoding {
+	case "":
+		return nil
+	case storedEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data.Body))
+		if err != nil {
+			return fmt.Errorf("error decompressing body: %w", err)
+		}
+
+		zr = r
+	case storedEncodingDeflate:
+		zr = flate.NewReader(bytes.NewReader(data.Body))
+	default:
+		return fmt.Errorf("unknown body encoding %q", data.BodyEncoding)
+	}
+
+	body, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("error decompressing body: %w", err)
+	}
+
+	data.Body = body
+	data.BodyEncoding = ""
+
+	return nil
+}
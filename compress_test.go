@@ -0,0 +1,270 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCache_Compress(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		encoding     string
+		wantEncoding string
+	}{
+		{name: "below threshold", body: strings.Repeat("x", 100)},
+		{name: "above threshold", body: strings.Repeat("x", 2048), wantEncoding: storedEncodingGzip},
+		{name: "already encoded", body: strings.Repeat("x", 2048), encoding: "br"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.encoding != "" {
+					rw.Header().Set("Content-Encoding", test.encoding)
+				}
+				_, _ = rw.Write([]byte(test.body))
+			}
+
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Compress: true}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "br")
+
+			for _, want := range []string{"miss", "hit"} {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				if state := rw.Header().Get("Cache-Status"); state != want {
+					t.Errorf("unexpected cache state: want %q, got %q", want, state)
+				}
+
+				if rw.Body.String() != test.body {
+					t.Errorf("unexpected body of %d bytes", rw.Body.Len())
+				}
+			}
+
+			b, err := c.cache.Get(c.cacheKey(req))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stored cacheData
+			if err = json.Unmarshal(b, &stored); err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.BodyEncoding != test.wantEncoding {
+				t.Errorf("unexpected stored encoding: want %q, got %q", test.wantEncoding, stored.BodyEncoding)
+			}
+
+			if test.wantEncoding == "" && string(stored.Body) != test.body {
+				t.Error("expected the body to be stored raw")
+			}
+
+			if test.wantEncoding != "" && len(stored.Body) >= len(test.body) {
+				t.Errorf("expected the body to be stored compressed, got %d bytes", len(stored.Body))
+			}
+		})
+	}
+}
+
+func TestCache_CompressionAlgo(t *testing.T) {
+	body := strings.Repeat(`{"id":1,"name":"x"}`, 200)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(body))
+	}
+
+	tests := []struct {
+		algo         string
+		wantEncoding string
+	}{
+		{algo: "", wantEncoding: storedEncodingGzip},
+		{algo: storedEncodingGzip, wantEncoding: storedEncodingGzip},
+		{algo: storedEncodingDeflate, wantEncoding: storedEncodingDeflate},
+		{algo: compressionNone, wantEncoding: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algo, func(t *testing.T) {
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Compress: true, CompressionAlgo: test.algo}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			for _, want := range []string{"miss", "hit"} {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				if state := rw.Header().Get("Cache-Status"); state != want {
+					t.Errorf("unexpected cache state: want %q, got %q", want, state)
+				}
+
+				if rw.Body.String() != body {
+					t.Errorf("unexpected body of %d bytes", rw.Body.Len())
+				}
+			}
+
+			b, err := c.cache.Get(c.cacheKey(req))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stored cacheData
+			if err = json.Unmarshal(b, &stored); err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.BodyEncoding != test.wantEncoding {
+				t.Errorf("unexpected stored encoding: want %q, got %q", test.wantEncoding, stored.BodyEncoding)
+			}
+		})
+	}
+}
+
+func TestCache_CompressionAlgo_MixedEntries(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path + body))
+	}
+
+	cfg := &Config{Path: t.TempDir(), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Compress: true, CompressionAlgo: storedEncodingGzip}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/gzip", nil))
+
+	// Roll the algorithm out on the same cache directory.
+	cfg.CompressionAlgo = storedEncodingDeflate
+
+	h, err = New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/deflate", nil))
+
+	for _, path := range []string{"/gzip", "/deflate"} {
+		rw = httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != "hit" {
+			t.Errorf("%s: unexpected cache state: want %q, got %q", path, "hit", state)
+		}
+
+		if rw.Body.String() != path+body {
+			t.Errorf("%s: unexpected body of %d bytes", path, rw.Body.Len())
+		}
+	}
+}
+
+func TestCache_Compress_NeverAdvertisesStoredEncoding(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(body))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Compress: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is stored gzipped, but only a br client asks for it, and
+	// there's no brotli encoder to re-encode to.
+	for _, want := range []string{"miss", "hit"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Accept-Encoding", "br")
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got %q", want, state)
+		}
+
+		if v := rw.Header().Get("Content-Encoding"); v != "" {
+			t.Errorf("unexpected Content-Encoding %q", v)
+		}
+
+		if rw.Body.String() != body {
+			t.Errorf("expected the identity body, got %d bytes", rw.Body.Len())
+		}
+	}
+}
+
+func TestDecompress_UnknownEncoding(t *testing.T) {
+	if err := decompress(&cacheData{Body: []byte("x"), BodyEncoding: "lz4"}); err == nil {
+		t.Error("expected an unknown encoding to fail")
+	}
+}
+
+func TestCache_Compress_Range(t *testing.T) {
+	body := strings.Repeat("0123456789", 205)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(body))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Compress: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	// A range of the stored gzip stream would be garbage, the cached copy is
+	// served whole as the resource bytes.
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Range", "bytes=0-9")
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want %q, got %q", "hit", state)
+	}
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, rw.Code)
+	}
+
+	for _, name := range []string{"Content-Range", "Content-Encoding"} {
+		if v := rw.Header().Get(name); v != "" {
+			t.Errorf("unexpected %s %q", name, v)
+		}
+	}
+
+	if rw.Body.String() != body {
+		t.Errorf("expected the whole identity body, got %d bytes", rw.Body.Len())
+	}
+}
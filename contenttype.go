@@ -0,0 +1,65 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseContentTypeTTLs parses the configured per content type TTLs. Content
+// types are matched ignoring case, and may end with "*" to match any content
+// type starting with the rest.
+func parseContentTypeTTLs(ttls map[string]int, maxExpiry int) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(ttls))
+
+	for pattern, ttl := range ttls {
+		p := strings.ToLower(strings.TrimSpace(pattern))
+		if p == "" || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+			return nil, fmt.Errorf("invalid content type %q", pattern)
+		}
+
+		if ttl < 1 || ttl > maxExpiry {
+			return nil, fmt.Errorf("ttl of content type %q must be between 1 and maxExpiry", pattern)
+		}
+
+		parsed[p] = time.Duration(ttl) * time.Second
+	}
+
+	return parsed, nil
+}
+
+// contentTypeTTL returns the TTL of responses with the given Content-Type, if
+// any. An exact match wins over wildcards, and longer wildcards over shorter
+// ones, so "image/svg+xml" wins over "image/*" which wins over "*".
+func (m *cache) contentTypeTTL(contentType string) (time.Duration, bool) {
+	if len(m.contentTypeTTLs) == 0 {
+		return 0, false
+	}
+
+	ct := contentType
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+
+	ct = strings.ToLower(strings.TrimSpace(ct))
+
+	if ttl, ok := m.contentTypeTTLs[ct]; ok {
+		return ttl, true
+	}
+
+	var (
+		ttl  time.Duration
+		best = -1
+	)
+
+	for pattern, d := range m.contentTypeTTLs {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix == pattern || len(prefix) <= best || !strings.HasPrefix(ct, prefix) {
+			continue
+		}
+
+		ttl, best = d, len(prefix)
+	}
+
+	return ttl, best >= 0
+}
@@ -0,0 +1,129 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseContentTypeTTLs(t *testing.T) {
+	ttls, err := parseContentTypeTTLs(map[string]int{"Image/*": 300, "application/json": 60}, 300)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttls["image/*"] != 5*time.Minute || ttls["application/json"] != time.Minute {
+		t.Errorf("unexpected ttls: %v", ttls)
+	}
+
+	for _, invalid := range []map[string]int{
+		{"": 30},
+		{"*/json": 30},
+		{"text/html": 0},
+		{"text/html": 301},
+	} {
+		if _, err = parseContentTypeTTLs(invalid, 300); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestCache_ContentTypeTTL(t *testing.T) {
+	ttls, err := parseContentTypeTTLs(map[string]int{
+		"*":              10,
+		"image/*":        20,
+		"image/svg+xml":  30,
+		"application/*":  40,
+		"application/v":  50,
+		"application/j*": 60,
+	}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &cache{contentTypeTTLs: ttls}
+
+	tests := []struct {
+		contentType string
+		want        time.Duration
+	}{
+		{contentType: "image/svg+xml", want: 30 * time.Second},
+		{contentType: "IMAGE/SVG+XML; charset=utf-8", want: 30 * time.Second},
+		{contentType: "image/png", want: 20 * time.Second},
+		{contentType: "application/json", want: 60 * time.Second},
+		{contentType: "application/vnd.api+json", want: 40 * time.Second},
+		{contentType: "text/html", want: 10 * time.Second},
+		{contentType: "", want: 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got, ok := m.contentTypeTTL(test.contentType); !ok || got != test.want {
+			t.Errorf("%q: unexpected ttl: want %v, got %v (%t)", test.contentType, test.want, got, ok)
+		}
+	}
+
+	if _, ok := (&cache{}).contentTypeTTL("text/html"); ok {
+		t.Error("expected no ttl without content type ttls")
+	}
+}
+
+func TestCache_ServeHTTP_ContentTypeTTLs(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		proxy       string
+		statusTTL   bool
+		wantExpiry  time.Duration
+	}{
+		{name: "wildcard", contentType: "image/png", wantExpiry: 300 * time.Second},
+		{name: "exact", contentType: "application/json", wantExpiry: 60 * time.Second},
+		{name: "unlisted", contentType: "text/html", wantExpiry: 600 * time.Second},
+		{name: "origin cache header wins", contentType: "image/png", proxy: "max-age=30", wantExpiry: 30 * time.Second},
+		{name: "explicit status TTL wins", contentType: "image/png", statusTTL: true, wantExpiry: 120 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", test.contentType)
+				if test.proxy != "" {
+					rw.Header().Set("X-Proxy-Cache-Control", test.proxy)
+				}
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				Backend:                backendMemory,
+				MaxExpiry:              600,
+				Cleanup:                20,
+				ContentTypeTTLs:        map[string]int{"image/*": 300, "application/json": 60},
+				TrustOriginCacheHeader: true,
+				OriginCacheHeader:      "X-Proxy-Cache-Control",
+			}
+			if test.statusTTL {
+				cfg.StatusTTLs = map[string]int{"200": 120}
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			stat, ok := c.cache.Stat(c.cacheKey(req))
+			if !ok {
+				t.Fatal("expected the response to be stored")
+			}
+
+			if until := time.Until(stat.Expires); until > test.wantExpiry || until < test.wantExpiry-5*time.Second {
+				t.Errorf("unexpected expiry: want %v, got %v", test.wantExpiry, until)
+			}
+		})
+	}
+}
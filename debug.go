@@ -0,0 +1,37 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	// debugHeader is the request header asking for the reason a response
+	// isn't cached.
+	debugHeader = "X-Cache-Debug"
+	// reasonHeader is the response header holding that reason.
+	reasonHeader = "X-Cache-Reason"
+	// hitsHeader is the response header holding the number of hits on the
+	// served entry.
+	hitsHeader = "X-Cache-Hits"
+)
+
+// debugReason sets the reason the response to r isn't cached, if the
+// request asked for it and debugging is enabled.
+func (m *cache) debugReason(w http.ResponseWriter, r *http.Request, reason string) {
+	if !m.cfg.DebugHeader || reason == "" || r.Header.Get(debugHeader) != "1" {
+		return
+	}
+
+	w.Header().Set(reasonHeader, reason)
+}
+
+// debugHits sets the number of hits on the entry served to r, if the request
+// asked for it and debugging is enabled.
+func (m *cache) debugHits(w http.ResponseWriter, r *http.Request, hits uint64) {
+	if !m.cfg.DebugHeader || r.Header.Get(debugHeader) != "1" {
+		return
+	}
+
+	w.Header().Set(hitsHeader, strconv.FormatUint(hits, 10))
+}
@@ -0,0 +1,91 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTP_DebugHeader(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/error" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		method     string
+		path       string
+		debug      string
+		wantReason string
+	}{
+		{name: "disabled by default", method: http.MethodGet, path: "/error", debug: "1"},
+		{name: "not requested", enabled: true, method: http.MethodGet, path: "/error"},
+		{name: "uncacheable status", enabled: true, method: http.MethodGet, path: "/error", debug: "1", wantReason: "status 500 is not cacheable"},
+		{name: "bypassed method", enabled: true, method: http.MethodPost, path: "/ok", debug: "1", wantReason: "method POST is not cached"},
+		{name: "cacheable", enabled: true, method: http.MethodGet, path: "/ok", debug: "1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, DebugHeader: test.enabled}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(test.method, "http://localhost"+test.path, nil)
+			if test.debug != "" {
+				req.Header.Set("X-Cache-Debug", test.debug)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if reason := rw.Header().Get("X-Cache-Reason"); reason != test.wantReason {
+				t.Errorf("unexpected reason: want %q, got %q", test.wantReason, reason)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_DebugHits(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, DebugHeader: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	for _, want := range []string{"", "1", "2", "3"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil)
+		req.Header.Set("X-Cache-Debug", "1")
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if hits := rw.Header().Get("X-Cache-Hits"); hits != want {
+			t.Errorf("unexpected hits: want %q, got %q", want, hits)
+		}
+	}
+
+	// Hits are counted whether or not they are reported.
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+
+	stat, ok := c.cache.Stat(c.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil)))
+	if !ok || stat.Hits != 4 {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+}
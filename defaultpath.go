@@ -0,0 +1,107 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// defaultPathDir is the directory, under the temp directory, used when no path
+// is configured, suffixed with the middleware name.
+const defaultPathDir = "plugin-simplecache"
+
+// defaultPath returns the cache path of the middleware called name when no
+// path is configured, creating it if needed. As the temp directory is shared
+// with other users, a directory found there is refused unless it can be
+// trusted, see checkCacheDir.
+func defaultPath(name string, dirMode os.FileMode) (string, error) {
+	dir := defaultPathDir
+	if name = sanitizePathName(name); name != "" {
+		dir += "-" + name
+	}
+
+	path := filepath.Join(os.TempDir(), dir)
+
+	// Never let the group or others write to the directory, whatever the
+	// configured mode.
+	if err := os.MkdirAll(path, dirMode&^0022); err != nil {
+		return "", fmt.Errorf("error creating default cache path: %w", err)
+	}
+
+	if err := checkCacheDir(path); err != nil {
+		return "", fmt.Errorf("invalid default cache path %q: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// pathNameChars are the characters kept from a middleware name in the default
+// path.
+const pathNameChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+
+// sanitizePathName replaces the characters of a middleware name that aren't
+// safe in a path, such as separators, with underscores.
+func sanitizePathName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(pathNameChars, r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// checkCacheDir checks that the directory at path is neither a symlink, nor
+// owned by another user, nor writable by the group or others, so that no
+// other user can plant entries in it. Where the owner of a file can't be
+// read, e.g. on Windows, the directory is trusted.
+func checkCacheDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	uid, ok := fileOwner(info)
+	if !ok || os.Getuid() < 0 {
+		return nil
+	}
+
+	if uid != os.Getuid() {
+		return fmt.Errorf("owned by user %d", uid)
+	}
+
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("writable by group or others, mode %04o", info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// fileOwner returns the uid of the owner of the file described by info. The
+// owner is read through reflection, as Yaegi, which runs plugins in Traefik,
+// doesn't provide the syscall package the file information comes from.
+func fileOwner(info os.FileInfo) (int, bool) {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	uid := v.FieldByName("Uid")
+
+	switch uid.Kind() {
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(uid.Uint()), true
+	default:
+		return 0, false
+	}
+}
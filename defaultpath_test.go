@@ -0,0 +1,97 @@
+package plugin_simplecache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizePathName(t *testing.T) {
+	tests := map[string]string{
+		"simplecache":   "simplecache",
+		"my-cache@file": "my-cache_file",
+		"../etc/x":      ".._etc_x",
+		"":              "",
+	}
+
+	for name, want := range tests {
+		if got := sanitizePathName(name); got != want {
+			t.Errorf("sanitizePathName(%q): want %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestCheckCacheDir(t *testing.T) {
+	dir := createTempDir(t)
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCacheDir(dir); err != nil {
+		t.Errorf("unexpected error for a private directory: %v", err)
+	}
+
+	if _, ok := fileOwner(mustStat(t, dir)); !ok {
+		t.Skip("file owner unavailable on this platform")
+	}
+
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCacheDir(dir); err == nil || !strings.Contains(err.Error(), "writable") {
+		t.Errorf("expected a world-writable directory to be refused, got: %v", err)
+	}
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "link")
+
+	target, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkCacheDir(link); err == nil {
+		t.Error("expected a symlink to be refused")
+	}
+}
+
+func TestCheckCacheDir_Owner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a directory requires root")
+	}
+
+	dir := createTempDir(t)
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chown(dir, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCacheDir(dir); err == nil || !strings.Contains(err.Error(), "owned by user 65534") {
+		t.Errorf("expected a directory of another user to be refused, got: %v", err)
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
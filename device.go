@@ -0,0 +1,74 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultDevice is the device of user agents no rule matches.
+const defaultDevice = "desktop"
+
+// DeviceRule classifies the user agents containing any of the Match strings,
+// ignoring case, as Device.
+type DeviceRule struct {
+	Device string   `json:"device" yaml:"device" toml:"device"`
+	Match  []string `json:"match" yaml:"match" toml:"match"`
+}
+
+// defaultDeviceRules tell bots, tablets and mobiles apart. Order matters: iPads
+// also announce "Mobile", and Android tablets are the Android devices that
+// don't.
+var defaultDeviceRules = []DeviceRule{
+	{Device: "bot", Match: []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "mediapartners"}},
+	{Device: "tablet", Match: []string{"ipad", "tablet", "kindle", "silk", "playbook"}},
+	{Device: "mobile", Match: []string{"mobi", "iphone", "ipod", "windows phone", "blackberry", "opera mini"}},
+	{Device: "tablet", Match: []string{"android"}},
+}
+
+// parseDeviceRules validates the configured device rules, returning them with
+// lowercase match strings, or the default rules if none are configured.
+func parseDeviceRules(rules []DeviceRule) ([]DeviceRule, error) {
+	if len(rules) == 0 {
+		return defaultDeviceRules, nil
+	}
+
+	parsed := make([]DeviceRule, 0, len(rules))
+
+	for i, rule := range rules {
+		if rule.Device == "" {
+			return nil, fmt.Errorf("rule %d: device is required", i)
+		}
+
+		if len(rule.Match) == 0 {
+			return nil, fmt.Errorf("rule %d: match is required", i)
+		}
+
+		match := make([]string, 0, len(rule.Match))
+		for _, s := range rule.Match {
+			if s == "" {
+				return nil, fmt.Errorf("rule %d: empty match", i)
+			}
+			match = append(match, strings.ToLower(s))
+		}
+
+		parsed = append(parsed, DeviceRule{Device: rule.Device, Match: match})
+	}
+
+	return parsed, nil
+}
+
+// classifyDevice returns the device of the first rule matching the user
+// agent.
+func classifyDevice(ua string, rules []DeviceRule) string {
+	ua = strings.ToLower(ua)
+
+	for _, rule := range rules {
+		for _, s := range rule.Match {
+			if strings.Contains(ua, s) {
+				return rule.Device
+			}
+		}
+	}
+
+	return defaultDevice
+}
@@ -0,0 +1,129 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyDevice(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want string
+	}{
+		{name: "empty", ua: "", want: "desktop"},
+		{name: "windows chrome", ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", want: "desktop"},
+		{name: "macos safari", ua: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15", want: "desktop"},
+		{name: "iphone", ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", want: "mobile"},
+		{name: "android phone", ua: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", want: "mobile"},
+		{name: "ipad", ua: "Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", want: "tablet"},
+		{name: "android tablet", ua: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", want: "tablet"},
+		{name: "googlebot", ua: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", want: "bot"},
+		{name: "googlebot smartphone", ua: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", want: "bot"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := classifyDevice(test.ua, defaultDeviceRules); got != test.want {
+				t.Errorf("unexpected device: want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseDeviceRules(t *testing.T) {
+	rules, err := parseDeviceRules([]DeviceRule{{Device: "app", Match: []string{"MyApp/"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := classifyDevice("myapp/2.0 (iPhone)", rules); got != "app" {
+		t.Errorf("unexpected device: want %q, got %q", "app", got)
+	}
+
+	if got := classifyDevice("Mozilla/5.0 (iPhone)", rules); got != defaultDevice {
+		t.Errorf("unexpected device: want %q, got %q", defaultDevice, got)
+	}
+
+	for _, invalid := range [][]DeviceRule{
+		{{Match: []string{"x"}}},
+		{{Device: "app"}},
+		{{Device: "app", Match: []string{""}}},
+	} {
+		if _, err := parseDeviceRules(invalid); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestCache_cacheKey_VaryByDevice(t *testing.T) {
+	newRequest := func(ua string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		req.Header.Set("User-Agent", ua)
+
+		return req
+	}
+
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) Mobile/15E148"
+	pixel := "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/120.0.0.0 Mobile Safari/537.36"
+	desktop := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0.0.0 Safari/537.36"
+
+	m := &cache{cfg: &Config{VaryByDevice: true}, deviceRules: defaultDeviceRules}
+
+	if m.cacheKey(newRequest(iphone)) != m.cacheKey(newRequest(pixel)) {
+		t.Error("expected mobiles to share entries")
+	}
+
+	if m.cacheKey(newRequest(iphone)) == m.cacheKey(newRequest(desktop)) {
+		t.Error("expected mobiles and desktops not to share entries")
+	}
+
+	m = &cache{cfg: &Config{}}
+
+	if m.cacheKey(newRequest(iphone)) != m.cacheKey(newRequest(desktop)) {
+		t.Error("expected devices to share entries by default")
+	}
+}
+
+func TestCache_ServeHTTP_VaryByDevice(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Vary", "User-Agent")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(classifyDevice(req.Header.Get("User-Agent"), defaultDeviceRules)))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: varyModeKey, VaryByDevice: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ua        string
+		wantState string
+		wantBody  string
+	}{
+		{ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) Mobile/15E148", wantState: "miss", wantBody: "mobile"},
+		{ua: "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", wantState: "hit", wantBody: "mobile"},
+		{ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Safari/537.36", wantState: "miss", wantBody: "desktop"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		req.Header.Set("User-Agent", test.ua)
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("%s: unexpected cache state: want %q, got: %q", test.ua, test.wantState, state)
+		}
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("%s: unexpected body: want %q, got %q", test.ua, test.wantBody, body)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var errLowDiskSpace = errors.New("free disk space below minFreeDiskBytes")
+
+// diskFree returns the space available on the disk holding path. It is nil
+// where the free space can't be read, e.g. on Windows or under Yaegi, and set
+// by diskspace_statfs.go elsewhere.
+var diskFree func(path string) (uint64, error)
+
+// diskGuard keeps the file store from filling the disk it shares with other
+// data below a safety margin.
+type diskGuard struct {
+	// minFree is the free space, in bytes, left on the disk, 0 disabling the
+	// guard.
+	minFree uint64
+	// evict removes the expired entries before giving up on a write.
+	evict bool
+	// skips counts the writes given up on for lack of space.
+	skips uint64
+
+	// free returns the space available on the disk holding path.
+	free func(path string) (uint64, error)
+}
+
+// allow reports whether a value of size bytes may be written under the file
+// store c without leaving less than the margin free, removing the expired
+// entries first if enabled and needed.
+func (g *diskGuard) allow(c *fileCache, size int) bool {
+	if g == nil || g.minFree == 0 {
+		return true
+	}
+
+	if g.enough(c.path, size) {
+		return true
+	}
+
+	if g.evict && c.Cleanup(time.Now()) > 0 && g.enough(c.path, size) {
+		return true
+	}
+
+	atomic.AddUint64(&g.skips, 1)
+
+	return false
+}
+
+func (g *diskGuard) enough(path string, size int) bool {
+	free, err := g.free(path)
+	if err != nil {
+		// Failing to tell shouldn't stop the cache from working.
+		log.Printf("Error checking free disk space: %v", err)
+		return true
+	}
+
+	return free >= g.minFree+uint64(size)
+}
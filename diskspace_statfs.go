@@ -0,0 +1,24 @@
+//go:build (linux || darwin || freebsd) && gc
+// +build linux darwin freebsd
+// +build gc
+
+package plugin_simplecache
+
+import "syscall"
+
+// The gc constraint keeps this file away from Yaegi, which doesn't set it and
+// doesn't provide syscall to plugins.
+func init() {
+	diskFree = statfsFree
+}
+
+// statfsFree returns the space available to unprivileged users on the disk
+// holding path.
+func statfsFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,143 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileCache_MinFreeDiskBytes(t *testing.T) {
+	fc, err := newFileCache(t.TempDir(), time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	free := uint64(2048)
+	fc.guard = &diskGuard{minFree: 1024, free: func(string) (uint64, error) { return free, nil }}
+
+	if err = fc.Set("small", make([]byte, 512), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = fc.Set("large", make([]byte, 1536), time.Minute, entryMeta{}); !errors.Is(err, errLowDiskSpace) {
+		t.Fatalf("expected a low disk space error, got %v", err)
+	}
+
+	if _, err = fc.Get("large"); err == nil {
+		t.Error("expected the large entry not to be stored")
+	}
+
+	if n := atomic.LoadUint64(&fc.guard.skips); n != 1 {
+		t.Errorf("unexpected skips: want 1, got %d", n)
+	}
+
+	// Errors checking the free space let writes through.
+	fc.guard.free = func(string) (uint64, error) { return 0, errors.New("statfs failed") }
+
+	if err = fc.Set("large", make([]byte, 1536), time.Minute, entryMeta{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileCache_MinFreeDiskBytesEvict(t *testing.T) {
+	fc, err := newFileCache(t.TempDir(), time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fc.Set("expired", make([]byte, 1024), -time.Second, entryMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The disk frees up as expired entries are removed.
+	fc.guard = &diskGuard{minFree: 1024, evict: true, free: func(string) (uint64, error) {
+		if _, ok := fc.index.Get("expired"); ok {
+			return 1024, nil
+		}
+		return 4096, nil
+	}}
+
+	if err = fc.Set("new", make([]byte, 1024), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := fc.index.Get("expired"); ok {
+		t.Error("expected the expired entry to be evicted")
+	}
+
+	if n := atomic.LoadUint64(&fc.guard.skips); n != 0 {
+		t.Errorf("unexpected skips: want 0, got %d", n)
+	}
+}
+
+func TestCache_MinFreeDiskBytes(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(strings.Repeat("x", 100)))
+	}
+
+	defer func(free func(string) (uint64, error)) { diskFree = free }(diskFree)
+	diskFree = func(string) (uint64, error) { return 1 << 40, nil }
+
+	cfg := &Config{Path: t.TempDir(), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, MinFreeDiskBytes: 1, MetricsPath: "/metrics"}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+	c.cache.(*fileCache).guard.free = func(string) (uint64, error) { return 0, nil }
+
+	for _, want := range []string{"miss", "miss"} {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != want {
+			t.Errorf("unexpected cache state: want %q, got %q", want, state)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/metrics", nil))
+
+	var snapshot metricsSnapshot
+	if err = json.NewDecoder(rw.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.DiskSpaceSkips != 2 {
+		t.Errorf("unexpected disk space skips: want 2, got %d", snapshot.DiskSpaceSkips)
+	}
+}
+
+func TestNew_MinFreeDiskBytesStatfs(t *testing.T) {
+	if diskFree == nil {
+		t.Skip("free disk space unavailable on this platform")
+	}
+
+	if _, err := diskFree(os.TempDir()); err != nil {
+		t.Skipf("free disk space unavailable: %v", err)
+	}
+
+	cfg := &Config{Path: os.TempDir(), MaxExpiry: 10, Cleanup: 20, MinFreeDiskBytes: 1}
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNew_MinFreeDiskBytesUnavailable(t *testing.T) {
+	defer func(free func(string) (uint64, error)) { diskFree = free }(diskFree)
+	diskFree = nil
+
+	cfg := &Config{Path: t.TempDir(), MaxExpiry: 10, Cleanup: 20, MinFreeDiskBytes: 1}
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err == nil {
+		t.Error("expected an error")
+	}
+}
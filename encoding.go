@@ -0,0 +1,128 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptsEncoding reports whether a content-coding is acceptable to the
+// client as described in RFC 7231 5.3.4: any coding is acceptable without an
+// Accept-Encoding header, an empty one only accepts identity, "*" matches
+// the codings that aren't listed, and identity is acceptable unless excluded
+// by a q-value of 0.
+func acceptsEncoding(h http.Header, coding string) bool {
+	if _, ok := h["Accept-Encoding"]; !ok {
+		return true
+	}
+
+	codings := acceptedCodings(h)
+
+	codingQ, ok := codings[normalizeCoding(coding)]
+	wildcardQ, wildcard := codings["*"]
+
+	switch {
+	case ok:
+		return codingQ > 0
+	case wildcard:
+		return wildcardQ > 0
+	default:
+		// Unlike other unlisted codings, identity is acceptable.
+		return normalizeCoding(coding) == "identity"
+	}
+}
+
+// acceptedCodings returns the q-values of the content-codings listed in the
+// Accept-Encoding header, by normalized name.
+func acceptedCodings(h http.Header) map[string]float64 {
+	codings := map[string]float64{}
+
+	for _, val := range h.Values("Accept-Encoding") {
+		for _, part := range strings.Split(val, ",") {
+			params := strings.Split(part, ";")
+
+			name := normalizeCoding(params[0])
+			if name == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+
+				q = v
+			}
+
+			codings[name] = q
+		}
+	}
+
+	return codings
+}
+
+// encodingKey returns the canonical set of content-codings accepted by the
+// client, so that equivalent Accept-Encoding headers select the same variant:
+// the accepted codings, then the excluded ones, each sorted. The q-values of
+// accepted codings are left out, as any of them can be served.
+func encodingKey(h http.Header) string {
+	if _, ok := h["Accept-Encoding"]; !ok {
+		// Like "*", any coding is acceptable.
+		return "*"
+	}
+
+	var accepted, excluded []string
+	for name, q := range acceptedCodings(h) {
+		if q > 0 {
+			accepted = append(accepted, name)
+		} else {
+			excluded = append(excluded, name+";q=0")
+		}
+	}
+
+	sort.Strings(accepted)
+	sort.Strings(excluded)
+
+	return strings.Join(append(accepted, excluded...), ",")
+}
+
+// normalizeCoding returns the canonical name of a content-coding.
+func normalizeCoding(coding string) string {
+	coding = strings.ToLower(strings.TrimSpace(coding))
+
+	switch coding {
+	case "x-gzip":
+		return "gzip"
+	case "x-compress":
+		return "compress"
+	default:
+		return coding
+	}
+}
+
+// acceptsEncodings reports whether all the content-codings of a response
+// with the given headers are acceptable to the client.
+func acceptsEncodings(req, resp http.Header) bool {
+	codings := resp.Values("Content-Encoding")
+	if len(codings) == 0 {
+		return acceptsEncoding(req, "identity")
+	}
+
+	for _, val := range codings {
+		for _, coding := range strings.Split(val, ",") {
+			if normalizeCoding(coding) != "" && !acceptsEncoding(req, coding) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,185 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept []string
+		coding string
+		want   bool
+	}{
+		{name: "absent header accepts anything", coding: "br", want: true},
+		{name: "absent header accepts identity", coding: "identity", want: true},
+		{name: "empty header accepts identity", accept: []string{""}, coding: "identity", want: true},
+		{name: "empty header forbids codings", accept: []string{""}, coding: "gzip", want: false},
+		{name: "listed coding", accept: []string{"gzip, deflate"}, coding: "gzip", want: true},
+		{name: "x-gzip alias", accept: []string{"x-gzip"}, coding: "gzip", want: true},
+		{name: "unlisted coding", accept: []string{"gzip"}, coding: "br", want: false},
+		{name: "unlisted identity", accept: []string{"gzip"}, coding: "identity", want: true},
+		{name: "identity;q=0 forbids identity", accept: []string{"gzip, identity;q=0"}, coding: "identity", want: false},
+		{name: "coding;q=0 forbids coding", accept: []string{"gzip;q=0, br"}, coding: "gzip", want: false},
+		{name: "* accepts anything", accept: []string{"*"}, coding: "zstd", want: true},
+		{name: "* accepts identity", accept: []string{"*"}, coding: "identity", want: true},
+		{name: "*;q=0 forbids identity", accept: []string{"gzip, *;q=0"}, coding: "identity", want: false},
+		{name: "listed identity overrides *;q=0", accept: []string{"identity, *;q=0"}, coding: "identity", want: true},
+		{name: "listed coding overrides *", accept: []string{"*, br;q=0"}, coding: "br", want: false},
+		{name: "multiple header lines", accept: []string{"gzip", "br;q=0.5"}, coding: "br", want: true},
+		{name: "case insensitive", accept: []string{"GZIP"}, coding: "gzip", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			if test.accept != nil {
+				h["Accept-Encoding"] = test.accept
+			}
+
+			if got := acceptsEncoding(h, test.coding); got != test.want {
+				t.Errorf("unexpected acceptability of %q with %q: want %t, got %t", test.coding, test.accept, test.want, got)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_ForbiddenEncoding(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		_, _ = rw.Write([]byte("compressed"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	tests := []struct {
+		accept    string
+		wantState string
+	}{
+		{accept: "gzip", wantState: "hit"},
+		{accept: "*", wantState: "hit"},
+		{accept: "br", wantState: "miss"},
+		{accept: "gzip;q=0, *", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %q: want %q, got %q", test.accept, test.wantState, state)
+		}
+	}
+}
+
+func TestEncodingKey(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{accept: nil, want: "*"},
+		{accept: []string{""}, want: ""},
+		{accept: []string{"gzip"}, want: "gzip"},
+		{accept: []string{"x-gzip;q=0.5"}, want: "gzip"},
+		{accept: []string{"gzip, br"}, want: "br,gzip"},
+		{accept: []string{"br;q=0.8", "GZIP"}, want: "br,gzip"},
+		{accept: []string{"gzip, identity;q=0"}, want: "gzip,identity;q=0"},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		if test.accept != nil {
+			h["Accept-Encoding"] = test.accept
+		}
+
+		if got := encodingKey(h); got != test.want {
+			t.Errorf("unexpected key for %q: want %q, got %q", test.accept, test.want, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_VaryAcceptEncoding(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+
+		coding := "identity"
+		for _, c := range []string{"br", "gzip"} {
+			if acceptsEncoding(req.Header, c) && req.Header.Get("Accept-Encoding") != "" {
+				coding = c
+				break
+			}
+		}
+
+		rw.Header().Set("Vary", "Accept-Encoding")
+		if coding != "identity" {
+			rw.Header().Set("Content-Encoding", coding)
+		}
+		_, _ = rw.Write([]byte(coding))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: varyModeKey}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accept    string
+		wantBody  string
+		wantState string
+		wantCalls int
+	}{
+		{accept: "gzip", wantBody: "gzip", wantState: "miss", wantCalls: 1},
+		{accept: "x-gzip", wantBody: "gzip", wantState: "hit", wantCalls: 1},
+		{accept: "gzip, br", wantBody: "br", wantState: "miss", wantCalls: 2},
+		{accept: "br;q=0.9, gzip", wantBody: "br", wantState: "hit", wantCalls: 2},
+		{accept: "", wantBody: "identity", wantState: "miss", wantCalls: 3},
+		{accept: "", wantBody: "identity", wantState: "hit", wantCalls: 3},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, req)
+
+		if rw.Body.String() != test.wantBody {
+			t.Errorf("unexpected body for %q: want %q, got %q", test.accept, test.wantBody, rw.Body.String())
+		}
+
+		wantEncoding := test.wantBody
+		if wantEncoding == "identity" {
+			wantEncoding = ""
+		}
+
+		if got := rw.Header().Get("Content-Encoding"); got != wantEncoding {
+			t.Errorf("unexpected Content-Encoding for %q: want %q, got %q", test.accept, wantEncoding, got)
+		}
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %q: want %q, got %q", test.accept, test.wantState, state)
+		}
+
+		if calls != test.wantCalls {
+			t.Errorf("unexpected origin calls for %q: want %d, got %d", test.accept, test.wantCalls, calls)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	eventHit   = "hit"
+	eventMiss  = "miss"
+	eventStore = "store"
+	eventEvict = "evict"
+	eventError = "error"
+
+	// eventQueueSize is the number of webhook events queued before new
+	// events are dropped.
+	eventQueueSize = 256
+	// eventTimeout bounds the delivery of a single webhook event.
+	eventTimeout = 5 * time.Second
+)
+
+// event describes something that happened to a cache entry.
+type event struct {
+	Type       string    `json:"type"`
+	Key        string    `json:"key"`
+	Size       int       `json:"size"`
+	DurationMs float64   `json:"durationMs"`
+	Time       time.Time `json:"time"`
+}
+
+// eventSink records cache events. Implementations must not block.
+type eventSink interface {
+	OnHit(e event)
+	OnMiss(e event)
+	OnStore(e event)
+	OnEvict(e event)
+	OnError(e event)
+}
+
+// newEvent returns an event of the given type for an operation that started
+// at start.
+func newEvent(typ, key string, size int, start time.Time) event {
+	now := time.Now()
+
+	return event{
+		Type:       typ,
+		Key:        key,
+		Size:       size,
+		DurationMs: float64(now.Sub(start)) / float64(time.Millisecond),
+		Time:       now,
+	}
+}
+
+// nopSink discards events.
+type nopSink struct{}
+
+func (nopSink) OnHit(event)   {}
+func (nopSink) OnMiss(event)  {}
+func (nopSink) OnStore(event) {}
+func (nopSink) OnEvict(event) {}
+func (nopSink) OnError(event) {}
+
+func validateEventWebhook(cfg *Config) error {
+	if cfg.EventWebhook == "" {
+		return nil
+	}
+
+	u, err := url.Parse(cfg.EventWebhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid eventWebhook %q", cfg.EventWebhook)
+	}
+
+	return nil
+}
+
+// webhookSink POSTs events as JSON to a URL from a background goroutine.
+// Events are dropped rather than blocking requests when the webhook can't
+// keep up.
+type webhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan event
+
+	dropped uint64
+}
+
+func newWebhookSink(url string) *webhookSink {
+	s := &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: eventTimeout},
+		queue:  make(chan event, eventQueueSize),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *webhookSink) OnHit(e event)   { s.enqueue(e) }
+func (s *webhookSink) OnMiss(e event)  { s.enqueue(e) }
+func (s *webhookSink) OnStore(e event) { s.enqueue(e) }
+func (s *webhookSink) OnEvict(e event) { s.enqueue(e) }
+func (s *webhookSink) OnError(e event) { s.enqueue(e) }
+
+func (s *webhookSink) enqueue(e event) {
+	select {
+	case s.queue <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *webhookSink) run() {
+	for e := range s.queue {
+		if err := s.post(e); err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *webhookSink) post(e event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected webhook status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_EventWebhook(t *testing.T) {
+	events := make(chan event, 10)
+
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var e event
+		if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+			t.Errorf("unexpected event: %v", err)
+		}
+
+		events <- e
+	}))
+	defer hook.Close()
+
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, EventWebhook: hook.URL, PurgePath: "/_purge"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "http://localhost/_purge?pattern=/page", nil))
+
+	for _, want := range []string{eventMiss, eventStore, eventHit, eventEvict} {
+		select {
+		case e := <-events:
+			if e.Type != want || e.Key != "GETlocalhost/page" {
+				t.Errorf("unexpected event: want %s, got %+v", want, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing %s event", want)
+		}
+	}
+}
+
+func TestWebhookSink_DropsUnderBackpressure(t *testing.T) {
+	// Nothing consumes the queue.
+	s := &webhookSink{queue: make(chan event, 1)}
+
+	s.OnHit(event{Type: eventHit})
+	s.OnMiss(event{Type: eventMiss})
+	s.OnStore(event{Type: eventStore})
+
+	if s.dropped != 2 {
+		t.Errorf("unexpected dropped events: want 2, got %d", s.dropped)
+	}
+}
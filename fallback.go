@@ -0,0 +1,67 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// onErrorPassthrough serves requests whose cache entry can't be read
+	// from next, as on a miss.
+	onErrorPassthrough = "passthrough"
+	// onErrorServeFallback serves the configured fallback response instead.
+	onErrorServeFallback = "serve-fallback"
+)
+
+func validateFallback(cfg *Config) error {
+	switch cfg.OnErrorBehavior {
+	case "", onErrorPassthrough, onErrorServeFallback:
+	default:
+		return fmt.Errorf("invalid onErrorBehavior %q", cfg.OnErrorBehavior)
+	}
+
+	if cfg.FallbackStatus != 0 && (cfg.FallbackStatus < 100 || cfg.FallbackStatus > 599) {
+		return fmt.Errorf("invalid fallbackStatus %d", cfg.FallbackStatus)
+	}
+
+	return nil
+}
+
+// serveFallback serves the fallback response to a request whose cache entry
+// couldn't be read, reporting whether it did.
+func (m *cache) serveFallback(w http.ResponseWriter, r *http.Request) bool {
+	if m.cfg.OnErrorBehavior != onErrorServeFallback || !m.fallbackPath(r.URL.Path) {
+		return false
+	}
+
+	status := m.cfg.FallbackStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+
+	if m.addStatusHeader(cacheErrorStatus, false) {
+		m.setStatusHeader(w, r, cacheErrorStatus, statusMember{detail: cacheErrorStatus})
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(m.cfg.FallbackBody))
+
+	return true
+}
+
+// fallbackPath reports whether the fallback response applies to path, which
+// is the case of all paths when no prefixes are configured.
+func (m *cache) fallbackPath(path string) bool {
+	if len(m.cfg.FallbackPaths) == 0 {
+		return true
+	}
+
+	for _, prefix := range m.cfg.FallbackPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
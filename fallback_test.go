@@ -0,0 +1,71 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_OnErrorBehavior(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("origin"))
+	}
+
+	tests := []struct {
+		name       string
+		behavior   string
+		paths      []string
+		wantStatus int
+		wantBody   string
+		wantState  string
+	}{
+		{name: "passthrough", behavior: "passthrough", wantStatus: http.StatusOK, wantBody: "origin", wantState: "error"},
+		{name: "serve-fallback", behavior: "serve-fallback", wantStatus: http.StatusServiceUnavailable, wantBody: "try later", wantState: "error"},
+		{name: "serve-fallback on protected path", behavior: "serve-fallback", paths: []string{"/protected"}, wantStatus: http.StatusServiceUnavailable, wantBody: "try later", wantState: "error"},
+		{name: "serve-fallback on other path", behavior: "serve-fallback", paths: []string{"/other"}, wantStatus: http.StatusOK, wantBody: "origin", wantState: "error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			cfg := &Config{
+				Path:            dir,
+				MaxExpiry:       10,
+				Cleanup:         20,
+				AddStatusHeader: true,
+				OnErrorBehavior: test.behavior,
+				FallbackPaths:   test.paths,
+				FallbackStatus:  http.StatusServiceUnavailable,
+				FallbackBody:    "try later",
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/protected/page", nil)
+
+			// Store an entry that can't be decoded.
+			if err = c.cache.Set(c.cacheKey(req), []byte("not json"), time.Minute, entryMeta{}); err != nil {
+				t.Fatal(err)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatus || rw.Body.String() != test.wantBody {
+				t.Errorf("unexpected response: want %d %q, got %d %q", test.wantStatus, test.wantBody, rw.Code, rw.Body.String())
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+		})
+	}
+}
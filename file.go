@@ -2,27 +2,65 @@
 package plugin_simplecache
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-var errCacheMiss = errors.New("cache miss")
+var (
+	errCacheMiss       = errors.New("cache miss")
+	errWritesSuspended = errors.New("cache writes suspended after repeated failures")
+)
+
+// entryVersion is the version of the entry format and key scheme, to be
+// bumped whenever either changes so that entries written by other versions
+// are never read back.
+const entryVersion = 2
+
+const (
+	// tmpSuffix marks files that are still being written.
+	tmpSuffix = ".tmp"
+	// tmpMaxAge is the age after which a temp file is considered left over
+	// by a failed or crashed write.
+	tmpMaxAge = time.Minute
+	// variantsSuffix names the directory holding the variants of a response
+	// stored per variant, next to the file of its variant marker.
+	variantsSuffix = ".variants"
+)
 
 type fileCache struct {
-	path string
-	pm   *pathMutex
+	path     string
+	pm       *pathMutex
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	breaker  *writeBreaker
+	index    *index
+	// guard, if any, skips writes when the disk is low on free space.
+	guard *diskGuard
+
+	// batchSize bounds the number of entries removed per cleanup run, 0
+	// meaning no limit.
+	batchSize int
+
+	hookMu  sync.Mutex
+	onEvict func(key string, size int)
 }
 
-func newFileCache(path string, vacuum time.Duration) (*fileCache, error) {
+func newFileCache(path string, vacuum time.Duration, batchSize int, fileMode, dirMode os.FileMode) (*fileCache, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("invalid cache path: %w", err)
@@ -33,8 +71,14 @@ func newFileCache(path string, vacuum time.Duration) (*fileCache, error) {
 	}
 
 	fc := &fileCache{
-		path: path,
-		pm:   &pathMutex{lock: map[string]*fileLock{}},
+		path:     path,
+		pm:       &pathMutex{lock: map[string]*fileLock{}},
+		fileMode: fileMode,
+		dirMode:  dirMode,
+		breaker:  &writeBreaker{threshold: 5, cooldown: 30 * time.Second},
+		index:    &index{entries: map[string]*indexEntry{}},
+
+		batchSize: batchSize,
 	}
 
 	go fc.vacuum(vacuum)
@@ -43,44 +87,162 @@ func newFileCache(path string, vacuum time.Duration) (*fileCache, error) {
 }
 
 func (c *fileCache) vacuum(interval time.Duration) {
+	c.load()
+
 	timer := time.NewTicker(interval)
 	defer timer.Stop()
 
 	for range timer.C {
-		_ = filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
-			switch {
-			case err != nil:
-				return err
-			case info.IsDir():
-				return nil
-			}
+		c.cleanupBatch(time.Now())
+	}
+}
 
-			mu := c.pm.MutexAt(filepath.Base(path))
-			mu.Lock()
-			defer mu.Unlock()
+// Cleanup runs cleanupBatch until all entries expired at now are removed,
+// returning the number of removed entries.
+func (c *fileCache) Cleanup(now time.Time) int {
+	var total int
 
-			// Get the expiry.
-			var t [8]byte
-			f, err := os.Open(filepath.Clean(path))
-			if err != nil {
-				// Just skip the file in this case.
-				return nil // nolint:nilerr // skip
-			}
-			if n, err := f.Read(t[:]); err != nil && n != 8 {
-				return nil
-			}
-			_ = f.Close()
+	for {
+		n := c.cleanupBatch(now)
+		total += n
+
+		if c.batchSize == 0 || n < c.batchSize {
+			return total
+		}
+	}
+}
+
+// cleanupBatch removes up to batchSize expired entries, as found in the
+// index, so each run only touches the files it removes. Entries left over by
+// a full batch are removed by the next runs.
+func (c *fileCache) cleanupBatch(now time.Time) int {
+	var n int
+
+	for _, key := range c.index.Expired(now, c.batchSize) {
+		if c.removeExpired(key, now) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// removeExpired removes the entry stored under key if it is still expired on
+// disk, as it may have been rewritten by another instance sharing the path.
+func (c *fileCache) removeExpired(key string, now time.Time) bool {
+	mu := c.pm.MutexAt(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	p := keyPath(c.path, key)
+
+	expires, meta, err := readHeader(p)
+	if os.IsNotExist(err) {
+		// Already removed, e.g. by a concurrent cleanup.
+		c.index.Delete(key)
+		return false
+	}
+
+	// The indexed expiry of the entry is trusted over the one on disk, unless
+	// it was rewritten meanwhile, e.g. by another instance.
+	if err == nil && meta.Key == key && !c.indexed(meta) && !expires.Before(now) {
+		c.index.Put(key, &indexEntry{meta: meta, expires: monotonic(expires)})
+		return false
+	}
+
+	c.index.Delete(key)
+
+	if err == nil && meta.Key != key {
+		// Replaced by a colliding key, leave it to its owner.
+		return false
+	}
+
+	_ = os.Remove(p)
+	c.evicted(key, meta.Size)
+
+	return true
+}
 
-			expires := time.Unix(int64(binary.LittleEndian.Uint64(t[:])), 0)
-			if !expires.Before(time.Now()) {
-				return nil
+// indexed reports whether the entry described by meta is the one indexed,
+// rather than one written by another instance.
+func (c *fileCache) indexed(meta entryMeta) bool {
+	e, ok := c.index.Get(meta.Key)
+
+	return ok && e.meta.Stored == meta.Stored
+}
+
+// monotonic returns the in-process equivalent of the wall clock expiry
+// read from disk. Being based on the monotonic clock, it isn't affected by
+// later changes of the system clock.
+func monotonic(expires time.Time) time.Time {
+	return time.Now().Add(time.Until(expires))
+}
+
+// OnEvict sets the function called when an entry is removed from the cache.
+func (c *fileCache) OnEvict(fn func(key string, size int)) {
+	c.hookMu.Lock()
+	defer c.hookMu.Unlock()
+
+	c.onEvict = fn
+}
+
+func (c *fileCache) evicted(key string, size int) {
+	c.hookMu.Lock()
+	fn := c.onEvict
+	c.hookMu.Unlock()
+
+	if fn != nil {
+		fn(key, size)
+	}
+}
+
+// load indexes the entries already on disk and removes temp files left over
+// by a previous crash. Entries written meanwhile are already indexed.
+func (c *fileCache) load() {
+	_ = filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+			return nil
+		case strings.HasSuffix(path, tmpSuffix):
+			removeStaleTemp(path, info)
+			return nil
+		}
+
+		expires, meta, err := readHeader(path)
+		if err != nil || meta.Key == "" {
+			// Not an entry, leave it alone.
+			return nil
+		}
+
+		// Entries of newer versions may belong to another instance sharing
+		// the path, only remove older ones.
+		if meta.Version != entryVersion {
+			if meta.Version < entryVersion {
+				_ = os.Remove(path)
 			}
+			return nil
+		}
 
-			// Delete the file.
-			_ = os.Remove(path)
+		if keyPath(c.path, meta.Key) != filepath.Clean(path) {
 			return nil
-		})
+		}
+
+		c.index.Add(meta.Key, &indexEntry{meta: meta, expires: monotonic(expires)})
+
+		return nil
+	})
+}
+
+// removeStaleTemp removes a temp file unless it is recent enough to belong to
+// a write still in progress, possibly from another instance sharing the path.
+func removeStaleTemp(path string, info os.FileInfo) {
+	if time.Since(info.ModTime()) < tmpMaxAge {
+		return
 	}
+
+	_ = os.Remove(path)
 }
 
 func (c *fileCache) Get(key string) ([]byte, error) {
@@ -98,41 +260,324 @@ func (c *fileCache) Get(key string) ([]byte, error) {
 		return nil, fmt.Errorf("error reading file %q: %w", p, err)
 	}
 
-	expires := time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0)
-	if expires.Before(time.Now()) {
+	expires, meta, val, err := decodeEntry(b)
+	if err != nil || meta.Key != key || meta.Version != entryVersion {
+		// Written by another version or for a colliding key.
+		return nil, errCacheMiss
+	}
+
+	// The indexed expiry isn't affected by clock changes, see monotonic.
+	expired := expires.Before(time.Now())
+	if e, ok := c.index.Get(key); ok && e.meta.Stored == meta.Stored {
+		expired = e.expires.Before(time.Now())
+	}
+
+	if expired {
 		_ = os.Remove(p)
+		c.index.Delete(key)
+		c.evicted(key, meta.Size)
 		return nil, errCacheMiss
 	}
 
-	return b[8:], nil
+	return val, nil
+}
+
+// Set stores val under key for the given expiry. The metadata is kept in
+// the index so entries can be looked up without reading them.
+//
+// The previous value remains readable until the new one is swapped in: the
+// entry is written to a temp file without holding the key lock, then renamed
+// into place atomically. An identical response, as told by meta.Hash, isn't
+// rewritten, only its indexed expiry and fetch times are updated.
+func (c *fileCache) Set(key string, val []byte, expiry time.Duration, meta entryMeta) error {
+	if !c.breaker.Allow() {
+		return errWritesSuspended
+	}
+
+	now := time.Now()
+
+	meta.Key = key
+	meta.Stored = now.Unix()
+	if meta.Hash == "" {
+		meta.Hash = hashBytes(val)
+	}
+
+	if c.extend(key, meta, now.Add(expiry)) {
+		return nil
+	}
+
+	return c.write(val, now.Add(expiry), meta)
+}
+
+// write stores val under meta.Key until expires, unless the disk is low on
+// space, recording the outcome in the write breaker.
+func (c *fileCache) write(val []byte, expires time.Time, meta entryMeta) error {
+	if !c.guard.allow(c, len(val)) {
+		return errLowDiskSpace
+	}
+
+	err := c.put(val, expires, meta)
+
+	c.breaker.Record(err)
+
+	return err
 }
 
-func (c *fileCache) Set(key string, val []byte, expiry time.Duration) error {
+// put stores val under meta.Key until expires.
+func (c *fileCache) put(val []byte, expires time.Time, meta entryMeta) error {
+	meta.Size = len(val)
+	meta.Version = entryVersion
+
+	p := keyPath(c.path, meta.Key)
+
+	tmp, err := c.writeTemp(p, val, expires, meta)
+	if err != nil {
+		return err
+	}
+
+	return c.swap(meta.Key, tmp, p, &indexEntry{meta: meta, expires: expires})
+}
+
+// extend extends the expiry of the entry stored under key to expires and
+// updates its indexed fetch times to those of meta, if it has the hash of
+// meta, reporting whether it did. Only the expiry is rewritten on disk, in
+// place, for exports and restarts to find the entry; the value is left
+// untouched, and so are the fetch times on disk.
+func (c *fileCache) extend(key string, meta entryMeta, expires time.Time) bool {
 	mu := c.pm.MutexAt(key)
 	mu.Lock()
 	defer mu.Unlock()
 
+	e, ok := c.index.Get(key)
+	if !ok || e.meta.Hash != meta.Hash {
+		return false
+	}
+
+	// The file may have been rewritten meanwhile, e.g. by another instance.
 	p := keyPath(c.path, key)
-	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
-		return fmt.Errorf("error creating file path: %w", err)
+
+	_, stored, err := readHeader(p)
+	if err != nil || stored.Key != key || stored.Stored != e.meta.Stored || stored.Hash != meta.Hash {
+		return false
+	}
+
+	if err = writeExpiry(p, expires); err != nil {
+		return false
+	}
+
+	extended := e.meta
+	extended.Fetch = meta.Fetch
+
+	c.index.Put(key, &indexEntry{
+		meta:    extended,
+		expires: expires,
+		hits:    atomic.LoadUint64(&e.hits),
+		used:    atomic.LoadInt64(&e.used),
+	})
+
+	return true
+}
+
+// swap renames the temp file tmp to p and indexes it under key.
+func (c *fileCache) swap(key, tmp, p string, e *indexEntry) error {
+	mu := c.pm.MutexAt(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+
+	c.index.Put(key, e)
+
+	return nil
+}
+
+// Stat returns the description of the entry stored under key, as found in
+// the index, without reading it.
+func (c *fileCache) Stat(key string) (entryStat, bool) {
+	e, ok := c.index.Get(key)
+	if !ok || e.expires.Before(time.Now()) {
+		return entryStat{}, false
+	}
+
+	stat := e.stat()
+	stat.Variants = c.index.VariantCount(key)
+
+	return stat, true
+}
+
+// Variants returns the description of the variants of the response stored
+// under key.
+func (c *fileCache) Variants(key string) []entryStat {
+	return c.index.Variants(key)
+}
+
+// Hit counts a hit on the entry stored under key, returning its hit count.
+func (c *fileCache) Hit(key string) uint64 {
+	return c.index.Hit(key)
+}
+
+// Delete removes the entry stored under key.
+func (c *fileCache) Delete(key string) {
+	mu := c.pm.MutexAt(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var size int
+	if e, ok := c.index.Get(key); ok {
+		size = e.meta.Size
+	}
+
+	err := os.Remove(keyPath(c.path, key))
+	c.index.Delete(key)
+
+	if err == nil {
+		c.evicted(key, size)
+	}
+}
+
+// HostUsage returns the total size of the entries of each host.
+func (c *fileCache) HostUsage() map[string]int {
+	return c.index.HostUsage()
+}
+
+// Trim removes the oldest entries of host until their total size doesn't
+// exceed max, returning the number of removed entries.
+func (c *fileCache) Trim(host string, max int) int {
+	keys := c.index.Over(host, max)
+	for _, key := range keys {
+		c.Delete(key)
+	}
+
+	return len(keys)
+}
+
+// Purge deletes up to limit entries whose metadata matches, returning the
+// keys of the deleted entries. The variants of a deleted response are
+// deleted along with it, see PurgeKey.
+func (c *fileCache) Purge(match func(entryMeta) bool, limit int) []string {
+	var keys []string
+
+	deleted := map[string]bool{}
+	for _, meta := range c.index.Metas() {
+		if len(keys) >= limit {
+			break
+		}
+
+		if deleted[meta.Key] || !match(meta) {
+			continue
+		}
+
+		for _, key := range c.PurgeKey(meta.Key) {
+			deleted[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// PurgeKey deletes the entry stored under key, returning the keys of the
+// deleted entries. Unless key is a variant's, the directory holding the
+// variants of the response is removed as well.
+func (c *fileCache) PurgeKey(key string) []string {
+	var keys []string
+
+	if _, _, ok := splitVariantKey(key); !ok {
+		keys = c.deleteVariants(key)
 	}
 
-	f, err := os.OpenFile(filepath.Clean(p), os.O_RDWR|os.O_CREATE, 0600)
+	if _, ok := c.index.Get(key); ok {
+		c.Delete(key)
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// deleteVariants deletes the variants of the response stored under key and
+// removes their directory, returning the keys of the deleted variants.
+func (c *fileCache) deleteVariants(key string) []string {
+	dir := variantsDir(c.path, key)
+
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("error creating file: %w", err)
+		return nil
 	}
 
-	defer func() {
+	var keys []string
+
+	for _, info := range files {
+		if info.IsDir() || strings.HasSuffix(info.Name(), tmpSuffix) {
+			continue
+		}
+
+		p := filepath.Join(dir, info.Name())
+
+		_, meta, err := readHeader(p)
+		if err != nil || meta.Key == "" || keyPath(c.path, meta.Key) != p {
+			continue
+		}
+
+		c.Delete(meta.Key)
+		keys = append(keys, meta.Key)
+	}
+
+	// Left in place if a variant is being written meanwhile.
+	_ = os.Remove(dir)
+
+	return keys
+}
+
+// writeTemp writes an entry to a temp file next to p, to be renamed to p so
+// that readers never see a partially written entry. The temp file is removed
+// if anything fails.
+func (c *fileCache) writeTemp(p string, val []byte, expires time.Time, meta entryMeta) (string, error) {
+	if err := c.mkdirAll(filepath.Dir(p)); err != nil {
+		return "", fmt.Errorf("error creating file path: %w", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*"+tmpSuffix)
+	if err != nil {
+		return "", fmt.Errorf("error creating file: %w", err)
+	}
+
+	tmp := f.Name()
+
+	if err = writeEntry(f, c.fileMode, val, expires, meta); err != nil {
 		_ = f.Close()
-	}()
+		_ = os.Remove(tmp)
+		return "", err
+	}
 
-	timestamp := uint64(time.Now().Add(expiry).Unix())
+	if err = f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("error writing file: %w", err)
+	}
 
-	var t [8]byte
+	return tmp, nil
+}
+
+// An entry file starts with a header made of the expiry as a little-endian
+// unix timestamp, the length of the metadata as a little-endian uint32 and
+// the JSON encoded metadata. The value follows.
+const maxMetaLen = 64 << 10
 
-	binary.LittleEndian.PutUint64(t[:], timestamp)
+func writeEntry(f *os.File, mode os.FileMode, val []byte, expires time.Time, meta entryMeta) error {
+	// Temp files are created 0600 and OpenFile modes are subject to the
+	// umask, so set the mode explicitly.
+	if err := f.Chmod(mode); err != nil {
+		return fmt.Errorf("error setting file mode: %w", err)
+	}
 
-	if _, err = f.Write(t[:]); err != nil {
+	h, err := entryHeader(expires, meta)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(h); err != nil {
 		return fmt.Errorf("error writing file: %w", err)
 	}
 
@@ -143,8 +588,132 @@ func (c *fileCache) Set(key string, val []byte, expiry time.Duration) error {
 	return nil
 }
 
+// entryHeader encodes the header of an entry.
+func entryHeader(expires time.Time, meta entryMeta) ([]byte, error) {
+	m, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding entry metadata: %w", err)
+	}
+
+	if len(m) > maxMetaLen {
+		return nil, errors.New("entry metadata too large")
+	}
+
+	h := make([]byte, 12, 12+len(m))
+
+	binary.LittleEndian.PutUint64(h[:8], uint64(expires.Unix()))
+	binary.LittleEndian.PutUint32(h[8:12], uint32(len(m)))
+
+	return append(h, m...), nil
+}
+
+// writeExpiry rewrites the expiry at the start of the header of the entry
+// file at path.
+func writeExpiry(path string, expires time.Time) error {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(expires.Unix()))
+
+	if _, err = f.WriteAt(b, 0); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// decodeEntry decodes the expiry, metadata and value of an entry file.
+func decodeEntry(b []byte) (time.Time, entryMeta, []byte, error) {
+	var meta entryMeta
+
+	if len(b) < 12 {
+		return time.Time{}, meta, nil, errors.New("short entry header")
+	}
+
+	n := binary.LittleEndian.Uint32(b[8:12])
+	if n > maxMetaLen || int(n) > len(b)-12 {
+		return time.Time{}, meta, nil, errors.New("invalid entry metadata length")
+	}
+
+	if err := json.Unmarshal(b[12:12+n], &meta); err != nil {
+		return time.Time{}, meta, nil, fmt.Errorf("invalid entry metadata: %w", err)
+	}
+
+	expires := time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0)
+
+	return expires, meta, b[12+n:], nil
+}
+
+// readHeader reads the expiry and metadata of an entry file without
+// reading its value.
+func readHeader(path string) (time.Time, entryMeta, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return time.Time{}, entryMeta{}, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := make([]byte, 12)
+	if _, err = io.ReadFull(f, h); err != nil {
+		return time.Time{}, entryMeta{}, err
+	}
+
+	n := binary.LittleEndian.Uint32(h[8:12])
+	if n > maxMetaLen {
+		return time.Time{}, entryMeta{}, errors.New("invalid entry metadata length")
+	}
+
+	b := make([]byte, 12+n)
+	copy(b, h)
+
+	if _, err = io.ReadFull(f, b[12:]); err != nil {
+		return time.Time{}, entryMeta{}, err
+	}
+
+	expires, meta, _, err := decodeEntry(b)
+
+	return expires, meta, err
+}
+
+// mkdirAll creates dir and any missing parents below the cache path with the
+// configured directory mode, regardless of the process umask.
+func (c *fileCache) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, c.dirMode); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(c.path, dir)
+	if err != nil {
+		return err
+	}
+
+	p := c.path
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+
+		if err = os.Chmod(p, c.dirMode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyHash hashes key along with the entry format version, so that entries of
+// different versions never share a file.
 func keyHash(key string) [4]byte {
-	h := crc32.Checksum([]byte(key), crc32.IEEETable)
+	h := crc32.Checksum([]byte(strconv.Itoa(entryVersion)+":"+key), crc32.IEEETable)
 
 	var b [4]byte
 
@@ -153,9 +722,17 @@ func keyHash(key string) [4]byte {
 	return b
 }
 
+// keyPath returns the path of the file of the entry stored under key. The
+// variants of a response are stored in a directory of their own, one file
+// per variant named by the hash of its signature, so that they can be found
+// and purged together.
 func keyPath(path, key string) string {
+	if base, variant, ok := splitVariantKey(key); ok {
+		h := keyHash(variant)
+		return filepath.Join(variantsDir(path, base), hex.EncodeToString(h[:]))
+	}
+
 	h := keyHash(key)
-	key = strings.NewReplacer("/", "-", ":", "_").Replace(key)
 
 	return filepath.Join(
 		path,
@@ -163,10 +740,41 @@ func keyPath(path, key string) string {
 		hex.EncodeToString(h[1:2]),
 		hex.EncodeToString(h[2:3]),
 		hex.EncodeToString(h[3:4]),
-		key,
+		fileName(key),
 	)
 }
 
+// maxFileNameLen is the maximum length of the name of an entry file, leaving
+// room for the suffix of its temp file within the usual 255 bytes limit.
+const maxFileNameLen = 240
+
+// fileName returns the name of the file of the entry stored under key. Names
+// too long for the filesystem are cut and suffixed with the hash of the whole
+// key, which is checked on reading anyway.
+func fileName(key string) string {
+	name := strings.NewReplacer("/", "-", ":", "_").Replace(key)
+	if len(name) <= maxFileNameLen {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	suffix := "~" + hex.EncodeToString(sum[:])
+
+	// Cut on a rune boundary, as some filesystems require valid UTF-8.
+	n := maxFileNameLen - len(suffix)
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+
+	return name[:n] + suffix
+}
+
+// variantsDir returns the path of the directory of the variants of the
+// response stored under key.
+func variantsDir(path, key string) string {
+	return keyPath(path, key) + variantsSuffix
+}
+
 type pathMutex struct {
 	mu   sync.Mutex
 	lock map[string]*fileLock
@@ -220,3 +828,81 @@ func (l *fileLock) Unlock() {
 	l.mu.Unlock()
 	l.cleanup()
 }
+
+// writeBreaker suspends writes for a cooldown period after a number of
+// consecutive failures, e.g. when the disk is full.
+type writeBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+// Allow reports whether a write may be attempted.
+func (b *writeBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	// Let a write through once the cooldown has passed to probe whether
+	// the failure condition has cleared.
+	if time.Since(b.openedAt) >= b.cooldown {
+		b.openedAt = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// Record records the outcome of a write. Only disk failures count towards
+// the threshold, see diskFailure, as the other errors are specific to the
+// entry written.
+func (b *writeBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	if !diskFailure(err) {
+		return
+	}
+
+	b.failures++
+	if b.failures == b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// diskFailures are the messages of the errors telling that the disk itself
+// fails, rather than the write of an entry: ENOSPC, EDQUOT, EIO and EROFS as
+// worded on Unix, and ERROR_DISK_FULL on Windows. Errors are told apart by
+// message as Yaegi, which runs plugins in Traefik, doesn't provide the
+// syscall package defining them.
+var diskFailures = []string{
+	"no space left on device",
+	"disk quota exceeded",
+	"input/output error",
+	"read-only file system",
+	"not enough space on the disk",
+}
+
+// diskFailure reports whether err tells that the disk itself fails.
+func diskFailure(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	for _, failure := range diskFailures {
+		if strings.Contains(msg, failure) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,11 +3,18 @@ package plugin_simplecache
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 const testCacheKey = "GETlocalhost:8080/test/path"
@@ -15,7 +22,7 @@ const testCacheKey = "GETlocalhost:8080/test/path"
 func TestFileCache(t *testing.T) {
 	dir := createTempDir(t)
 
-	fc, err := newFileCache(dir, time.Second)
+	fc, err := newFileCache(dir, time.Second, 0, 0600, 0700)
 	if err != nil {
 		t.Errorf("unexpected newFileCache error: %v", err)
 	}
@@ -27,7 +34,7 @@ func TestFileCache(t *testing.T) {
 
 	cacheContent := []byte("some random cache content that should be exact")
 
-	err = fc.Set(testCacheKey, cacheContent, time.Second)
+	err = fc.Set(testCacheKey, cacheContent, time.Second, entryMeta{})
 	if err != nil {
 		t.Errorf("unexpected cache set error: %v", err)
 	}
@@ -42,6 +49,632 @@ func TestFileCache(t *testing.T) {
 	}
 }
 
+func TestFileCache_Modes(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Second, 0, 0640, 0750)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Second, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	p := keyPath(dir, testCacheKey)
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0640 {
+		t.Errorf("unexpected file mode: want %o, got %o", 0640, mode)
+	}
+
+	for d := filepath.Dir(p); d != filepath.Clean(dir); d = filepath.Dir(d) {
+		info, err = os.Stat(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mode := info.Mode().Perm(); mode != 0750 {
+			t.Errorf("unexpected directory mode for %s: want %o, got %o", d, 0750, mode)
+		}
+	}
+}
+
+func TestFileCache_SetFailureRemovesTemp(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	// A directory in place of the entry makes the final rename fail.
+	p := keyPath(dir, testCacheKey)
+	if err = os.MkdirAll(filepath.Join(p, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, entryMeta{}); err == nil {
+		t.Fatal("expected cache set error")
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestFileCache_BreakerSuspendsWrites(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	for i := 0; i < fc.breaker.threshold; i++ {
+		fc.breaker.Record(&os.PathError{Op: "write", Path: dir, Err: syscall.ENOSPC})
+	}
+
+	if err = fc.Set("some other key", []byte("some content"), time.Minute, entryMeta{}); !errors.Is(err, errWritesSuspended) {
+		t.Errorf("unexpected cache set error: want %v, got %v", errWritesSuspended, err)
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestFileCache_BreakerIgnoresEntryErrors(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	p := keyPath(dir, testCacheKey)
+	if err = os.MkdirAll(filepath.Join(p, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing writes of a single entry don't suspend the others.
+	for i := 0; i < fc.breaker.threshold; i++ {
+		if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, entryMeta{}); err == nil || errors.Is(err, errWritesSuspended) {
+			t.Fatalf("unexpected cache set error on attempt %d: %v", i, err)
+		}
+	}
+
+	if err = fc.Set("some other key", []byte("some content"), time.Minute, entryMeta{}); err != nil {
+		t.Errorf("unexpected cache set error: %v", err)
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestFileCache_LongKey(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	long := "GETlocalhost/" + strings.Repeat("é", 200)
+	other := long + "x"
+
+	for _, key := range []string{long, other} {
+		if err = fc.Set(key, []byte(key), time.Minute, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	for _, key := range []string{long, other} {
+		if name := filepath.Base(keyPath(dir, key)); len(name) > maxFileNameLen || !utf8.ValidString(name) {
+			t.Errorf("unexpected file name of %d bytes: %q", len(name), name)
+		}
+
+		got, err := fc.Get(key)
+		if err != nil || string(got) != key {
+			t.Errorf("unexpected value of a long key: %q, %v", got, err)
+		}
+	}
+}
+
+func TestFileCache_LoadRemovesStaleTemp(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	sub := filepath.Join(dir, "00", "01", "02", "03")
+	if err = os.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := filepath.Join(sub, "stale.123.tmp")
+	fresh := filepath.Join(sub, "fresh.456.tmp")
+
+	for _, p := range []string{stale, fresh} {
+		if err = ioutil.WriteFile(p, []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * tmpMaxAge)
+	if err = os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	fc.load()
+
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected stale temp file to be removed, got: %v", err)
+	}
+
+	if _, err = os.Stat(fresh); err != nil {
+		t.Errorf("expected fresh temp file to be kept, got: %v", err)
+	}
+}
+
+func TestFileCache_LoadIndexesEntries(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	meta := entryMeta{Host: "localhost:8080", Path: "/test/path"}
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, meta); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	// A foreign file in the cache directory must be left alone.
+	foreign := filepath.Join(dir, "foreign")
+	if err = ioutil.WriteFile(foreign, []byte("not an entry"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := &fileCache{path: dir, pm: &pathMutex{lock: map[string]*fileLock{}}, index: &index{entries: map[string]*indexEntry{}}}
+	reopened.load()
+
+	meta.Key = testCacheKey
+	meta.Size = len("some content")
+	meta.Version = entryVersion
+	meta.Hash = hashBytes([]byte("some content"))
+
+	metas := reopened.index.Metas()
+	if len(metas) == 1 {
+		meta.Stored = metas[0].Stored
+	}
+	if len(metas) != 1 || metas[0] != meta {
+		t.Errorf("unexpected index: want [%+v], got %+v", meta, metas)
+	}
+
+	if _, err = os.Stat(foreign); err != nil {
+		t.Errorf("expected foreign file to be kept, got: %v", err)
+	}
+}
+
+func TestFileCache_CleanupBatch(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 2, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err = fc.Set(key, []byte("some content"), time.Second, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	if err = fc.Set("fresh", []byte("some content"), time.Hour, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	later := time.Now().Add(time.Minute)
+
+	if n := fc.cleanupBatch(later); n != 2 {
+		t.Errorf("expected the first run to remove a batch of 2, got %d", n)
+	}
+
+	if n := fc.cleanupBatch(later); n != 1 {
+		t.Errorf("expected the second run to remove the remaining entry, got %d", n)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err = os.Stat(keyPath(dir, key)); !os.IsNotExist(err) {
+			t.Errorf("expected %q to be removed, got: %v", key, err)
+		}
+	}
+
+	if _, err = fc.Get("fresh"); err != nil {
+		t.Errorf("expected fresh entry to be kept, got: %v", err)
+	}
+}
+
+func TestFileCache_CleanupAllConcurrently(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 3, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	const entries = 50
+	for i := 0; i < entries; i++ {
+		if err = fc.Set(fmt.Sprintf("key%d", i), []byte("some content"), -time.Second, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		removed [4]int
+	)
+
+	for i := range removed {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			removed[i] = fc.Cleanup(time.Now())
+		}(i)
+	}
+
+	wg.Wait()
+
+	var total int
+	for _, n := range removed {
+		total += n
+	}
+
+	if total != entries {
+		t.Errorf("unexpected number of removed entries: want %d, got %d", entries, total)
+	}
+}
+
+func TestFileCache_ClockSetBack(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	for _, key := range []string{"lazy", "cleanup"} {
+		if err = fc.Set(key, []byte("some content"), -time.Second, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+
+		// Setting the clock back an hour makes the wall clock expiry on disk
+		// look an hour later.
+		e, _ := fc.index.Get(key)
+		p := keyPath(dir, key)
+
+		tmp, err := fc.writeTemp(p, []byte("some content"), time.Now().Add(time.Hour), e.meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.Rename(tmp, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = fc.Get("lazy"); !errors.Is(err, errCacheMiss) {
+		t.Errorf("expected the entry to be expired, got: %v", err)
+	}
+
+	if n := fc.cleanupBatch(time.Now()); n != 1 {
+		t.Errorf("expected cleanup to remove the entry, removed %d", n)
+	}
+
+	for _, key := range []string{"lazy", "cleanup"} {
+		if _, err = os.Stat(keyPath(dir, key)); !os.IsNotExist(err) {
+			t.Errorf("expected %q to be removed, got: %v", key, err)
+		}
+	}
+}
+
+func TestFileCache_Stat(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if _, ok := fc.Stat(testCacheKey); ok {
+		t.Fatal("expected no stat for a missing entry")
+	}
+
+	before := time.Now().Truncate(time.Second)
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, entryMeta{Status: 200}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	stat, ok := fc.Stat(testCacheKey)
+	if !ok {
+		t.Fatal("expected a stat")
+	}
+
+	if stat.Key != testCacheKey || stat.Status != 200 || stat.Size != len("some content") {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+
+	if stat.StoredAt.Before(before) || !stat.Expires.After(stat.StoredAt) {
+		t.Errorf("unexpected stat times: %+v", stat)
+	}
+}
+
+func TestFileCache_GetRejectsCollidingKey(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	// Overwrite the entry with one recorded for another key.
+	p := keyPath(dir, testCacheKey)
+
+	tmp, err := fc.writeTemp(p, []byte("other content"), time.Now().Add(time.Minute), entryMeta{Key: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Rename(tmp, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fc.Get(testCacheKey); !errors.Is(err, errCacheMiss) {
+		t.Errorf("unexpected cache get error: want %v, got %v", errCacheMiss, err)
+	}
+}
+
+func TestFileCache_VersionMismatch(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	write := func(key string, version int) string {
+		p := keyPath(dir, key)
+
+		tmp, err := fc.writeTemp(p, []byte("some content"), time.Now().Add(time.Minute), entryMeta{Key: key, Version: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.Rename(tmp, p); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	// Entries written before and after a version bump.
+	older := write("older", entryVersion-1)
+	newer := write("newer", entryVersion+1)
+
+	for _, key := range []string{"older", "newer"} {
+		if _, err = fc.Get(key); !errors.Is(err, errCacheMiss) {
+			t.Errorf("expected %q to miss, got: %v", key, err)
+		}
+	}
+
+	reopened := &fileCache{path: dir, pm: &pathMutex{lock: map[string]*fileLock{}}, index: &index{entries: map[string]*indexEntry{}}}
+	reopened.load()
+
+	if _, err = os.Stat(older); !os.IsNotExist(err) {
+		t.Errorf("expected the older version entry to be removed, got: %v", err)
+	}
+
+	if _, err = os.Stat(newer); err != nil {
+		t.Errorf("expected the newer version entry to be kept, got: %v", err)
+	}
+
+	if n := len(reopened.index.Metas()); n != 0 {
+		t.Errorf("expected no entry to be indexed, got %d", n)
+	}
+}
+
+func TestFileCache_Purge(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err = fc.Set("GETlocalhost"+p, []byte("some content"), time.Minute, entryMeta{Path: p}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	keys := fc.Purge(func(meta entryMeta) bool { return meta.Path != "/b" }, 1)
+	if len(keys) != 1 {
+		t.Fatalf("unexpected purged keys: %v", keys)
+	}
+
+	keys = fc.Purge(func(meta entryMeta) bool { return meta.Path != "/b" }, 10)
+	if len(keys) != 1 {
+		t.Fatalf("unexpected purged keys: %v", keys)
+	}
+
+	for _, p := range []string{"/a", "/c"} {
+		if _, err = fc.Get("GETlocalhost" + p); !errors.Is(err, errCacheMiss) {
+			t.Errorf("expected %s to be purged, got: %v", p, err)
+		}
+	}
+
+	if _, err = fc.Get("GETlocalhost/b"); err != nil {
+		t.Errorf("expected /b to be kept, got: %v", err)
+	}
+}
+
+func TestFileCache_ExtendedExportReload(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	// Stored already expired on disk, then extended.
+	if err = fc.Set(testCacheKey, []byte("some content"), -time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Hour, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if n, err := fc.Export(&snapshot); err != nil || n != 1 {
+		t.Errorf("expected the extended entry to be exported, got %d: %v", n, err)
+	}
+
+	restarted, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	restarted.load()
+
+	if stat, ok := restarted.Stat(testCacheKey); !ok || time.Until(stat.Expires) < 59*time.Minute {
+		t.Errorf("expected the extended entry to be loaded, got %+v", stat)
+	}
+}
+
+func TestFileCache_SetIdenticalValue(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	p := keyPath(dir, testCacheKey)
+
+	before, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("some content"), time.Hour, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rewrite would have renamed a new file into place.
+	if !os.SameFile(before, info) {
+		t.Error("expected an identical value not to be rewritten")
+	}
+
+	stat, ok := fc.Stat(testCacheKey)
+	if !ok || time.Until(stat.Expires) < 59*time.Minute {
+		t.Errorf("expected the expiry to be extended, got %+v", stat)
+	}
+
+	if expires, _, err := readHeader(p); err != nil || time.Until(expires) < 59*time.Minute {
+		t.Errorf("expected the expiry on disk to be extended, got %v: %v", expires, err)
+	}
+
+	if err = fc.Set(testCacheKey, []byte("other content"), time.Hour, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	if info, err = os.Stat(p); err != nil || os.SameFile(before, info) {
+		t.Errorf("expected a changed value to be rewritten, got: %v", err)
+	}
+
+	got, err := fc.Get(testCacheKey)
+	if err != nil || string(got) != "other content" {
+		t.Errorf("unexpected value %q: %v", got, err)
+	}
+}
+
+func TestFileCache_PurgeKeyVariants(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	key := "GETlocalhost/a"
+	variants := []string{key + variantSep + "Accept-Language=en", key + variantSep + "Accept-Language=fr"}
+
+	for _, k := range append([]string{key}, variants...) {
+		if err = fc.Set(k, []byte("some content"), time.Minute, entryMeta{Path: "/a"}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	for _, k := range variants {
+		if p := keyPath(dir, k); filepath.Dir(p) != keyPath(dir, key)+variantsSuffix {
+			t.Errorf("unexpected variant path %q", p)
+		}
+	}
+
+	if keys := fc.PurgeKey(variants[0]); len(keys) != 1 || keys[0] != variants[0] {
+		t.Fatalf("unexpected purged keys: %v", keys)
+	}
+
+	if _, err = fc.Get(variants[1]); err != nil {
+		t.Errorf("expected the other variant to be kept, got: %v", err)
+	}
+
+	if keys := fc.PurgeKey(key); len(keys) != 2 {
+		t.Fatalf("unexpected purged keys: %v", keys)
+	}
+
+	for _, k := range append([]string{key}, variants...) {
+		if _, err = fc.Get(k); !errors.Is(err, errCacheMiss) {
+			t.Errorf("expected %s to be purged, got: %v", k, err)
+		}
+	}
+
+	if _, err = os.Stat(keyPath(dir, key) + variantsSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the variants directory to be removed, got: %v", err)
+	}
+}
+
+func assertNoTempFiles(tb testing.TB, dir string) {
+	tb.Helper()
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, tmpSuffix) {
+			tb.Errorf("unexpected temp file %s", path)
+		}
+
+		return nil
+	})
+}
+
 func TestFileCache_ConcurrentAccess(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -54,7 +687,7 @@ func TestFileCache_ConcurrentAccess(t *testing.T) {
 
 	dir := createTempDir(t)
 
-	fc, err := newFileCache(dir, time.Second)
+	fc, err := newFileCache(dir, time.Second, 0, 0600, 0700)
 	if err != nil {
 		t.Errorf("unexpected newFileCache error: %v", err)
 	}
@@ -86,7 +719,7 @@ func TestFileCache_ConcurrentAccess(t *testing.T) {
 		defer wg.Done()
 
 		for {
-			err = fc.Set(testCacheKey, cacheContent, time.Second)
+			err = fc.Set(testCacheKey, cacheContent, time.Second, entryMeta{})
 			if err != nil {
 				panic(fmt.Errorf("unexpected cache set error: %w", err))
 			}
@@ -102,6 +735,54 @@ func TestFileCache_ConcurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFileCache_SetKeepsPreviousValueReadable(t *testing.T) {
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	versions := [][]byte{
+		bytes.Repeat([]byte("a"), 4<<20),
+		bytes.Repeat([]byte("b"), 4<<20),
+	}
+
+	if err = fc.Set(testCacheKey, versions[0], time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 1; i <= 20; i++ {
+			if err := fc.Set(testCacheKey, versions[i%2], time.Minute, entryMeta{}); err != nil {
+				t.Errorf("unexpected cache set error: %v", err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		got, err := fc.Get(testCacheKey)
+		if err != nil {
+			t.Fatalf("expected the previous value to stay readable, got: %v", err)
+		}
+
+		if !bytes.Equal(got, versions[0]) && !bytes.Equal(got, versions[1]) {
+			t.Fatalf("unexpected partial value of %d bytes", len(got))
+		}
+	}
+}
+
 func TestPathMutex(t *testing.T) {
 	pm := &pathMutex{lock: map[string]*fileLock{}}
 
@@ -142,12 +823,12 @@ func TestPathMutex(t *testing.T) {
 func BenchmarkFileCache_Get(b *testing.B) {
 	dir := createTempDir(b)
 
-	fc, err := newFileCache(dir, time.Minute)
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
 	if err != nil {
 		b.Errorf("unexpected newFileCache error: %v", err)
 	}
 
-	_ = fc.Set(testCacheKey, []byte("some random cache content that should be exact"), time.Minute)
+	_ = fc.Set(testCacheKey, []byte("some random cache content that should be exact"), time.Minute, entryMeta{})
 
 	b.ReportAllocs()
 	b.ResetTimer()
@@ -0,0 +1,17 @@
+package plugin_simplecache
+
+import "net/http"
+
+// headFromGet reports whether a HEAD request may be served from the stored
+// response to the same GET request.
+func (m *cache) headFromGet(r *http.Request) bool {
+	return m.cfg.HeadFromGet && r.Method == http.MethodHead
+}
+
+// getKey returns the cache key of the GET request equivalent to r.
+func (m *cache) getKey(r *http.Request) string {
+	g := r.Clone(r.Context())
+	g.Method = http.MethodGet
+
+	return m.cacheKey(g)
+}
@@ -0,0 +1,101 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCache_HeadFromGet(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write([]byte("some content that is compressed"))
+	_ = zw.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{name: "identity", body: []byte("some content")},
+		{name: "gzip", encoding: "gzip", body: zipped.Bytes()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Cache-Control", "max-age=20")
+				if test.encoding != "" {
+					rw.Header().Set("Content-Encoding", test.encoding)
+				}
+				_, _ = rw.Write(test.body)
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, HeadFromGet: true}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			req = httptest.NewRequest(http.MethodHead, "http://localhost/some/path", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rw := httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if calls != 1 {
+				t.Errorf("expected HEAD to be served from the stored GET, got %d calls", calls)
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != "hit" {
+				t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+			}
+
+			if got, want := rw.Header().Get("Content-Length"), strconv.Itoa(len(test.body)); got != want {
+				t.Errorf("unexpected Content-Length: want %s, got %s", want, got)
+			}
+
+			if got := rw.Header().Get("Content-Encoding"); got != test.encoding {
+				t.Errorf("unexpected Content-Encoding: want %q, got %q", test.encoding, got)
+			}
+
+			if rw.Body.Len() != 0 {
+				t.Errorf("expected no body, got %q", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestCache_HeadFromGetDisabled(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "http://localhost/some/path", nil))
+
+	if calls != 2 {
+		t.Errorf("expected HEAD to be fetched, got %d calls", calls)
+	}
+}
@@ -0,0 +1,52 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+func validateHosts(hosts []string) error {
+	for _, host := range hosts {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+
+	return nil
+}
+
+// cachedHost reports whether requests to the host of r are cached, which is
+// the case of all hosts when none are configured. A "*." prefix matches any
+// subdomain, but not the domain itself.
+func (m *cache) cachedHost(r *http.Request) bool {
+	if len(m.cfg.Hosts) == 0 {
+		return true
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range m.cfg.Hosts {
+		pattern = strings.ToLower(pattern)
+
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+
+		if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
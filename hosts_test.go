@@ -0,0 +1,78 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateHosts(t *testing.T) {
+	tests := []struct {
+		hosts   []string
+		wantErr bool
+	}{
+		{hosts: nil},
+		{hosts: []string{"example.com", "*.example.com"}},
+		{hosts: []string{""}, wantErr: true},
+		{hosts: []string{"*."}, wantErr: true},
+		{hosts: []string{"api.*.com"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := validateHosts(test.hosts); (err != nil) != test.wantErr {
+			t.Errorf("%v: unexpected error: %v", test.hosts, err)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_Hosts(t *testing.T) {
+	tests := []struct {
+		name      string
+		hosts     []string
+		host      string
+		wantState string
+	}{
+		{name: "all hosts", host: "other.org", wantState: "hit"},
+		{name: "exact", hosts: []string{"example.com"}, host: "example.com", wantState: "hit"},
+		{name: "exact with port", hosts: []string{"example.com"}, host: "EXAMPLE.com:8080", wantState: "hit"},
+		{name: "wildcard", hosts: []string{"*.example.com"}, host: "api.example.com", wantState: "hit"},
+		{name: "wildcard excludes the domain", hosts: []string{"*.example.com"}, host: "example.com", wantState: "miss"},
+		{name: "not matching", hosts: []string{"example.com", "*.example.com"}, host: "example.org", wantState: "miss"},
+		{name: "not matching suffix", hosts: []string{"*.example.com"}, host: "badexample.com", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, Hosts: test.hosts}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			var rw *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+				req.Host = test.host
+
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got: %q", test.wantState, state)
+			}
+
+			if test.wantState == "miss" && len(c.cache.HostUsage()) != 0 {
+				t.Errorf("unexpected entries stored: %v", c.cache.HostUsage())
+			}
+		})
+	}
+}
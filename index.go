@@ -0,0 +1,399 @@
+package plugin_simplecache
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// entryMeta describes a stored entry.
+type entryMeta struct {
+	Key    string `json:"key"`
+	Host   string `json:"host,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+	// Size is the size of the stored value.
+	Size int `json:"size,omitempty"`
+	// Stored is the unix time the entry was stored at.
+	Stored int64 `json:"stored,omitempty"`
+	// Version is the entry format version, see entryVersion.
+	Version int `json:"version,omitempty"`
+	// Hash is the hash of the stored response, that of the stored value
+	// unless set by the caller, used by the file backend to tell identical
+	// rewrites apart.
+	Hash string `json:"hash,omitempty"`
+	// Fetch describes when the stored response was received, left out of
+	// Hash.
+	Fetch entryFetch `json:"fetch"`
+}
+
+// entryFetch holds what changes each time a response is received, even
+// unchanged. The file backend updates it in the index instead of rewriting an
+// identical value, see fileCache.extend.
+type entryFetch struct {
+	// Received is the unix time in nanoseconds the response was received
+	// at, 0 if unknown.
+	Received int64 `json:"received,omitempty"`
+	// InitialAge is the age in nanoseconds of the response when received.
+	InitialAge int64 `json:"initialAge,omitempty"`
+	// StaleAt is the unix time in nanoseconds the response goes stale at,
+	// if kept to be served stale.
+	StaleAt int64 `json:"staleAt,omitempty"`
+	// Date is the Date header of the response.
+	Date string `json:"date,omitempty"`
+}
+
+// entryStat describes a stored entry and its expiry.
+type entryStat struct {
+	Key      string    `json:"key"`
+	Host     string    `json:"host,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Size     int       `json:"size"`
+	StoredAt time.Time `json:"storedAt"`
+	Expires  time.Time `json:"expires"`
+	Hits     uint64    `json:"hits"`
+	// UsedAt is the time of the last hit on the entry, or of its storage if
+	// it had none since.
+	UsedAt time.Time `json:"usedAt"`
+	// Variants is the number of variants stored for a response stored per
+	// variant.
+	Variants int `json:"variants,omitempty"`
+
+	fetch entryFetch
+}
+
+// hostEntries holds the entries of a host and their total size.
+type hostEntries struct {
+	size    int
+	entries map[string]*indexEntry
+}
+
+type indexEntry struct {
+	meta    entryMeta
+	expires time.Time
+	// hits counts the hits on the entry since it was indexed. It is only
+	// kept in memory, so that hits don't rewrite the entry.
+	hits uint64
+	// used is the unix time in nanoseconds of the last hit or storage of the
+	// entry, 0 for entries loaded from disk.
+	used int64
+}
+
+func (e *indexEntry) stat() entryStat {
+	usedAt := time.Unix(e.meta.Stored, 0)
+	if used := atomic.LoadInt64(&e.used); used != 0 {
+		usedAt = time.Unix(0, used)
+	}
+
+	return entryStat{
+		Key:      e.meta.Key,
+		Host:     e.meta.Host,
+		Path:     e.meta.Path,
+		Status:   e.meta.Status,
+		Size:     e.meta.Size,
+		StoredAt: time.Unix(e.meta.Stored, 0),
+		Expires:  e.expires,
+		Hits:     atomic.LoadUint64(&e.hits),
+		UsedAt:   usedAt,
+		fetch:    e.meta.Fetch,
+	}
+}
+
+// index keeps the metadata of the stored entries in memory, so they can be
+// enumerated without walking the cache directory.
+type index struct {
+	mu      sync.RWMutex
+	entries map[string]*indexEntry
+	// size is the total size of the entries.
+	size int
+	// hosts holds the entries of each host, so that those of a host over its
+	// budget are found without scanning the others.
+	hosts map[string]*hostEntries
+	// variants holds the number of variants of each response stored per
+	// variant.
+	variants map[string]int
+}
+
+// Put indexes the entry stored under key.
+func (i *index) Put(key string, e *indexEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e.used == 0 {
+		e.used = time.Now().UnixNano()
+	}
+
+	i.remove(key)
+	i.add(key, e)
+}
+
+// Add indexes the entry stored under key unless it is already indexed.
+func (i *index) Add(key string, e *indexEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.entries[key]; !ok {
+		i.add(key, e)
+	}
+}
+
+func (i *index) add(key string, e *indexEntry) {
+	if i.hosts == nil {
+		i.hosts = map[string]*hostEntries{}
+	}
+
+	he, ok := i.hosts[e.meta.Host]
+	if !ok {
+		he = &hostEntries{entries: map[string]*indexEntry{}}
+		i.hosts[e.meta.Host] = he
+	}
+
+	i.entries[key] = e
+	i.size += e.meta.Size
+	he.entries[key] = e
+	he.size += e.meta.Size
+
+	if base, _, ok := splitVariantKey(key); ok {
+		if i.variants == nil {
+			i.variants = map[string]int{}
+		}
+
+		i.variants[base]++
+	}
+}
+
+func (i *index) remove(key string) {
+	e, ok := i.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(i.entries, key)
+	i.size -= e.meta.Size
+
+	if he, ok := i.hosts[e.meta.Host]; ok {
+		delete(he.entries, key)
+		he.size -= e.meta.Size
+
+		if len(he.entries) == 0 {
+			delete(i.hosts, e.meta.Host)
+		}
+	}
+
+	if base, _, ok := splitVariantKey(key); ok {
+		if i.variants[base]--; i.variants[base] <= 0 {
+			delete(i.variants, base)
+		}
+	}
+}
+
+// Get returns the entry indexed under key.
+func (i *index) Get(key string) (*indexEntry, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e, ok := i.entries[key]
+
+	return e, ok
+}
+
+// Hit counts a hit on the entry indexed under key, returning its hit count.
+func (i *index) Hit(key string) uint64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e, ok := i.entries[key]
+	if !ok {
+		return 0
+	}
+
+	atomic.StoreInt64(&e.used, time.Now().UnixNano())
+
+	return atomic.AddUint64(&e.hits, 1)
+}
+
+// VariantCount returns the number of variants indexed for the response
+// stored under key.
+func (i *index) VariantCount(key string) int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.variants[key]
+}
+
+// Variants returns the description of the variants indexed for the response
+// stored under key.
+func (i *index) Variants(key string) []entryStat {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.variants[key] == 0 {
+		return nil
+	}
+
+	var stats []entryStat
+	for k, e := range i.entries {
+		if base, _, ok := splitVariantKey(k); ok && base == key {
+			stats = append(stats, e.stat())
+		}
+	}
+
+	return stats
+}
+
+// Delete removes key from the index.
+func (i *index) Delete(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(key)
+}
+
+// HostUsage returns the total size of the entries of each host.
+func (i *index) HostUsage() map[string]int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	usage := make(map[string]int, len(i.hosts))
+	for host, he := range i.hosts {
+		if he.size > 0 {
+			usage[host] = he.size
+		}
+	}
+
+	return usage
+}
+
+// Over returns the keys of the entries of host to remove for its usage not to
+// exceed max, the oldest first. Only the entries of host are considered.
+func (i *index) Over(host string, max int) []string {
+	i.mu.RLock()
+
+	he, ok := i.hosts[host]
+	if !ok || he.size <= max {
+		i.mu.RUnlock()
+		return nil
+	}
+
+	excess := he.size - max
+
+	c := make(candidates, 0, len(he.entries))
+	for _, e := range he.entries {
+		c = append(c, candidate{key: e.meta.Key, size: e.meta.Size, order: e.meta.Stored, tie: e.expires.UnixNano()})
+	}
+
+	i.mu.RUnlock()
+
+	return c.take(excess)
+}
+
+// Coldest returns the keys of the entries to remove for the total size not to
+// exceed max, the least recently used first and, among those last used at the
+// same time, the least hit.
+func (i *index) Coldest(max int) []string {
+	i.mu.RLock()
+
+	excess := i.size - max
+	if excess <= 0 {
+		i.mu.RUnlock()
+		return nil
+	}
+
+	c := make(candidates, 0, len(i.entries))
+	for _, e := range i.entries {
+		used := atomic.LoadInt64(&e.used)
+		if used == 0 {
+			used = time.Unix(e.meta.Stored, 0).UnixNano()
+		}
+
+		c = append(c, candidate{key: e.meta.Key, size: e.meta.Size, order: used, tie: int64(atomic.LoadUint64(&e.hits))})
+	}
+
+	i.mu.RUnlock()
+
+	return c.take(excess)
+}
+
+// candidate is an entry considered for removal, ranked by order then tie, the
+// lowest first.
+type candidate struct {
+	key        string
+	size       int
+	order, tie int64
+}
+
+// candidates is a heap of entries considered for removal, so that only those
+// removed are ordered.
+type candidates []candidate
+
+func (c candidates) Len() int { return len(c) }
+
+func (c candidates) Less(a, b int) bool {
+	if c[a].order != c[b].order {
+		return c[a].order < c[b].order
+	}
+	return c[a].tie < c[b].tie
+}
+
+func (c candidates) Swap(a, b int) { c[a], c[b] = c[b], c[a] }
+
+func (c *candidates) Push(x interface{}) { *c = append(*c, x.(candidate)) }
+
+func (c *candidates) Pop() interface{} {
+	old := *c
+	x := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return x
+}
+
+// take returns the keys of the lowest ranked candidates whose total size
+// reaches excess.
+func (c candidates) take(excess int) []string {
+	heap.Init(&c)
+
+	var keys []string
+	for excess > 0 && c.Len() > 0 {
+		x := heap.Pop(&c).(candidate)
+		keys = append(keys, x.key)
+		excess -= x.size
+	}
+
+	return keys
+}
+
+// Metas returns the metadata of all indexed entries.
+func (i *index) Metas() []entryMeta {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	metas := make([]entryMeta, 0, len(i.entries))
+	for _, e := range i.entries {
+		metas = append(metas, e.meta)
+	}
+
+	return metas
+}
+
+// Expired returns the keys of up to limit entries expired at now, the
+// earliest expired first. A limit of 0 means no limit.
+func (i *index) Expired(now time.Time, limit int) []string {
+	i.mu.RLock()
+
+	var c candidates
+	for _, e := range i.entries {
+		if e.expires.Before(now) {
+			// Each entry counts as one towards the limit.
+			c = append(c, candidate{key: e.meta.Key, size: 1, order: e.expires.UnixNano()})
+		}
+	}
+
+	i.mu.RUnlock()
+
+	if limit == 0 || limit > len(c) {
+		limit = len(c)
+	}
+
+	return c.take(limit)
+}
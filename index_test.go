@@ -0,0 +1,79 @@
+package plugin_simplecache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndex_HostUsage(t *testing.T) {
+	i := &index{entries: map[string]*indexEntry{}}
+
+	i.Put("a1", &indexEntry{meta: entryMeta{Key: "a1", Host: "a", Size: 10}})
+	i.Put("a2", &indexEntry{meta: entryMeta{Key: "a2", Host: "a", Size: 5}})
+	i.Put("b1", &indexEntry{meta: entryMeta{Key: "b1", Host: "b", Size: 7}})
+	i.Put("a1", &indexEntry{meta: entryMeta{Key: "a1", Host: "a", Size: 3}})
+	i.Add("b1", &indexEntry{meta: entryMeta{Key: "b1", Host: "b", Size: 100}})
+
+	if got, want := i.HostUsage(), map[string]int{"a": 8, "b": 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected usage: want %v, got %v", want, got)
+	}
+
+	i.Delete("b1")
+
+	if got, want := i.HostUsage(), map[string]int{"a": 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected usage after delete: want %v, got %v", want, got)
+	}
+}
+
+func TestIndex_Over(t *testing.T) {
+	i := &index{entries: map[string]*indexEntry{}}
+
+	now := time.Now()
+	i.Put("new", &indexEntry{meta: entryMeta{Key: "new", Host: "a", Size: 10, Stored: 3}, expires: now})
+	i.Put("old", &indexEntry{meta: entryMeta{Key: "old", Host: "a", Size: 10, Stored: 1}, expires: now})
+	i.Put("mid", &indexEntry{meta: entryMeta{Key: "mid", Host: "a", Size: 10, Stored: 2}, expires: now})
+	i.Put("other", &indexEntry{meta: entryMeta{Key: "other", Host: "b", Size: 100, Stored: 0}, expires: now})
+
+	if got := i.Over("a", 30); len(got) != 0 {
+		t.Errorf("expected no keys within budget, got %v", got)
+	}
+
+	if got, want := i.Over("a", 15), []string{"old", "mid"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected keys: want %v, got %v", want, got)
+	}
+
+	for _, key := range []string{"new", "old", "mid"} {
+		i.Delete(key)
+	}
+
+	if got := i.Over("a", 0); len(got) != 0 {
+		t.Errorf("expected no keys once the host is empty, got %v", got)
+	}
+
+	if got, want := i.Over("b", 50), []string{"other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected keys of the other host: want %v, got %v", want, got)
+	}
+}
+
+func TestIndex_Expired(t *testing.T) {
+	i := &index{entries: map[string]*indexEntry{}}
+
+	now := time.Now()
+	i.Put("late", &indexEntry{meta: entryMeta{Key: "late"}, expires: now.Add(-time.Second)})
+	i.Put("early", &indexEntry{meta: entryMeta{Key: "early"}, expires: now.Add(-time.Hour)})
+	i.Put("mid", &indexEntry{meta: entryMeta{Key: "mid"}, expires: now.Add(-time.Minute)})
+	i.Put("fresh", &indexEntry{meta: entryMeta{Key: "fresh"}, expires: now.Add(time.Minute)})
+
+	if got, want := i.Expired(now, 0), []string{"early", "mid", "late"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected expired keys: want %v, got %v", want, got)
+	}
+
+	if got, want := i.Expired(now, 2), []string{"early", "mid"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected limited expired keys: want %v, got %v", want, got)
+	}
+
+	if got := i.Expired(now.Add(-2*time.Hour), 0); len(got) != 0 {
+		t.Errorf("expected no expired keys, got %v", got)
+	}
+}
@@ -0,0 +1,41 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// invalidate purges the entries of the paths the origin listed in the
+// invalidation header of its response, on the host of r, then drops the
+// header so that it isn't forwarded.
+func (m *cache) invalidate(r *http.Request, h http.Header) {
+	values := h.Values(m.cfg.InvalidationHeader)
+	if len(values) == 0 {
+		return
+	}
+
+	h.Del(m.cfg.InvalidationHeader)
+
+	paths := map[string]bool{}
+
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if i := strings.IndexByte(p, '?'); i >= 0 {
+				p = p[:i]
+			}
+
+			if strings.HasPrefix(p, "/") {
+				paths[p] = true
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return
+	}
+
+	m.cache.Purge(func(meta entryMeta) bool {
+		return meta.Host == r.Host && paths[meta.Path]
+	}, defaultPurgeLimit)
+}
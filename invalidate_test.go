@@ -0,0 +1,74 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_ServeHTTP_InvalidationHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate string
+		wantPurged []string
+	}{
+		{name: "single path", invalidate: "/products/42", wantPurged: []string{"/products/42"}},
+		{name: "multiple paths", invalidate: "/products/42, /products", wantPurged: []string{"/products/42", "/products"}},
+		{name: "no path", invalidate: "", wantPurged: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if req.Method == http.MethodPost && test.invalidate != "" {
+					rw.Header().Set("X-Cache-Invalidate", test.invalidate)
+				}
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte(req.URL.Path))
+			}
+
+			cfg := &Config{
+				Backend:            backendMemory,
+				MaxExpiry:          10,
+				Cleanup:            20,
+				CacheMethods:       []string{http.MethodGet},
+				InvalidationHeader: "X-Cache-Invalidate",
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			paths := []string{"/products/42", "/products", "/products?page=2", "/other"}
+			for _, p := range paths {
+				c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+p, nil))
+			}
+
+			// The write response isn't cacheable, its header is honored anyway.
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost/products/42", nil))
+
+			if v := rw.Header().Get("X-Cache-Invalidate"); v != "" {
+				t.Errorf("unexpected invalidation header forwarded: %q", v)
+			}
+
+			purged := map[string]bool{}
+			for _, p := range test.wantPurged {
+				purged[p] = true
+			}
+
+			for _, p := range paths {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost"+p, nil)
+
+				_, cached := c.cache.Stat(c.cacheKey(req))
+				if want := !purged[req.URL.Path]; cached != want {
+					t.Errorf("unexpected cache state of %s: want cached %t", p, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	keySourceHeader = "header"
+	keySourceQuery  = "query"
+	keySourceCookie = "cookie"
+)
+
+// keySource is a request value the cache key may be derived from.
+type keySource struct {
+	kind string
+	name string
+}
+
+// parseKeySources parses the configured key sources, given as kind:name.
+func parseKeySources(specs []string) ([]keySource, error) {
+	sources := make([]keySource, 0, len(specs))
+
+	for _, spec := range specs {
+		var kind, name string
+		if i := strings.IndexByte(spec, ':'); i >= 0 {
+			kind, name = spec[:i], spec[i+1:]
+		}
+
+		switch kind {
+		case keySourceHeader, keySourceQuery, keySourceCookie:
+		default:
+			return nil, fmt.Errorf("invalid key source %q", spec)
+		}
+
+		if name == "" {
+			return nil, fmt.Errorf("key source %q has no name", spec)
+		}
+
+		sources = append(sources, keySource{kind: kind, name: name})
+	}
+
+	return sources, nil
+}
+
+// value returns the value of the source in r, if present.
+func (s keySource) value(r *http.Request) (string, bool) {
+	switch s.kind {
+	case keySourceHeader:
+		if values := r.Header.Values(s.name); len(values) > 0 {
+			return values[0], true
+		}
+	case keySourceQuery:
+		if values, ok := r.URL.Query()[s.name]; ok {
+			return values[0], true
+		}
+	case keySourceCookie:
+		if c, err := r.Cookie(s.name); err == nil {
+			return c.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// sourceKey returns the part of the key of r derived from the first key
+// source present in r, if any.
+func (m *cache) sourceKey(r *http.Request) string {
+	for _, s := range m.keySources {
+		if v, ok := s.value(r); ok {
+			return s.kind + ":" + s.name + "=" + url.QueryEscape(v)
+		}
+	}
+
+	return ""
+}
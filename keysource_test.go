@@ -0,0 +1,86 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseKeySources(t *testing.T) {
+	tests := []struct {
+		specs   []string
+		wantErr bool
+	}{
+		{specs: nil},
+		{specs: []string{"header:X-Tenant", "query:tenant", "cookie:tenant"}},
+		{specs: []string{"tenant"}, wantErr: true},
+		{specs: []string{"path:tenant"}, wantErr: true},
+		{specs: []string{"header:"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		if _, err := parseKeySources(test.specs); (err != nil) != test.wantErr {
+			t.Errorf("%v: unexpected error: %v", test.specs, err)
+		}
+	}
+}
+
+func TestCache_cacheKey_KeySources(t *testing.T) {
+	sources, err := parseKeySources([]string{"header:X-Tenant", "cookie:tenant", "query:tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &cache{cfg: &Config{}, keySources: sources}
+
+	newRequest := func(header, cookie, query string) *http.Request {
+		target := "http://localhost/api"
+		if query != "" {
+			target += "?tenant=" + query
+		}
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set("X-Tenant", header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "tenant", Value: cookie})
+		}
+
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		a, b     *http.Request
+		wantSame bool
+	}{
+		{name: "header", a: newRequest("a", "", ""), b: newRequest("b", "", ""), wantSame: false},
+		{name: "same header", a: newRequest("a", "", ""), b: newRequest("a", "", ""), wantSame: true},
+		{name: "cookie", a: newRequest("", "a", ""), b: newRequest("", "b", ""), wantSame: false},
+		{name: "header before cookie", a: newRequest("a", "x", ""), b: newRequest("a", "y", ""), wantSame: true},
+		{name: "header of the same value as a cookie", a: newRequest("a", "", ""), b: newRequest("", "a", ""), wantSame: false},
+		{name: "no source", a: newRequest("", "", ""), b: newRequest("", "", ""), wantSame: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := m.cacheKey(test.a), m.cacheKey(test.b)
+			if (a == b) != test.wantSame {
+				t.Errorf("unexpected keys: %q and %q", a, b)
+			}
+		})
+	}
+
+	// Sources are resolved in order, the query only when no other source is
+	// present.
+	for req, want := range map[*http.Request]string{
+		newRequest("", "", "a"):   "GETlocalhost/api?tenant=a|query:tenant=a",
+		newRequest("", "c", "a"):  "GETlocalhost/api?tenant=a|cookie:tenant=c",
+		newRequest("h", "c", "a"): "GETlocalhost/api?tenant=a|header:X-Tenant=h",
+	} {
+		if key := m.cacheKey(req); key != want {
+			t.Errorf("unexpected key: want %q, got %q", want, key)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package plugin_simplecache
+
+import (
+	"strconv"
+	"strings"
+)
+
+// primaryLanguage returns the primary subtag of the language preferred in
+// an Accept-Language header, e.g. "fr" for "fr-CH, fr;q=0.9, en;q=0.8", or
+// "" if there is none.
+func primaryLanguage(header string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		tag := strings.TrimSpace(params[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				v = 0
+			}
+
+			q = v
+		}
+
+		// The first language wins ties.
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	if i := strings.Index(best, "-"); i >= 0 {
+		best = best[:i]
+	}
+
+	return strings.ToLower(best)
+}
@@ -0,0 +1,56 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrimaryLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "fr", want: "fr"},
+		{header: "en-US", want: "en"},
+		{header: "EN-gb", want: "en"},
+		{header: "fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", want: "fr"},
+		{header: "de;q=0.5, en;q=0.9", want: "en"},
+		{header: "en, fr", want: "en"},
+		{header: "en;q=0.8, fr;q=0.8", want: "en"},
+		{header: "*", want: ""},
+		{header: "en;q=0, fr;q=0.1", want: "fr"},
+		{header: "en;q=0", want: ""},
+		{header: "en;q=bad, fr;q=0.2", want: "fr"},
+	}
+
+	for _, test := range tests {
+		if got := primaryLanguage(test.header); got != test.want {
+			t.Errorf("unexpected language for %q: want %q, got %q", test.header, test.want, got)
+		}
+	}
+}
+
+func TestCache_cacheKey_VaryAcceptLanguage(t *testing.T) {
+	newRequest := func(lang string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		req.Header.Set("Accept-Language", lang)
+
+		return req
+	}
+
+	m := &cache{cfg: &Config{}}
+	if m.cacheKey(newRequest("en")) != m.cacheKey(newRequest("fr")) {
+		t.Error("expected languages to share a key by default")
+	}
+
+	m = &cache{cfg: &Config{VaryAcceptLanguage: true}}
+	if m.cacheKey(newRequest("en-US, en;q=0.9")) != m.cacheKey(newRequest("en-GB")) {
+		t.Error("expected regional variants to share a key")
+	}
+
+	if m.cacheKey(newRequest("en")) == m.cacheKey(newRequest("fr-FR, en;q=0.5")) {
+		t.Error("expected languages to have distinct keys")
+	}
+}
@@ -0,0 +1,45 @@
+package plugin_simplecache
+
+import "strings"
+
+// stripMatrixParams removes the configured matrix parameters, the ;name=value
+// suffixes of path segments, from p. Names are matched ignoring case.
+func (m *cache) stripMatrixParams(p string) string {
+	if len(m.cfg.StripMatrixParams) == 0 || !strings.Contains(p, ";") {
+		return p
+	}
+
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		params := strings.Split(segment, ";")
+		if len(params) == 1 {
+			continue
+		}
+
+		kept := params[:1]
+		for _, param := range params[1:] {
+			name := param
+			if j := strings.IndexByte(param, '='); j >= 0 {
+				name = param[:j]
+			}
+
+			if !m.strippedMatrixParam(name) {
+				kept = append(kept, param)
+			}
+		}
+
+		segments[i] = strings.Join(kept, ";")
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (m *cache) strippedMatrixParam(name string) bool {
+	for _, stripped := range m.cfg.StripMatrixParams {
+		if strings.EqualFold(stripped, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache_cacheKey_StripMatrixParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		strip    []string
+		a, b     string
+		wantSame bool
+	}{
+		{name: "kept by default", a: "/page;jsessionid=abc", b: "/page", wantSame: false},
+		{name: "stripped", strip: []string{"jsessionid"}, a: "/page;jsessionid=abc", b: "/page", wantSame: true},
+		{name: "ignoring case", strip: []string{"jsessionid"}, a: "/page;JSESSIONID=abc", b: "/page;jsessionid=def", wantSame: true},
+		{name: "multiple params", strip: []string{"jsessionid", "sid"}, a: "/page;sid=1;color=red;jsessionid=abc", b: "/page;color=red", wantSame: true},
+		{name: "other params kept", strip: []string{"jsessionid"}, a: "/page;color=red", b: "/page;color=blue", wantSame: false},
+		{name: "intermediate segment", strip: []string{"jsessionid"}, a: "/a;jsessionid=abc/b;jsessionid=def/c", b: "/a/b/c", wantSame: true},
+		{name: "valueless param", strip: []string{"debug"}, a: "/a;debug/b", b: "/a/b", wantSame: true},
+		{name: "query kept", strip: []string{"jsessionid"}, a: "/page;jsessionid=abc?x=1", b: "/page?x=2", wantSame: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &cache{cfg: &Config{StripMatrixParams: test.strip}}
+
+			a := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.a, nil))
+			b := m.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost"+test.b, nil))
+
+			if (a == b) != test.wantSame {
+				t.Errorf("unexpected keys: %q and %q", a, b)
+			}
+		})
+	}
+}
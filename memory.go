@@ -0,0 +1,258 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errEntryTooLarge = errors.New("entry larger than memoryMaxBytes")
+
+// memoryCache is a store keeping the entries in memory, for setups without a
+// writable filesystem. Entries are lost on restart.
+type memoryCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	index  *index
+
+	// batchSize bounds the number of entries removed per cleanup run, 0
+	// meaning no limit.
+	batchSize int
+	// maxBytes bounds the total size of the entries, 0 meaning no limit.
+	maxBytes int
+
+	hookMu  sync.Mutex
+	onEvict func(key string, size int)
+}
+
+func newMemoryCache(vacuum time.Duration, batchSize, maxBytes int) *memoryCache {
+	mc := &memoryCache{
+		values: map[string][]byte{},
+		index:  &index{entries: map[string]*indexEntry{}},
+
+		batchSize: batchSize,
+		maxBytes:  maxBytes,
+	}
+
+	go mc.vacuum(vacuum)
+
+	return mc
+}
+
+func (c *memoryCache) vacuum(interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for range timer.C {
+		c.cleanupBatch(time.Now())
+	}
+}
+
+// Cleanup runs cleanupBatch until all entries expired at now are removed,
+// returning the number of removed entries.
+func (c *memoryCache) Cleanup(now time.Time) int {
+	var total int
+
+	for {
+		n := c.cleanupBatch(now)
+		total += n
+
+		if c.batchSize == 0 || n < c.batchSize {
+			return total
+		}
+	}
+}
+
+// cleanupBatch removes up to batchSize expired entries.
+func (c *memoryCache) cleanupBatch(now time.Time) int {
+	var n int
+
+	for _, key := range c.index.Expired(now, c.batchSize) {
+		if c.remove(key, func(e *indexEntry) bool { return e.expires.Before(now) }) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// remove removes the entry stored under key if cond holds for it, reporting
+// whether it was removed.
+func (c *memoryCache) remove(key string, cond func(*indexEntry) bool) bool {
+	c.mu.Lock()
+
+	e, ok := c.index.Get(key)
+	if !ok || !cond(e) {
+		c.mu.Unlock()
+		return false
+	}
+
+	delete(c.values, key)
+	c.index.Delete(key)
+	c.mu.Unlock()
+
+	c.evicted(key, e.meta.Size)
+
+	return true
+}
+
+// OnEvict sets the function called when an entry is removed from the cache.
+func (c *memoryCache) OnEvict(fn func(key string, size int)) {
+	c.hookMu.Lock()
+	defer c.hookMu.Unlock()
+
+	c.onEvict = fn
+}
+
+func (c *memoryCache) evicted(key string, size int) {
+	c.hookMu.Lock()
+	fn := c.onEvict
+	c.hookMu.Unlock()
+
+	if fn != nil {
+		fn(key, size)
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	val, ok := c.values[key]
+	e, _ := c.index.Get(key)
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, errCacheMiss
+	}
+
+	if e.expires.Before(time.Now()) {
+		c.remove(key, func(cur *indexEntry) bool { return cur == e })
+		return nil, errCacheMiss
+	}
+
+	return val, nil
+}
+
+// Set stores val under key for the given expiry. The value is copied, so the
+// caller may reuse it.
+func (c *memoryCache) Set(key string, val []byte, expiry time.Duration, meta entryMeta) error {
+	now := time.Now()
+
+	meta.Key = key
+	meta.Stored = now.Unix()
+
+	return c.put(val, now.Add(expiry), meta)
+}
+
+// put stores a copy of val under meta.Key until expires, then evicts the
+// least recently used entries for the total size not to exceed maxBytes.
+func (c *memoryCache) put(val []byte, expires time.Time, meta entryMeta) error {
+	if c.maxBytes > 0 && len(val) > c.maxBytes {
+		return errEntryTooLarge
+	}
+
+	meta.Size = len(val)
+	meta.Version = entryVersion
+
+	c.mu.Lock()
+	c.values[meta.Key] = append([]byte(nil), val...)
+	c.index.Put(meta.Key, &indexEntry{meta: meta, expires: expires})
+	c.mu.Unlock()
+
+	if c.maxBytes > 0 {
+		for _, key := range c.index.Coldest(c.maxBytes) {
+			c.Delete(key)
+		}
+	}
+
+	return nil
+}
+
+// Stat returns the description of the entry stored under key.
+func (c *memoryCache) Stat(key string) (entryStat, bool) {
+	e, ok := c.index.Get(key)
+	if !ok || e.expires.Before(time.Now()) {
+		return entryStat{}, false
+	}
+
+	stat := e.stat()
+	stat.Variants = c.index.VariantCount(key)
+
+	return stat, true
+}
+
+// Variants returns the description of the variants of the response stored
+// under key.
+func (c *memoryCache) Variants(key string) []entryStat {
+	return c.index.Variants(key)
+}
+
+// Hit counts a hit on the entry stored under key, returning its hit count.
+func (c *memoryCache) Hit(key string) uint64 {
+	return c.index.Hit(key)
+}
+
+// Delete removes the entry stored under key.
+func (c *memoryCache) Delete(key string) {
+	c.remove(key, func(*indexEntry) bool { return true })
+}
+
+// HostUsage returns the total size of the entries of each host.
+func (c *memoryCache) HostUsage() map[string]int {
+	return c.index.HostUsage()
+}
+
+// Trim removes the oldest entries of host until their total size doesn't
+// exceed max, returning the number of removed entries.
+func (c *memoryCache) Trim(host string, max int) int {
+	keys := c.index.Over(host, max)
+	for _, key := range keys {
+		c.Delete(key)
+	}
+
+	return len(keys)
+}
+
+// Purge deletes up to limit entries whose metadata matches, returning the
+// keys of the deleted entries.
+func (c *memoryCache) Purge(match func(entryMeta) bool, limit int) []string {
+	var keys []string
+
+	for _, meta := range c.index.Metas() {
+		if len(keys) >= limit {
+			break
+		}
+
+		if !match(meta) {
+			continue
+		}
+
+		c.Delete(meta.Key)
+		keys = append(keys, meta.Key)
+	}
+
+	return keys
+}
+
+// PurgeKey deletes the entry stored under key, returning the keys of the
+// deleted entries. Unless key is a variant's, the variants of the response
+// are deleted as well.
+func (c *memoryCache) PurgeKey(key string) []string {
+	var keys []string
+
+	if _, _, ok := splitVariantKey(key); !ok {
+		for _, meta := range c.index.Metas() {
+			if strings.HasPrefix(meta.Key, key+variantSep) {
+				c.Delete(meta.Key)
+				keys = append(keys, meta.Key)
+			}
+		}
+	}
+
+	if _, ok := c.index.Get(key); ok {
+		c.Delete(key)
+		keys = append(keys, key)
+	}
+
+	return keys
+}
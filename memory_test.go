@@ -0,0 +1,217 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	if _, err := mc.Get(testCacheKey); err != errCacheMiss {
+		t.Errorf("expected a cache miss, got: %v", err)
+	}
+
+	content := []byte("some random cache content that should be exact")
+
+	if err := mc.Set(testCacheKey, content, time.Minute, entryMeta{Host: "localhost"}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	content[0] = 'S'
+
+	got, err := mc.Get(testCacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache get error: %v", err)
+	}
+
+	if !bytes.Equal(got, []byte("some random cache content that should be exact")) {
+		t.Errorf("unexpected cache content: %s", got)
+	}
+
+	stat, ok := mc.Stat(testCacheKey)
+	if !ok || stat.Host != "localhost" || stat.Size != len(content) {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+
+	mc.Delete(testCacheKey)
+
+	if _, err = mc.Get(testCacheKey); err != errCacheMiss {
+		t.Errorf("expected a cache miss after delete, got: %v", err)
+	}
+}
+
+func TestMemoryCache_Expiry(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	var evicted []string
+	mc.OnEvict(func(key string, _ int) { evicted = append(evicted, key) })
+
+	if err := mc.Set(testCacheKey, []byte("some content"), -time.Second, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	if _, err := mc.Get(testCacheKey); err != errCacheMiss {
+		t.Errorf("expected an expired entry to miss, got: %v", err)
+	}
+
+	if _, ok := mc.Stat(testCacheKey); ok {
+		t.Error("expected no stat for an expired entry")
+	}
+
+	if len(evicted) != 1 || evicted[0] != testCacheKey {
+		t.Errorf("unexpected evicted keys: %v", evicted)
+	}
+}
+
+func TestMemoryCache_Cleanup(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 2, 0)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := mc.Set(key, []byte("some content"), time.Second, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	if err := mc.Set("fresh", []byte("some content"), time.Hour, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	later := time.Now().Add(time.Minute)
+
+	if n := mc.cleanupBatch(later); n != 2 {
+		t.Errorf("expected a batch of 2 to be removed, got %d", n)
+	}
+
+	if n := mc.Cleanup(later); n != 1 {
+		t.Errorf("expected the remaining entry to be removed, got %d", n)
+	}
+
+	if _, err := mc.Get("fresh"); err != nil {
+		t.Errorf("expected fresh entry to be kept, got: %v", err)
+	}
+}
+
+func TestMemoryCache_Purge(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	for key, host := range map[string]string{"1": "a.example", "2": "b.example", "3": "a.example"} {
+		if err := mc.Set(key, []byte("some content"), time.Minute, entryMeta{Host: host}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	keys := mc.Purge(func(meta entryMeta) bool { return meta.Host == "a.example" }, 10)
+	if len(keys) != 2 {
+		t.Errorf("expected 2 purged entries, got %v", keys)
+	}
+
+	if n := len(mc.index.Metas()); n != 1 {
+		t.Errorf("expected 1 remaining entry, got %d", n)
+	}
+}
+
+func TestMemoryCache_PurgeKey(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	for _, key := range []string{"a", "a" + variantSep + "X=1", "a" + variantSep + "X=2", "ab"} {
+		if err := mc.Set(key, []byte("some content"), time.Minute, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	if keys := mc.PurgeKey("a" + variantSep + "X=1"); len(keys) != 1 {
+		t.Errorf("expected 1 purged variant, got %v", keys)
+	}
+
+	if keys := mc.PurgeKey("a"); len(keys) != 2 {
+		t.Errorf("expected the entry and its remaining variant to be purged, got %v", keys)
+	}
+
+	if _, err := mc.Get("ab"); err != nil {
+		t.Errorf("expected unrelated entry to be kept, got: %v", err)
+	}
+}
+
+func TestMemoryCache_MaxBytes(t *testing.T) {
+	mc := newMemoryCache(time.Minute, 0, 30)
+
+	var evicted []string
+	mc.OnEvict(func(key string, _ int) { evicted = append(evicted, key) })
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := mc.Set(key, []byte("0123456789"), time.Minute, entryMeta{}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	mc.Hit("a")
+
+	if err := mc.Set("d", []byte("0123456789"), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("expected the least recently used entry to be evicted, got %v", evicted)
+	}
+
+	for _, key := range []string{"a", "c", "d"} {
+		if _, err := mc.Get(key); err != nil {
+			t.Errorf("expected %q to be kept, got: %v", key, err)
+		}
+	}
+
+	if err := mc.Set("e", make([]byte, 31), time.Minute, entryMeta{}); err != errEntryTooLarge {
+		t.Errorf("expected an entry over the budget to be rejected, got: %v", err)
+	}
+
+	if n := len(mc.index.Metas()); n != 3 {
+		t.Errorf("expected 3 remaining entries, got %d", n)
+	}
+}
+
+func TestCache_PerHostMaxBytes(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=20")
+		_, _ = rw.Write([]byte(strings.Repeat("x", 100)))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, PerHostMaxBytes: 400}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(url string) string {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, url, nil))
+
+		return rw.Header().Get("Cache-Status")
+	}
+
+	serve("http://quiet.example/page")
+
+	for i := 0; i < 10; i++ {
+		serve("http://noisy.example/page/" + strconv.Itoa(i))
+	}
+
+	usage := h.(*cache).cache.HostUsage()
+	if usage["noisy.example"] > 400 {
+		t.Errorf("expected noisy host usage within budget, got %d", usage["noisy.example"])
+	}
+
+	if state := serve("http://quiet.example/page"); state != "hit" {
+		t.Errorf("expected the quiet host entry to be kept, got %q", state)
+	}
+
+	if state := serve("http://noisy.example/page/0"); state != "miss" {
+		t.Errorf("expected the oldest noisy host entry to be evicted, got %q", state)
+	}
+}
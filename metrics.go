@@ -0,0 +1,102 @@
+package plugin_simplecache
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// metrics holds the cache counters exposed on the metrics path.
+type metrics struct {
+	hits   uint64
+	misses uint64
+	errors uint64
+	stores uint64
+	// evictions counts the entries removed from the store, whether expired,
+	// trimmed or purged.
+	evictions uint64
+	// storeTimeouts counts the store operations given up on after
+	// storeTimeoutMs.
+	storeTimeouts uint64
+	// storeRejects counts the store operations failed fast because too many
+	// were given up on but still running, storeAbandoned.
+	storeRejects   uint64
+	storeAbandoned int64
+}
+
+type metricsSnapshot struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Errors        uint64 `json:"errors"`
+	Stores        uint64 `json:"stores"`
+	Evictions     uint64 `json:"evictions"`
+	BufferedBytes int64  `json:"bufferedBytes"`
+	BufferSkips   uint64 `json:"bufferSkips"`
+	EventDrops    uint64 `json:"eventDrops"`
+	// OriginInflight is the number of misses being fetched from the origin.
+	OriginInflight int64  `json:"originInflight"`
+	OriginRejects  uint64 `json:"originRejects"`
+	StoreTimeouts  uint64 `json:"storeTimeouts"`
+	StoreRejects   uint64 `json:"storeRejects"`
+	// DiskSpaceSkips counts the writes skipped by minFreeDiskBytes.
+	DiskSpaceSkips uint64 `json:"diskSpaceSkips"`
+	// HostBytes is the total size of the entries of each host.
+	HostBytes map[string]int `json:"hostBytes"`
+}
+
+func (m *cache) serveMetrics(w http.ResponseWriter) {
+	snapshot := metricsSnapshot{
+		Hits:           atomic.LoadUint64(&m.metrics.hits),
+		Misses:         atomic.LoadUint64(&m.metrics.misses),
+		Errors:         atomic.LoadUint64(&m.metrics.errors),
+		Stores:         atomic.LoadUint64(&m.metrics.stores),
+		Evictions:      atomic.LoadUint64(&m.metrics.evictions),
+		BufferedBytes:  atomic.LoadInt64(&m.budget.used),
+		BufferSkips:    atomic.LoadUint64(&m.budget.skips),
+		HostBytes:      m.cache.HostUsage(),
+		OriginInflight: atomic.LoadInt64(&m.origin.inflight),
+		OriginRejects:  atomic.LoadUint64(&m.origin.rejects),
+		StoreTimeouts:  atomic.LoadUint64(&m.metrics.storeTimeouts),
+		StoreRejects:   atomic.LoadUint64(&m.metrics.storeRejects),
+	}
+
+	if s, ok := m.events.(*webhookSink); ok {
+		snapshot.EventDrops = atomic.LoadUint64(&s.dropped)
+	}
+
+	if fc, ok := m.cache.(*fileCache); ok && fc.guard != nil {
+		snapshot.DiskSpaceSkips = atomic.LoadUint64(&fc.guard.skips)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+}
+
+// bufferBudget bounds the total bytes buffered across all in-flight
+// responses being stored. A zero limit is unbounded.
+type bufferBudget struct {
+	used int64
+	// skips counts responses not stored because the budget was exhausted.
+	skips uint64
+	limit int64
+}
+
+// Reserve reserves n bytes, reporting false if that would exceed the limit.
+func (b *bufferBudget) Reserve(n int64) bool {
+	used := atomic.AddInt64(&b.used, n)
+	if b.limit > 0 && used > b.limit {
+		atomic.AddInt64(&b.used, -n)
+		return false
+	}
+
+	return true
+}
+
+// Release releases n previously reserved bytes.
+func (b *bufferBudget) Release(n int64) {
+	atomic.AddInt64(&b.used, -n)
+}
@@ -0,0 +1,82 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBufferBudget(t *testing.T) {
+	b := &bufferBudget{limit: 10}
+
+	if !b.Reserve(6) {
+		t.Fatal("expected reserve within limit to succeed")
+	}
+
+	if b.Reserve(6) {
+		t.Error("expected reserve over limit to fail")
+	}
+
+	b.Release(6)
+
+	if !b.Reserve(10) {
+		t.Error("expected reserve after release to succeed")
+	}
+
+	if b.used != 10 {
+		t.Errorf("unexpected used bytes: want 10, got %d", b.used)
+	}
+}
+
+func TestCache_MaxBufferMemory(t *testing.T) {
+	dir := createTempDir(t)
+
+	body := strings.Repeat("x", 64)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(body[:32]))
+		_, _ = rw.Write([]byte(body[32:]))
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, MaxBufferMemory: 48, MetricsPath: "/_metrics"}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != "miss" {
+			t.Errorf("unexpected cache state: want \"miss\", got: %q", state)
+		}
+
+		if rw.Body.String() != body {
+			t.Errorf("unexpected body: got %q", rw.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/_metrics", nil)
+	rw := httptest.NewRecorder()
+
+	c.ServeHTTP(rw, req)
+
+	var got metricsSnapshot
+	if err = json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := metricsSnapshot{Misses: 2, BufferSkips: 2, HostBytes: map[string]int{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected metrics: want %+v, got %+v", want, got)
+	}
+}
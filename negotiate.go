@@ -0,0 +1,103 @@
+package plugin_simplecache
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mediaRange is a media range of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the media ranges of an Accept header, ignoring their
+// parameters other than q.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+
+		i := strings.Index(mt, "/")
+		if i <= 0 || i == len(mt)-1 {
+			continue
+		}
+
+		mr := mediaRange{typ: mt[:i], subtype: mt[i+1:], q: 1}
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				q = 0
+			}
+
+			mr.q = q
+		}
+
+		ranges = append(ranges, mr)
+	}
+
+	return ranges
+}
+
+// negotiateType returns the served media type preferred by an Accept header,
+// or "" if none is acceptable. Each served type gets the q-value of the most
+// specific media range matching it, and the first served type wins ties. An
+// empty Accept header accepts any type.
+func negotiateType(header string, served []string) string {
+	if strings.TrimSpace(header) == "" {
+		header = "*/*"
+	}
+
+	ranges := parseAccept(header)
+
+	var (
+		best  string
+		bestQ float64
+	)
+
+	for _, st := range served {
+		st = strings.ToLower(st)
+
+		i := strings.Index(st, "/")
+		if i < 0 {
+			continue
+		}
+
+		typ, subtype := st[:i], st[i+1:]
+
+		q, specificity := 0.0, -1
+		for _, mr := range ranges {
+			var s int
+
+			switch {
+			case mr.typ == typ && mr.subtype == subtype:
+				s = 2
+			case mr.typ == typ && mr.subtype == "*":
+				s = 1
+			case mr.typ == "*" && mr.subtype == "*":
+				s = 0
+			default:
+				continue
+			}
+
+			if s > specificity {
+				q, specificity = mr.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = st, q
+		}
+	}
+
+	return best
+}
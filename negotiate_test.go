@@ -0,0 +1,55 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateType(t *testing.T) {
+	served := []string{"application/json", "text/html"}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "application/json"},
+		{accept: "*/*", want: "application/json"},
+		{accept: "application/json, */*;q=0.8", want: "application/json"},
+		{accept: "text/html", want: "text/html"},
+		{accept: "text/*", want: "text/html"},
+		{accept: "text/html;q=0.9, application/json;q=0.5", want: "text/html"},
+		{accept: "application/json;q=0.5, text/html;q=0.5", want: "application/json"},
+		{accept: "*/*;q=0.1, text/html;q=0.2", want: "text/html"},
+		{accept: "text/html;q=0, */*", want: "application/json"},
+		{accept: "application/json;q=0, */*;q=0.5", want: "text/html"},
+		{accept: "TEXT/HTML; charset=utf-8", want: "text/html"},
+		{accept: "image/png", want: ""},
+		{accept: "text/html;q=0", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := negotiateType(test.accept, served); got != test.want {
+			t.Errorf("unexpected type for %q: want %q, got %q", test.accept, test.want, got)
+		}
+	}
+}
+
+func TestCache_cacheKey_ServedTypes(t *testing.T) {
+	newRequest := func(accept string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/api", nil)
+		req.Header.Set("Accept", accept)
+
+		return req
+	}
+
+	m := &cache{cfg: &Config{ServedTypes: []string{"application/json", "text/html"}}}
+
+	if m.cacheKey(newRequest("application/json, */*;q=0.8")) != m.cacheKey(newRequest("*/*")) {
+		t.Error("expected requests negotiating the same type to share a key")
+	}
+
+	if m.cacheKey(newRequest("text/html")) == m.cacheKey(newRequest("*/*")) {
+		t.Error("expected requests negotiating different types to have distinct keys")
+	}
+}
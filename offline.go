@@ -0,0 +1,108 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// offlineStatuses are the statuses of a proxy that couldn't get a response
+// from the origin.
+var offlineStatuses = map[int]bool{
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// offlinePage is the response served when the origin is unreachable and the
+// cache holds no entry to serve instead.
+type offlinePage struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+// newOfflinePage reads the configured offline page, which is kept in memory
+// so that serving it doesn't depend on the disk. It returns nil if none is
+// configured.
+func newOfflinePage(cfg *Config) (*offlinePage, error) {
+	if cfg.OfflineResponseFile == "" {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadFile(filepath.Clean(cfg.OfflineResponseFile))
+	if err != nil {
+		return nil, fmt.Errorf("invalid offlineResponseFile: %w", err)
+	}
+
+	page := &offlinePage{status: http.StatusServiceUnavailable, contentType: cfg.OfflineContentType, body: body}
+
+	if cfg.OfflineStatus != 0 {
+		if cfg.OfflineStatus < 100 || cfg.OfflineStatus > 599 {
+			return nil, fmt.Errorf("invalid offlineStatus %d", cfg.OfflineStatus)
+		}
+
+		page.status = cfg.OfflineStatus
+	}
+
+	if page.contentType == "" {
+		page.contentType = mime.TypeByExtension(filepath.Ext(cfg.OfflineResponseFile))
+	}
+
+	if page.contentType == "" {
+		page.contentType = http.DetectContentType(body)
+	}
+
+	return page, nil
+}
+
+// failsOffline reports whether a response with the given status should be
+// replaced by the offline page, which is only the case when the origin is
+// unreachable and there is no stale entry to serve.
+func (m *cache) failsOffline(stale *cacheData, status int) bool {
+	return m.offline != nil && stale == nil && offlineStatuses[status]
+}
+
+// serveFailed serves the stale entry or the offline page in place of the
+// failed response held by rw, reporting false if neither applies. The
+// response may be held for the fallback origin only, with a status not to
+// serve stale on.
+func (m *cache) serveFailed(rw *responseWriter, w http.ResponseWriter, r *http.Request, stale *cacheData) bool {
+	switch {
+	case m.failsStale(stale, rw.status):
+		m.serveStale(rw, w, r, stale)
+	case m.failsOffline(stale, rw.status):
+		rw.release()
+
+		// Drop the headers of the failed response.
+		for k := range w.Header() {
+			delete(w.Header(), k)
+		}
+
+		m.serveOffline(w, r)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// serveOffline serves the offline page, which must never be cached
+// downstream.
+func (m *cache) serveOffline(w http.ResponseWriter, r *http.Request) {
+	if m.addStatusHeader(cacheErrorStatus, false) {
+		m.setStatusHeader(w, r, cacheErrorStatus, statusMember{fwd: "miss", detail: "offline"})
+	}
+
+	w.Header().Set("Content-Type", m.offline.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.offline.body)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(m.offline.status)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(m.offline.body)
+	}
+}
@@ -0,0 +1,94 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache_ServeHTTP_OfflineResponse(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "offline.html")
+	if err := ioutil.WriteFile(file, []byte("<h1>Be right back</h1>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	status := http.StatusOK
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Origin", "yes")
+		rw.WriteHeader(status)
+		_, _ = rw.Write([]byte("origin"))
+	}
+
+	cfg := &Config{
+		Backend:             backendMemory,
+		MaxExpiry:           10,
+		Cleanup:             20,
+		AddStatusHeader:     true,
+		OfflineResponseFile: file,
+		OfflineStatus:       http.StatusOK,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/cached", nil))
+
+	if body := rw.Body.String(); body != "origin" {
+		t.Errorf("unexpected body: want %q, got %q", "origin", body)
+	}
+
+	status = http.StatusBadGateway
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusOK, rw.Code)
+	}
+
+	if body := rw.Body.String(); body != "<h1>Be right back</h1>" {
+		t.Errorf("unexpected body: got %q", body)
+	}
+
+	if ct := rw.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type: got %q", ct)
+	}
+
+	if cc := rw.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("unexpected cache control: want %q, got %q", "no-store", cc)
+	}
+
+	if state := rw.Header().Get("Cache-Status"); state != cacheErrorStatus {
+		t.Errorf("unexpected cache state: want %q, got %q", cacheErrorStatus, state)
+	}
+
+	if v := rw.Header().Get("X-Origin"); v != "" {
+		t.Errorf("unexpected origin header %q", v)
+	}
+
+	// A cached entry is still served from the cache.
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/cached", nil))
+
+	if body := rw.Body.String(); body != "origin" {
+		t.Errorf("unexpected body: want %q, got %q", "origin", body)
+	}
+
+	// Other failures are passed through.
+	status = http.StatusInternalServerError
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if rw.Code != http.StatusInternalServerError || rw.Body.String() != "origin" {
+		t.Errorf("unexpected response: %d %q", rw.Code, rw.Body.String())
+	}
+}
@@ -0,0 +1,114 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultFallbackOriginTimeout bounds requests to the fallback origin when
+// no timeout is configured.
+const defaultFallbackOriginTimeout = 10 * time.Second
+
+// hopHeaders are the hop-by-hop headers, which aren't forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// fallbackOrigin is a backup origin requests are sent to when next fails.
+type fallbackOrigin struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func newFallbackOrigin(cfg *Config) (*fallbackOrigin, error) {
+	if cfg.FallbackOrigin == "" {
+		return nil, nil
+	}
+
+	base, err := url.Parse(cfg.FallbackOrigin)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid fallbackOrigin %q", cfg.FallbackOrigin)
+	}
+
+	if cfg.FallbackOriginTimeout < 0 {
+		return nil, fmt.Errorf("fallbackOriginTimeout must be greater or equal to 0")
+	}
+
+	timeout := defaultFallbackOriginTimeout
+	if cfg.FallbackOriginTimeout > 0 {
+		timeout = time.Duration(cfg.FallbackOriginTimeout) * time.Second
+	}
+
+	return &fallbackOrigin{base: base, client: &http.Client{
+		Timeout: timeout,
+		// Redirects are for the client to follow.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}, nil
+}
+
+// fails reports whether a response to r with the given status should be
+// replaced by the response of the fallback origin.
+func (o *fallbackOrigin) fails(r *http.Request, status int) bool {
+	if o == nil || status < 500 {
+		return false
+	}
+
+	// Requests with bodies can't be replayed, the body was read by next.
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// Do sends r to the fallback origin.
+func (o *fallbackOrigin) Do(r *http.Request) (*http.Response, error) {
+	u := *o.base
+	u.Path = strings.TrimSuffix(o.base.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+
+	req.Header.Set("X-Forwarded-Host", r.Host)
+
+	return o.client.Do(req)
+}
+
+// responseHandler returns a handler replaying resp.
+func responseHandler(resp *http.Response) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		for key, vals := range resp.Header {
+			w.Header()[key] = vals
+		}
+
+		for _, h := range hopHeaders {
+			w.Header().Del(h)
+		}
+
+		w.WriteHeader(resp.StatusCode)
+
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("Error copying fallback origin response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_FallbackOrigin(t *testing.T) {
+	backup := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Origin", "backup")
+		_, _ = rw.Write([]byte("backup " + req.URL.RequestURI()))
+	}))
+	defer backup.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Origin", "primary")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("primary down"))
+	}
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantStatus int
+		wantBody   string
+		wantOrigin string
+		wantState  string
+	}{
+		{name: "serves and caches the fallback", origin: backup.URL, wantStatus: http.StatusOK, wantBody: "backup /page?q=1", wantOrigin: "backup", wantState: "hit"},
+		{name: "serves the failure if the fallback is down", origin: down.URL, wantStatus: http.StatusServiceUnavailable, wantBody: "primary down", wantOrigin: "primary", wantState: "miss"},
+		{name: "serves the failure without fallback", wantStatus: http.StatusServiceUnavailable, wantBody: "primary down", wantOrigin: "primary", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := createTempDir(t)
+
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, FallbackOrigin: test.origin}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rw *httptest.ResponseRecorder
+
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page?q=1", nil))
+
+				if rw.Code != test.wantStatus || rw.Body.String() != test.wantBody || rw.Header().Get("X-Origin") != test.wantOrigin {
+					t.Errorf("unexpected response: want %d %q from %s, got %d %q from %s",
+						test.wantStatus, test.wantBody, test.wantOrigin, rw.Code, rw.Body.String(), rw.Header().Get("X-Origin"))
+				}
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_FallbackOriginStaleOnStatuses(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("backup failed"))
+	}))
+	defer failing.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("primary down"))
+	}
+
+	tests := []struct {
+		name       string
+		origin     string
+		staleOn    []int
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "fallback down, unlisted status", origin: down.URL, staleOn: []int{429}, wantStatus: http.StatusServiceUnavailable, wantBody: "primary down"},
+		{name: "fallback fails, unlisted status", origin: failing.URL, staleOn: []int{429, 503}, wantStatus: http.StatusInternalServerError, wantBody: "backup failed"},
+		{name: "fallback down, listed status", origin: down.URL, staleOn: []int{503}, wantStatus: http.StatusOK, wantBody: "stale content"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{
+				Path:            createTempDir(t),
+				MaxExpiry:       10,
+				Cleanup:         20,
+				StaleIfError:    60,
+				StaleOnStatuses: test.staleOn,
+				FallbackOrigin:  test.origin,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+
+			c.store(c.cacheKey(req), req, &cacheData{
+				Status:       http.StatusOK,
+				Headers:      map[string][]string{"Content-Type": {"text/plain"}},
+				Body:         []byte("stale content"),
+				ResponseTime: time.Now().Add(-20 * time.Second),
+				Expires:      time.Now().Add(-10 * time.Second),
+			}, time.Minute)
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatus || rw.Body.String() != test.wantBody {
+				t.Errorf("unexpected response: want %d %q, got %d %q", test.wantStatus, test.wantBody, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
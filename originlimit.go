@@ -0,0 +1,105 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+func validateOriginLimit(cfg *Config) error {
+	if cfg.MaxOriginConcurrency < 0 {
+		return errors.New("maxOriginConcurrency must be greater or equal to 0")
+	}
+
+	if cfg.OriginQueueTimeoutMs < 0 {
+		return errors.New("originQueueTimeoutMs must be greater or equal to 0")
+	}
+
+	return nil
+}
+
+// originLimiter bounds the number of misses fetched from the origin at once.
+// A nil slots channel is unbounded.
+type originLimiter struct {
+	// inflight is the number of misses being fetched from the origin.
+	inflight int64
+	// rejects counts misses not fetched because no slot freed up in time.
+	rejects uint64
+
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newOriginLimiter(limit, timeoutMs int) *originLimiter {
+	l := &originLimiter{timeout: time.Duration(timeoutMs) * time.Millisecond}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+
+	return l
+}
+
+// Acquire takes a slot, waiting up to the queue timeout for one to free up,
+// reporting false if none did.
+func (l *originLimiter) Acquire(ctx context.Context) bool {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if !l.wait(ctx) {
+				atomic.AddUint64(&l.rejects, 1)
+				return false
+			}
+		}
+	}
+
+	atomic.AddInt64(&l.inflight, 1)
+
+	return true
+}
+
+func (l *originLimiter) wait(ctx context.Context) bool {
+	if l.timeout <= 0 {
+		return false
+	}
+
+	t := time.NewTimer(l.timeout)
+	defer t.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (l *originLimiter) Release() {
+	atomic.AddInt64(&l.inflight, -1)
+
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// serveOriginBusy serves a miss that couldn't be fetched from the busy origin,
+// from the stale entry if any.
+func (m *cache) serveOriginBusy(w http.ResponseWriter, r *http.Request, stale *cacheData) {
+	if stale != nil {
+		m.serveStaleEntry(w, r, stale)
+		return
+	}
+
+	if m.addStatusHeader(cacheErrorStatus, false) {
+		m.setStatusHeader(w, r, cacheErrorStatus, statusMember{detail: "origin-busy"})
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(1))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
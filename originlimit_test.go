@@ -0,0 +1,134 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_ServeHTTP_MaxOriginConcurrency(t *testing.T) {
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	var current, peak int32
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		entered <- struct{}{}
+		<-release
+		atomic.AddInt32(&current, -1)
+
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Backend:              backendMemory,
+		MaxExpiry:            10,
+		Cleanup:              20,
+		MaxOriginConcurrency: 2,
+		OriginQueueTimeoutMs: 10000,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	var wg sync.WaitGroup
+
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page/"+strconv.Itoa(i), nil))
+			codes[i] = rw.Code
+		}(i)
+	}
+
+	<-entered
+	<-entered
+
+	if n := atomic.LoadInt64(&c.origin.inflight); n != 2 {
+		t.Errorf("unexpected in-flight origin fetches: want 2, got %d", n)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if p := atomic.LoadInt32(&peak); p != 2 {
+		t.Errorf("unexpected peak origin concurrency: want 2, got %d", p)
+	}
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: unexpected status %d", i, code)
+		}
+	}
+
+	if n := atomic.LoadInt64(&c.origin.inflight); n != 0 {
+		t.Errorf("unexpected in-flight origin fetches: want 0, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTP_MaxOriginConcurrencyReject(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, MaxOriginConcurrency: 1}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/a", nil))
+	}()
+
+	<-entered
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/b", nil))
+
+	close(release)
+	<-done
+
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: want %d, got %d", http.StatusServiceUnavailable, rw.Code)
+	}
+
+	if v := rw.Header().Get("Retry-After"); v == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	if n := atomic.LoadUint64(&c.origin.rejects); n != 1 {
+		t.Errorf("unexpected rejects: want 1, got %d", n)
+	}
+}
@@ -0,0 +1,69 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// PathRule adjusts caching of the paths starting with PathPrefix.
+type PathRule struct {
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix" toml:"pathPrefix"`
+	// IgnoreQuery leaves the whole query string out of the cache key.
+	IgnoreQuery bool `json:"ignoreQuery" yaml:"ignoreQuery" toml:"ignoreQuery"`
+	// CacheOnlyWhenNoQuery bypasses the cache for requests with a query string.
+	CacheOnlyWhenNoQuery bool `json:"cacheOnlyWhenNoQuery" yaml:"cacheOnlyWhenNoQuery" toml:"cacheOnlyWhenNoQuery"`
+	// ExpireEvery makes entries expire at the next multiple of this many
+	// seconds of the UTC clock, instead of a rolling TTL.
+	ExpireEvery int `json:"expireEvery" yaml:"expireEvery" toml:"expireEvery"`
+	// ExpireJitter spreads the expiries over up to this many seconds past
+	// the boundary.
+	ExpireJitter int `json:"expireJitter" yaml:"expireJitter" toml:"expireJitter"`
+}
+
+func validatePathRules(rules []PathRule) error {
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return fmt.Errorf("rule %d: pathPrefix must start with /", i)
+		}
+
+		if rule.ExpireEvery < 0 {
+			return fmt.Errorf("rule %d: expireEvery must be greater or equal to 0", i)
+		}
+
+		if rule.ExpireJitter < 0 {
+			return fmt.Errorf("rule %d: expireJitter must be greater or equal to 0", i)
+		}
+
+		// Entries must not outlive the next boundary.
+		if rule.ExpireJitter > 0 && rule.ExpireJitter >= rule.ExpireEvery {
+			return fmt.Errorf("rule %d: expireJitter must be less than expireEvery", i)
+		}
+	}
+
+	return nil
+}
+
+// pathRule returns the first path rule applying to path, if any.
+func (m *cache) pathRule(path string) (PathRule, bool) {
+	for _, rule := range m.cfg.PathRules {
+		if strings.HasPrefix(path, rule.PathPrefix) {
+			return rule, true
+		}
+	}
+
+	return PathRule{}, false
+}
+
+// boundaryExpiry returns the time left from now until the next multiple of
+// every since the zero time, a UTC clock boundary, pushed back by a random
+// jitter of up to jitter.
+func boundaryExpiry(now time.Time, every, jitter time.Duration) time.Duration {
+	expiry := now.Truncate(every).Add(every).Sub(now)
+	if jitter > 0 {
+		expiry += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	return expiry
+}
@@ -0,0 +1,293 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidatePathRules(t *testing.T) {
+	if err := validatePathRules([]PathRule{{PathPrefix: "/static/", IgnoreQuery: true}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, prefix := range []string{"", "static/"} {
+		if err := validatePathRules([]PathRule{{PathPrefix: prefix}}); err == nil {
+			t.Errorf("expected an error for prefix %q", prefix)
+		}
+	}
+
+	for _, rule := range []PathRule{
+		{PathPrefix: "/", ExpireEvery: -1},
+		{PathPrefix: "/", ExpireEvery: 3600, ExpireJitter: -1},
+		{PathPrefix: "/", ExpireEvery: 3600, ExpireJitter: 3600},
+		{PathPrefix: "/", ExpireJitter: 60},
+	} {
+		if err := validatePathRules([]PathRule{rule}); err == nil {
+			t.Errorf("expected an error for rule %+v", rule)
+		}
+	}
+}
+
+func TestBoundaryExpiry(t *testing.T) {
+	tests := []struct {
+		now   string
+		every time.Duration
+		want  time.Duration
+	}{
+		{now: "2024-05-01T10:20:30Z", every: time.Hour, want: 39*time.Minute + 30*time.Second},
+		{now: "2024-05-01T10:00:00Z", every: time.Hour, want: time.Hour},
+		{now: "2024-05-01T10:59:59Z", every: time.Hour, want: time.Second},
+		{now: "2024-05-01T10:20:30Z", every: 15 * time.Minute, want: 9*time.Minute + 30*time.Second},
+		{now: "2024-05-01T22:00:00Z", every: 24 * time.Hour, want: 2 * time.Hour},
+		{now: "2024-05-01T12:20:30+02:00", every: time.Hour, want: 39*time.Minute + 30*time.Second},
+	}
+
+	for _, test := range tests {
+		now, err := time.Parse(time.RFC3339, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := boundaryExpiry(now, test.every, 0); got != test.want {
+			t.Errorf("%s every %s: want %s, got %s", test.now, test.every, test.want, got)
+		}
+	}
+
+	now, _ := time.Parse(time.RFC3339, "2024-05-01T10:20:30Z")
+	for i := 0; i < 100; i++ {
+		got := boundaryExpiry(now, time.Hour, time.Minute)
+		if min := 39*time.Minute + 30*time.Second; got < min || got >= min+time.Minute {
+			t.Fatalf("unexpected jittered expiry: want within a minute past %s, got %s", min, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_PathRuleIgnoreQuery(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(req.URL.RawQuery))
+	}
+
+	cfg := &Config{
+		Backend:         backendMemory,
+		MaxExpiry:       10,
+		Cleanup:         20,
+		AddStatusHeader: true,
+		PathRules:       []PathRule{{PathPrefix: "/static/", IgnoreQuery: true}},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	tests := []struct {
+		target    string
+		wantState string
+		wantBody  string
+	}{
+		{target: "/static/logo.png", wantState: "miss", wantBody: ""},
+		{target: "/static/logo.png?v=2", wantState: "hit", wantBody: ""},
+		{target: "/static/logo.png?v=3&size=large", wantState: "hit", wantBody: ""},
+		{target: "/page", wantState: "miss", wantBody: ""},
+		{target: "/page?v=2", wantState: "miss", wantBody: "v=2"},
+	}
+
+	for _, test := range tests {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+test.target, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("%s: unexpected cache state: want %q, got: %q", test.target, test.wantState, state)
+		}
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("%s: unexpected body: want %q, got %q", test.target, test.wantBody, body)
+		}
+	}
+
+	if n := len(c.cache.(*memoryCache).values); n != 3 {
+		t.Errorf("unexpected number of entries: want 3, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTP_PathRuleCacheOnlyWhenNoQuery(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(req.URL.RawQuery))
+	}
+
+	cfg := &Config{
+		Backend:         backendMemory,
+		MaxExpiry:       10,
+		Cleanup:         20,
+		AddStatusHeader: true,
+		PathRules:       []PathRule{{PathPrefix: "/products/", CacheOnlyWhenNoQuery: true}},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	tests := []struct {
+		target    string
+		wantState string
+		wantBody  string
+	}{
+		{target: "/products/shoes", wantState: "miss", wantBody: ""},
+		{target: "/products/shoes", wantState: "hit", wantBody: ""},
+		{target: "/products/shoes?q=red", wantState: "miss", wantBody: "q=red"},
+		{target: "/products/shoes?q=blue", wantState: "miss", wantBody: "q=blue"},
+		{target: "/page?v=2", wantState: "miss", wantBody: "v=2"},
+		{target: "/page?v=2", wantState: "hit", wantBody: "v=2"},
+	}
+
+	for _, test := range tests {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost"+test.target, nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("%s: unexpected cache state: want %q, got: %q", test.target, test.wantState, state)
+		}
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("%s: unexpected body: want %q, got %q", test.target, test.wantBody, body)
+		}
+	}
+
+	if n := len(c.cache.(*memoryCache).values); n != 2 {
+		t.Errorf("unexpected number of entries: want 2, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTP_PathRuleExpireEvery(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/reports/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Backend:         backendMemory,
+		MaxExpiry:       3600,
+		Cleanup:         600,
+		AddStatusHeader: true,
+		StatusTTLs:      map[string]int{"404": 10},
+		PathRules:       []PathRule{{PathPrefix: "/reports/", ExpireEvery: 3600}},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	before := time.Now()
+	for _, target := range []string{"/reports/daily", "/reports/weekly", "/reports/missing", "/page"} {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost"+target, nil))
+	}
+	after := time.Now()
+
+	mc := c.cache.(*memoryCache)
+
+	var aligned int
+	for _, meta := range mc.index.Metas() {
+		e, _ := mc.index.Get(meta.Key)
+
+		switch meta.Path {
+		case "/page":
+			if ttl := e.expires.Sub(before); ttl < 3600*time.Second || ttl > 3601*time.Second {
+				t.Errorf("%s: unexpected TTL: want 3600s, got %s", meta.Path, ttl)
+			}
+			continue
+		case "/reports/missing":
+			// The explicit status TTL wins over the boundary.
+			if ttl := e.expires.Sub(before); ttl < 10*time.Second || ttl > 11*time.Second {
+				t.Errorf("%s: unexpected TTL: want 10s, got %s", meta.Path, ttl)
+			}
+			continue
+		}
+
+		// The boundary is past the whole hour the requests fell in, unless
+		// they straddled it.
+		boundary := before.Truncate(time.Hour).Add(time.Hour)
+		if !after.Before(boundary) {
+			boundary = after.Truncate(time.Hour).Add(time.Hour)
+		}
+
+		if d := e.expires.Sub(boundary); d < -time.Second || d > time.Second {
+			t.Errorf("%s: unexpected expiry: want %s, got %s", meta.Path, boundary, e.expires)
+		}
+
+		aligned++
+	}
+
+	if aligned != 2 {
+		t.Errorf("unexpected number of boundary-aligned entries: want 2, got %d", aligned)
+	}
+
+	// Age the entry past maxExpiry, as if stored 4000s ago: it is still
+	// served until the boundary.
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/daily", nil)
+	key := c.cacheKey(req)
+
+	data, err := c.get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := mc.index.Get(key)
+	data.ResponseTime = data.ResponseTime.Add(-4000 * time.Second)
+	c.set(key, req, data, time.Until(e.expires))
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, req)
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want %q, got %q", "hit", state)
+	}
+
+	if age := rw.Header().Get("Age"); age != "4000" && age != "4001" {
+		t.Errorf("unexpected age: want 4000, got %s", age)
+	}
+}
+
+func TestNew_ExpireEveryMaxServeAge(t *testing.T) {
+	rules := []PathRule{{PathPrefix: "/reports/", ExpireEvery: 3600, ExpireJitter: 60}}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 3660, Cleanup: 600, MaxServeAge: 3600, PathRules: rules}
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err == nil {
+		t.Error("expected an error")
+	}
+
+	cfg.MaxServeAge = 3660
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNew_ExpireEveryMaxExpiry(t *testing.T) {
+	rules := []PathRule{{PathPrefix: "/reports/", ExpireEvery: 3600, ExpireJitter: 60}}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 3600, Cleanup: 600, PathRules: rules}
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err == nil {
+		t.Error("expected an error")
+	}
+
+	cfg.MaxExpiry = 3660
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package plugin_simplecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPersistInterval is the interval at which persisted counters are
+// written to disk. Counts since the last write are lost on a crash.
+const metricsPersistInterval = time.Minute
+
+// persistedMetrics holds the lifetime counters written to the cache path.
+type persistedMetrics struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Errors    uint64 `json:"errors"`
+	Stores    uint64 `json:"stores"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// metricsFile returns the path of the file the counters of the middleware
+// named name are persisted to, so that instances sharing the cache path keep
+// their own counters.
+func metricsFile(dir, name string) string {
+	name = strings.NewReplacer("/", "-", string(filepath.Separator), "-").Replace(name)
+
+	return filepath.Join(dir, "metrics-"+name+".json")
+}
+
+// load restores the counters persisted to path. A missing or corrupt
+// file leaves them at zero.
+func (mt *metrics) load(path string) {
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading persisted metrics: %v", err)
+		}
+		return
+	}
+
+	var p persistedMetrics
+	if err = json.Unmarshal(b, &p); err != nil {
+		log.Printf("Error decoding persisted metrics, starting from zero: %v", err)
+		return
+	}
+
+	atomic.StoreUint64(&mt.hits, p.Hits)
+	atomic.StoreUint64(&mt.misses, p.Misses)
+	atomic.StoreUint64(&mt.errors, p.Errors)
+	atomic.StoreUint64(&mt.stores, p.Stores)
+	atomic.StoreUint64(&mt.evictions, p.Evictions)
+}
+
+// save writes the counters to path, through a temp file renamed into place
+// so that a crash never leaves a partially written file.
+func (mt *metrics) save(path string, mode os.FileMode) error {
+	b, err := json.Marshal(persistedMetrics{
+		Hits:      atomic.LoadUint64(&mt.hits),
+		Misses:    atomic.LoadUint64(&mt.misses),
+		Errors:    atomic.LoadUint64(&mt.errors),
+		Stores:    atomic.LoadUint64(&mt.stores),
+		Evictions: atomic.LoadUint64(&mt.evictions),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding metrics: %w", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*"+tmpSuffix)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+
+	tmp := f.Name()
+
+	if err = f.Chmod(mode); err == nil {
+		_, err = f.Write(b)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+
+	return nil
+}
+
+// persist saves the counters to path at every interval.
+func (mt *metrics) persist(path string, mode os.FileMode, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for range timer.C {
+		if err := mt.save(path, mode); err != nil {
+			log.Printf("Error persisting metrics: %v", err)
+		}
+	}
+}
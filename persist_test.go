@@ -0,0 +1,96 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_PersistMetrics(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, PersistMetrics: true}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	for i := 0; i < 3; i++ {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+	}
+
+	file := metricsFile(dir, "simplecache")
+	if err = c.metrics.save(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNoTempFiles(t, dir)
+
+	// Simulate a restart.
+	h, err = New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := h.(*cache)
+
+	if n := atomic.LoadUint64(&restarted.metrics.hits); n != 2 {
+		t.Errorf("unexpected hits: want 2, got %d", n)
+	}
+
+	if n := atomic.LoadUint64(&restarted.metrics.misses); n != 1 {
+		t.Errorf("unexpected misses: want 1, got %d", n)
+	}
+
+	if n := atomic.LoadUint64(&restarted.metrics.stores); n != 1 {
+		t.Errorf("unexpected stores: want 1, got %d", n)
+	}
+
+	// Other instances keep their own counters.
+	h, err = New(context.Background(), http.HandlerFunc(next), cfg, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadUint64(&h.(*cache).metrics.hits); n != 0 {
+		t.Errorf("unexpected hits of another instance: want 0, got %d", n)
+	}
+}
+
+func TestMetrics_LoadCorrupt(t *testing.T) {
+	dir := createTempDir(t)
+
+	file := filepath.Join(dir, "metrics.json")
+	if err := ioutil.WriteFile(file, []byte(`{"hits":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mt := &metrics{hits: 1}
+	mt.load(file)
+
+	if mt.hits != 1 {
+		t.Errorf("expected a corrupt file to be ignored, got %d hits", mt.hits)
+	}
+
+	mt.load(filepath.Join(dir, "missing.json"))
+
+	if mt.hits != 1 {
+		t.Errorf("expected a missing file to be ignored, got %d hits", mt.hits)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the corrupt file to be left alone, got: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func validatePlaceholder(cfg *Config) error {
+	if len(cfg.PlaceholderPaths) == 0 {
+		return nil
+	}
+
+	// Placeholder paths are warmed like prefetched pages.
+	if cfg.PrefetchConcurrency < 1 {
+		return errors.New("prefetchConcurrency must be greater or equal to 1")
+	}
+
+	if cfg.PlaceholderStatus != 0 && (cfg.PlaceholderStatus < 100 || cfg.PlaceholderStatus > 599) {
+		return fmt.Errorf("invalid placeholderStatus %d", cfg.PlaceholderStatus)
+	}
+
+	if cfg.PlaceholderRetryAfter < 0 {
+		return errors.New("placeholderRetryAfter must be greater or equal to 0")
+	}
+
+	return nil
+}
+
+// maxColdWarms bounds the number of keys remembered by coldWarms.
+const maxColdWarms = 10000
+
+// servePlaceholder serves the placeholder response to a cold miss on a
+// placeholder path, stored under key, while the entry is warmed in the
+// background, reporting whether it did. Once a warm stored nothing, e.g. for
+// an error or an uncacheable response, the misses on key are fetched as
+// usual until a response is stored, rather than served the placeholder and
+// warmed again each time.
+func (m *cache) servePlaceholder(w http.ResponseWriter, r *http.Request, key string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if !m.placeholderPath(r.URL.Path) || m.coldWarms.Has(key) {
+		return false
+	}
+
+	req, err := backgroundRequest(r, r.URL.RequestURI())
+	if err != nil {
+		return false
+	}
+
+	warmed := func(stored bool) {
+		if !stored {
+			m.coldWarms.Add(key)
+		}
+	}
+
+	if !m.background(req, key, warmed) {
+		return false
+	}
+
+	status := m.cfg.PlaceholderStatus
+	if status == 0 {
+		status = http.StatusAccepted
+	}
+
+	if m.addStatusHeader(cacheMissStatus, false) {
+		m.setStatusHeader(w, r, cacheMissStatus, statusMember{detail: "placeholder"})
+	}
+
+	// The placeholder stands in for the real response only until it is
+	// cached, so must not be cached downstream.
+	w.Header().Set("Cache-Control", "no-store")
+
+	if m.cfg.PlaceholderRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(m.cfg.PlaceholderRetryAfter))
+	}
+
+	w.WriteHeader(status)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write([]byte(m.cfg.PlaceholderBody))
+	}
+
+	return true
+}
+
+// placeholderPath reports whether misses on path are served the placeholder.
+func (m *cache) placeholderPath(path string) bool {
+	for _, prefix := range m.cfg.PlaceholderPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keySet is a set of keys bounded to max keys, emptied once full.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+	max  int
+}
+
+// Add adds key to the set.
+func (s *keySet) Add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) >= s.max {
+		s.keys = map[string]struct{}{}
+	}
+
+	s.keys[key] = struct{}{}
+}
+
+// Has reports whether key is in the set.
+func (s *keySet) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.keys[key]
+
+	return ok
+}
+
+// Delete removes key from the set.
+func (s *keySet) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+}
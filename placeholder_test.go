@@ -0,0 +1,158 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_ServeHTTP_Placeholder(t *testing.T) {
+	release := make(chan struct{})
+
+	var calls int32
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if req.URL.Path == "/reports/yearly" {
+			<-release
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("report"))
+	}
+
+	cfg := &Config{
+		Backend:               backendMemory,
+		MaxExpiry:             10,
+		Cleanup:               20,
+		AddStatusHeader:       true,
+		PrefetchConcurrency:   1,
+		PlaceholderPaths:      []string{"/reports/"},
+		PlaceholderBody:       "coming soon",
+		PlaceholderRetryAfter: 5,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	// Both requests arrive while the entry is being warmed.
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/reports/yearly", nil))
+
+		if rw.Code != http.StatusAccepted {
+			t.Fatalf("unexpected status: want %d, got %d", http.StatusAccepted, rw.Code)
+		}
+
+		if body := rw.Body.String(); body != "coming soon" {
+			t.Errorf("unexpected body: %q", body)
+		}
+
+		if v := rw.Header().Get("Retry-After"); v != "5" {
+			t.Errorf("unexpected Retry-After: %q", v)
+		}
+
+		if v := rw.Header().Get("Cache-Control"); v != "no-store" {
+			t.Errorf("unexpected Cache-Control: %q", v)
+		}
+	}
+
+	close(release)
+	c.bg.Wait()
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/reports/yearly", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+	}
+
+	if body := rw.Body.String(); body != "report" {
+		t.Errorf("unexpected body: %q", body)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("unexpected origin requests: want 1, got %d", n)
+	}
+
+	// Other paths are fetched on a miss as usual.
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/home", nil))
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "report" {
+		t.Errorf("unexpected response: %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestCache_ServeHTTP_PlaceholderUncacheable(t *testing.T) {
+	status := int32(http.StatusInternalServerError)
+
+	var calls int32
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		rw.WriteHeader(int(atomic.LoadInt32(&status)))
+		_, _ = rw.Write([]byte("report"))
+	}
+
+	cfg := &Config{
+		Backend:             backendMemory,
+		MaxExpiry:           10,
+		Cleanup:             20,
+		AddStatusHeader:     true,
+		PrefetchConcurrency: 1,
+		PlaceholderPaths:    []string{"/reports/"},
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	serve := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/reports/yearly", nil))
+		c.bg.Wait()
+
+		return rw
+	}
+
+	if rw := serve(); rw.Code != http.StatusAccepted {
+		t.Fatalf("expected the first miss to get the placeholder, got %d", rw.Code)
+	}
+
+	// The warm got a 500, which isn't stored, so the next misses are
+	// passed through instead of warmed again.
+	for i := 0; i < 2; i++ {
+		if rw := serve(); rw.Code != http.StatusInternalServerError {
+			t.Errorf("expected the origin error to be passed through, got %d", rw.Code)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("unexpected origin requests: want 3, got %d", n)
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+
+	if rw := serve(); rw.Code != http.StatusOK {
+		t.Errorf("unexpected status once the origin recovered: %d", rw.Code)
+	}
+
+	if rw := serve(); rw.Header().Get("Cache-Status") != "hit" {
+		t.Errorf("expected the recovered response to be cached, got %q", rw.Header().Get("Cache-Status"))
+	}
+
+	if c.coldWarms.Has(c.cacheKey(httptest.NewRequest(http.MethodGet, "http://localhost/reports/yearly", nil))) {
+		t.Error("expected the key to be forgotten once a response was stored")
+	}
+}
@@ -0,0 +1,183 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PrefetchRule warms the pages following the requested one in the
+// background, for paginated paths where the next page is likely the next
+// request.
+type PrefetchRule struct {
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix" toml:"pathPrefix"`
+	Param      string `json:"param" yaml:"param" toml:"param"`
+	Pages      int    `json:"pages" yaml:"pages" toml:"pages"`
+}
+
+func validatePrefetch(cfg *Config) error {
+	if len(cfg.Prefetch) == 0 {
+		return nil
+	}
+
+	if cfg.PrefetchConcurrency < 1 {
+		return errors.New("prefetchConcurrency must be greater or equal to 1")
+	}
+
+	for i, rule := range cfg.Prefetch {
+		if rule.Param == "" {
+			return fmt.Errorf("prefetch rule %d: param is required", i)
+		}
+
+		if rule.Pages < 1 {
+			return fmt.Errorf("prefetch rule %d: pages must be greater or equal to 1", i)
+		}
+	}
+
+	return nil
+}
+
+// prefetch warms the URLs the prefetch rules derive from the request.
+func (m *cache) prefetch(r *http.Request) {
+	for _, rule := range m.cfg.Prefetch {
+		if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+
+		page, err := strconv.Atoi(r.URL.Query().Get(rule.Param))
+		if err != nil {
+			continue
+		}
+
+		for i := 1; i <= rule.Pages; i++ {
+			q := r.URL.Query()
+			q.Set(rule.Param, strconv.Itoa(page+i))
+
+			u := *r.URL
+			u.RawQuery = q.Encode()
+
+			m.warm(r, u.String())
+		}
+	}
+}
+
+// warm fetches the given URL through next in the background and stores the
+// response, reporting whether the URL is being fetched. Nothing is done if the
+// URL is already cached or being fetched, or if the maximum number of
+// background fetches are already running.
+func (m *cache) warm(r *http.Request, rawURL string) bool {
+	req, err := backgroundRequest(r, rawURL)
+	if err != nil {
+		return false
+	}
+
+	key := m.cacheKey(req)
+	if _, err = m.lookup(key, req); err == nil {
+		return false
+	}
+
+	return m.background(req, key, nil)
+}
+
+// backgroundRequest returns the request for the given URL to fetch in the
+// background on behalf of r.
+func backgroundRequest(r *http.Request, rawURL string) (*http.Request, error) {
+	req := r.Clone(context.Background())
+
+	u, err := req.URL.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req.URL = u
+	req.RequestURI = u.RequestURI()
+	req.Body = http.NoBody
+	req.ContentLength = 0
+
+	// The fetched entry must be the full resource.
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		req.Header.Del(h)
+	}
+
+	return req, nil
+}
+
+// background fetches req through next in the background and stores the
+// response under key, reporting whether key is being fetched. Nothing is done
+// if key is already being fetched, or if the maximum number of background
+// fetches are already running. done, if any, is called once the fetch
+// completed, with whether the response was stored.
+func (m *cache) background(req *http.Request, key string, done func(stored bool)) bool {
+	if !m.inflight.Acquire(key) {
+		return true
+	}
+
+	select {
+	case m.warmSem <- struct{}{}:
+	default:
+		m.inflight.Release(key)
+		return false
+	}
+
+	m.bg.Add(1)
+
+	go func() {
+		defer m.bg.Done()
+		defer func() { <-m.warmSem }()
+		defer m.inflight.Release(key)
+
+		stored := m.fetch(&discardWriter{header: http.Header{}}, req, key, cacheMissStatus)
+		if done != nil {
+			done(stored)
+		}
+	}()
+
+	return true
+}
+
+// inflight tracks the keys currently being fetched from next.
+type inflight struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// Acquire marks key as being fetched, reporting false if it already was.
+func (f *inflight) Acquire(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.keys[key]; ok {
+		return false
+	}
+
+	f.keys[key] = struct{}{}
+
+	return true
+}
+
+// Release marks key as no longer being fetched.
+func (f *inflight) Release(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.keys, key)
+}
+
+// discardWriter is the writer background fetches are served to.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardWriter) WriteHeader(int) {}
@@ -0,0 +1,135 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCache_Prefetch(t *testing.T) {
+	dir := createTempDir(t)
+
+	var (
+		mu    sync.Mutex
+		pages []string
+	)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		pages = append(pages, req.URL.Query().Get("page"))
+		mu.Unlock()
+
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("page " + req.URL.Query().Get("page")))
+	}
+
+	cfg := &Config{
+		Path:                dir,
+		MaxExpiry:           10,
+		Cleanup:             20,
+		AddStatusHeader:     true,
+		Prefetch:            []PrefetchRule{{PathPrefix: "/items", Param: "page", Pages: 2}},
+		PrefetchConcurrency: 2,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/items?page=1&size=10", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+	c.bg.Wait()
+
+	for _, page := range []string{"2", "3"} {
+		req = httptest.NewRequest(http.MethodGet, "http://localhost/items?page="+page+"&size=10", nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != "hit" {
+			t.Errorf("unexpected cache state for page %s: want \"hit\", got: %q", page, state)
+		}
+
+		if body := rw.Body.String(); body != "page "+page {
+			t.Errorf("unexpected body: want %q, got %q", "page "+page, body)
+		}
+	}
+
+	// Warmed pages must not trigger further prefetches.
+	if len(pages) != 3 {
+		t.Errorf("unexpected origin requests: %v", pages)
+	}
+}
+
+func TestCache_PrefetchConcurrency(t *testing.T) {
+	dir := createTempDir(t)
+
+	release := make(chan struct{})
+
+	var (
+		mu    sync.Mutex
+		pages []string
+	)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		page := req.URL.Query().Get("page")
+
+		mu.Lock()
+		pages = append(pages, page)
+		mu.Unlock()
+
+		if page != "1" {
+			<-release
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Path:                dir,
+		MaxExpiry:           10,
+		Cleanup:             20,
+		Prefetch:            []PrefetchRule{{PathPrefix: "/items", Param: "page", Pages: 3}},
+		PrefetchConcurrency: 1,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/items?page=1", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	close(release)
+	c.bg.Wait()
+
+	if len(pages) != 2 || pages[1] != "2" {
+		t.Errorf("unexpected origin requests: %v", pages)
+	}
+}
+
+func TestInflight(t *testing.T) {
+	f := &inflight{keys: map[string]struct{}{}}
+
+	if !f.Acquire("key") {
+		t.Fatal("expected first acquire to succeed")
+	}
+
+	if f.Acquire("key") {
+		t.Error("expected second acquire to fail")
+	}
+
+	f.Release("key")
+
+	if !f.Acquire("key") {
+		t.Error("expected acquire after release to succeed")
+	}
+}
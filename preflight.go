@@ -0,0 +1,49 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// preflightKey returns the part of the key of a CORS preflight request
+// identifying the method and headers it asks for.
+func preflightKey(r *http.Request) string {
+	var headers []string
+	for _, val := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(val, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				headers = append(headers, name)
+			}
+		}
+	}
+
+	sort.Strings(headers)
+
+	return "acrm=" + url.QueryEscape(r.Header.Get("Access-Control-Request-Method")) +
+		"&acrh=" + url.QueryEscape(strings.Join(headers, ","))
+}
+
+// preflightExpiry returns the expiry of a preflight response, which is
+// given by Access-Control-Max-Age when present, capped at maxExpiry.
+func preflightExpiry(h http.Header, maxExpiry time.Duration) (time.Duration, bool) {
+	v := h.Get("Access-Control-Max-Age")
+	if v == "" {
+		return maxExpiry, true
+	}
+
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+
+	expiry := time.Duration(secs) * time.Second
+	if expiry > maxExpiry {
+		expiry = maxExpiry
+	}
+
+	return expiry, true
+}
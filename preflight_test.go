@@ -0,0 +1,137 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPreflightKey(t *testing.T) {
+	a := httptest.NewRequest(http.MethodOptions, "http://localhost/api", nil)
+	a.Header.Set("Access-Control-Request-Method", "PUT")
+	a.Header.Set("Access-Control-Request-Headers", "X-Token, content-type")
+
+	b := httptest.NewRequest(http.MethodOptions, "http://localhost/api", nil)
+	b.Header.Set("Access-Control-Request-Method", "PUT")
+	b.Header.Set("Access-Control-Request-Headers", "Content-Type,x-token")
+
+	c := httptest.NewRequest(http.MethodOptions, "http://localhost/api", nil)
+	c.Header.Set("Access-Control-Request-Method", "DELETE")
+	c.Header.Set("Access-Control-Request-Headers", "Content-Type,x-token")
+
+	if preflightKey(a) != preflightKey(b) {
+		t.Errorf("expected equivalent headers to share a key: %q, %q", preflightKey(a), preflightKey(b))
+	}
+
+	if preflightKey(a) == preflightKey(c) {
+		t.Errorf("expected different methods to have distinct keys: %q", preflightKey(a))
+	}
+}
+
+func TestPreflightExpiry(t *testing.T) {
+	tests := []struct {
+		maxAge     string
+		wantExpiry time.Duration
+		wantOk     bool
+	}{
+		{maxAge: "", wantExpiry: time.Minute, wantOk: true},
+		{maxAge: "30", wantExpiry: 30 * time.Second, wantOk: true},
+		{maxAge: "86400", wantExpiry: time.Minute, wantOk: true},
+		{maxAge: "0", wantOk: false},
+		{maxAge: "-1", wantOk: false},
+		{maxAge: "soon", wantOk: false},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		if test.maxAge != "" {
+			h.Set("Access-Control-Max-Age", test.maxAge)
+		}
+
+		expiry, ok := preflightExpiry(h, time.Minute)
+		if ok != test.wantOk || expiry != test.wantExpiry {
+			t.Errorf("unexpected expiry for %q: want %v %t, got %v %t", test.maxAge, test.wantExpiry, test.wantOk, expiry, ok)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_CacheMethods(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Access-Control-Allow-Methods", req.Header.Get("Access-Control-Request-Method"))
+		rw.WriteHeader(http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name      string
+		methods   []string
+		method    string
+		wantState string
+	}{
+		{name: "GET is cached by default", method: http.MethodGet, wantState: "hit"},
+		{name: "OPTIONS is not cached by default", method: http.MethodOptions, wantState: "miss"},
+		{name: "POST is not cached by default", method: http.MethodPost, wantState: "miss"},
+		{name: "OPTIONS is cached when enabled", methods: []string{"GET", "OPTIONS"}, method: http.MethodOptions, wantState: "hit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{Path: dir, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, CacheMethods: test.methods}
+
+			c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rw *httptest.ResponseRecorder
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(test.method, "http://localhost/"+t.Name(), nil)
+				req.Header.Set("Access-Control-Request-Method", "PUT")
+				rw = httptest.NewRecorder()
+
+				c.ServeHTTP(rw, req)
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_VaryOrigin(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Cache-Control", "max-age=20")
+		rw.Header().Set("Access-Control-Allow-Origin", req.Header.Get("Origin"))
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryOrigin: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, origin := range []string{"https://a.example", "https://b.example", "https://a.example", "https://b.example"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("Origin", origin)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if got := rw.Header().Get("Access-Control-Allow-Origin"); got != origin {
+			t.Errorf("unexpected allowed origin for %s: got %q", origin, got)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("expected one fetch per origin, got %d", calls)
+	}
+}
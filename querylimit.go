@@ -0,0 +1,91 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultMaxQueryParams is generous enough for any legitimate URL while
+// keeping junk parameters from multiplying keys.
+const defaultMaxQueryParams = 256
+
+const (
+	// queryOverflowBypass doesn't cache requests with too many query
+	// parameters.
+	queryOverflowBypass = "bypass"
+	// queryOverflowDrop keys requests on their first query parameters only.
+	queryOverflowDrop = "drop"
+)
+
+func validateQueryLimit(cfg *Config) error {
+	if cfg.MaxQueryParams < 0 {
+		return errors.New("maxQueryParams must be greater or equal to 0")
+	}
+
+	switch cfg.QueryParamsOverflowPolicy {
+	case "", queryOverflowBypass, queryOverflowDrop:
+	default:
+		return fmt.Errorf("invalid queryParamsOverflowPolicy %q", cfg.QueryParamsOverflowPolicy)
+	}
+
+	return nil
+}
+
+// countQueryParams returns the number of parameters in rawQuery, without
+// splitting it.
+func countQueryParams(rawQuery string) int {
+	var n int
+
+	for len(rawQuery) > 0 {
+		i := strings.IndexByte(rawQuery, '&')
+		if i < 0 {
+			i = len(rawQuery)
+		}
+
+		if i > 0 {
+			n++
+		}
+
+		if i == len(rawQuery) {
+			break
+		}
+
+		rawQuery = rawQuery[i+1:]
+	}
+
+	return n
+}
+
+// tooManyQueryParams reports whether rawQuery has more parameters than
+// allowed.
+func (m *cache) tooManyQueryParams(rawQuery string) bool {
+	return m.cfg.MaxQueryParams > 0 && countQueryParams(rawQuery) > m.cfg.MaxQueryParams
+}
+
+// limitQuery returns rawQuery cut after its first parameters with the drop
+// policy, so that junk parameters appended to it don't select another key.
+func (m *cache) limitQuery(rawQuery string) string {
+	if m.cfg.QueryParamsOverflowPolicy != queryOverflowDrop || !m.tooManyQueryParams(rawQuery) {
+		return rawQuery
+	}
+
+	var n int
+
+	for i := 0; i < len(rawQuery); i++ {
+		if rawQuery[i] != '&' {
+			continue
+		}
+
+		// Skip empty parameters, as countQueryParams does.
+		if i > 0 && rawQuery[i-1] != '&' {
+			n++
+		}
+
+		if n == m.cfg.MaxQueryParams {
+			return rawQuery[:i]
+		}
+	}
+
+	return rawQuery
+}
@@ -0,0 +1,114 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCountQueryParams(t *testing.T) {
+	tests := map[string]int{
+		"":          0,
+		"a=1":       1,
+		"a=1&b":     2,
+		"&a=1&&b=&": 2,
+	}
+
+	for query, want := range tests {
+		if got := countQueryParams(query); got != want {
+			t.Errorf("%q: want %d, got %d", query, want, got)
+		}
+	}
+}
+
+func TestLimitQuery(t *testing.T) {
+	m := &cache{cfg: &Config{MaxQueryParams: 2, QueryParamsOverflowPolicy: queryOverflowDrop}}
+
+	tests := map[string]string{
+		"a=1&b=2":         "a=1&b=2",
+		"a=1&b=2&c=3&d=4": "a=1&b=2",
+		"a=1&&b=2&c=3":    "a=1&&b=2",
+	}
+
+	for query, want := range tests {
+		if got := m.limitQuery(query); got != want {
+			t.Errorf("%q: want %q, got %q", query, want, got)
+		}
+	}
+}
+
+func junkQuery(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = "junk" + strconv.Itoa(i) + "=x"
+	}
+
+	return strings.Join(params, "&")
+}
+
+func TestCache_ServeHTTP_MaxQueryParams(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantStates  []string
+		wantEntries int
+	}{
+		{policy: queryOverflowBypass, wantStates: []string{"miss", "miss", "miss"}, wantEntries: 0},
+		{policy: queryOverflowDrop, wantStates: []string{"miss", "hit", "hit"}, wantEntries: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			var calls int
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				Backend:                   backendMemory,
+				MaxExpiry:                 10,
+				Cleanup:                   20,
+				AddStatusHeader:           true,
+				MaxQueryParams:            defaultMaxQueryParams,
+				QueryParamsOverflowPolicy: test.policy,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			// Junk appended past the limit differs on every request.
+			junk := junkQuery(defaultMaxQueryParams)
+			for i, want := range test.wantStates {
+				target := "http://localhost/page?" + junk + "&bust=" + strconv.Itoa(i) + "&" + junkQuery(5000)
+
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, target, nil))
+
+				if state := rw.Header().Get("Cache-Status"); state != want {
+					t.Errorf("request %d: unexpected cache state: want %q, got %q", i, want, state)
+				}
+			}
+
+			if n := len(c.cache.(*memoryCache).values); n != test.wantEntries {
+				t.Errorf("unexpected number of entries: want %d, got %d", test.wantEntries, n)
+			}
+
+			// Requests within the limit are cached as usual.
+			for _, want := range []string{"miss", "hit"} {
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page?a=1", nil))
+
+				if state := rw.Header().Get("Cache-Status"); state != want {
+					t.Errorf("unexpected cache state: want %q, got %q", want, state)
+				}
+			}
+		})
+	}
+}
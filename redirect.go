@@ -0,0 +1,34 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+func validateRedirectTTL(cfg *Config) error {
+	if cfg.RedirectTTL < 0 || cfg.RedirectTTL > cfg.MaxExpiry {
+		return errors.New("redirectTTL must be between 0 and maxExpiry")
+	}
+
+	return nil
+}
+
+// permanentRedirect reports whether responses with status are permanent
+// redirects to cache for the redirect TTL.
+func (m *cache) permanentRedirect(status int) bool {
+	if !m.cfg.CachePermanentRedirects {
+		return false
+	}
+
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+// redirectTTL returns the TTL of permanent redirects, maxExpiry by default.
+func (m *cache) redirectTTL() time.Duration {
+	if m.cfg.RedirectTTL > 0 {
+		return time.Duration(m.cfg.RedirectTTL) * time.Second
+	}
+
+	return time.Duration(m.cfg.MaxExpiry) * time.Second
+}
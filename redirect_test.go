@@ -0,0 +1,67 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_CachePermanentRedirects(t *testing.T) {
+	tests := []struct {
+		status     int
+		wantStored bool
+		wantExpiry time.Duration
+	}{
+		{status: http.StatusMovedPermanently, wantStored: true, wantExpiry: 3600 * time.Second},
+		{status: http.StatusPermanentRedirect, wantStored: true, wantExpiry: 3600 * time.Second},
+		{status: http.StatusFound},
+		{status: http.StatusSeeOther},
+		{status: http.StatusTemporaryRedirect},
+		{status: http.StatusOK, wantStored: true, wantExpiry: 60 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Location", "/elsewhere")
+				rw.WriteHeader(test.status)
+			}
+
+			cfg := &Config{
+				Backend:                 backendMemory,
+				MaxExpiry:               86400,
+				Cleanup:                 20,
+				CacheStatuses:           []int{http.StatusOK},
+				HeuristicMaxTTL:         60,
+				CachePermanentRedirects: true,
+				RedirectTTL:             3600,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/old", nil)
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			stat, ok := c.cache.Stat(c.cacheKey(req))
+			if ok != test.wantStored {
+				t.Fatalf("unexpected stored state: want %t, got %t", test.wantStored, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if until := time.Until(stat.Expires); until > test.wantExpiry || until < test.wantExpiry-5*time.Second {
+				t.Errorf("unexpected expiry: want %v, got %v", test.wantExpiry, until)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+func validateRefreshAhead(cfg *Config) error {
+	if cfg.RefreshAheadWindow < 0 {
+		return errors.New("refreshAheadWindow must be greater or equal to 0")
+	}
+
+	// Entries are refreshed like prefetched pages.
+	if cfg.RefreshAheadWindow > 0 && cfg.PrefetchConcurrency < 1 {
+		return errors.New("prefetchConcurrency must be greater or equal to 1")
+	}
+
+	return nil
+}
+
+// refreshAhead refetches the entry of a GET request hitting key in the
+// background when it is about to expire, so that hot URLs keep hitting fresh
+// entries. The cached response is still served meanwhile.
+func (m *cache) refreshAhead(r *http.Request, key string, data *cacheData) {
+	if m.cfg.RefreshAheadWindow <= 0 || r.Method != http.MethodGet {
+		return
+	}
+
+	// Entries kept to be served stale expire later than they go stale.
+	expires := data.Expires
+	if expires.IsZero() {
+		stat, ok := m.cache.Stat(key)
+		if !ok {
+			return
+		}
+		expires = stat.Expires
+	}
+
+	if time.Until(expires) > time.Duration(m.cfg.RefreshAheadWindow)*time.Second {
+		return
+	}
+
+	req, err := backgroundRequest(r, r.URL.RequestURI())
+	if err != nil {
+		return
+	}
+
+	m.background(req, key, nil)
+}
@@ -0,0 +1,200 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_RefreshAhead(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    int
+		wantCalls int32
+	}{
+		{name: "outside the window", window: 5, wantCalls: 1},
+		{name: "within the window", window: 10, wantCalls: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte(strconv.Itoa(int(n))))
+			}
+
+			cfg := &Config{
+				Backend:             backendMemory,
+				MaxExpiry:           10,
+				Cleanup:             20,
+				AddStatusHeader:     true,
+				PrefetchConcurrency: 1,
+				RefreshAheadWindow:  test.window,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+			c.bg.Wait()
+
+			// The still fresh entry is served right away.
+			if state := rw.Header().Get("Cache-Status"); state != "hit" || rw.Body.String() != "1" {
+				t.Errorf("unexpected response: %q %q", state, rw.Body.String())
+			}
+
+			if n := atomic.LoadInt32(&calls); n != test.wantCalls {
+				t.Fatalf("unexpected origin requests: want %d, got %d", test.wantCalls, n)
+			}
+
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+
+			if want := strconv.Itoa(int(test.wantCalls)); rw.Body.String() != want {
+				t.Errorf("unexpected body: want %q, got %q", want, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_RefreshAheadDedup(t *testing.T) {
+	release := make(chan struct{})
+
+	var calls int32
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Backend:             backendMemory,
+		MaxExpiry:           10,
+		Cleanup:             20,
+		AddStatusHeader:     true,
+		PrefetchConcurrency: 4,
+		RefreshAheadWindow:  10,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+
+	// Hits arriving while the entry is being refreshed don't refresh it again.
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/hot", nil))
+
+		if state := rw.Header().Get("Cache-Status"); state != "hit" {
+			t.Errorf("unexpected cache state: want \"hit\", got: %q", state)
+		}
+	}
+
+	close(release)
+	c.bg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("unexpected origin requests: want 2, got %d", n)
+	}
+}
+
+func TestCache_ServeHTTP_RefreshIdenticalResponse(t *testing.T) {
+	dir := createTempDir(t)
+
+	var calls int32
+
+	date := time.Now().UTC()
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+
+		// Only the Date and Age change from one fetch to the next.
+		rw.Header().Set("Date", date.Add(time.Duration(n)*time.Second).Format(http.TimeFormat))
+		rw.Header().Set("Age", strconv.Itoa(200-100*int(n)))
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = rw.Write([]byte("report"))
+	}
+
+	cfg := &Config{
+		Path:                dir,
+		MaxExpiry:           300,
+		Cleanup:             600,
+		AddStatusHeader:     true,
+		PrefetchConcurrency: 1,
+		RefreshAheadWindow:  300,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/report", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	p := keyPath(dir, c.cacheKey(req))
+
+	before, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hit is within the refresh window, so the entry is refetched.
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/report", nil))
+	c.bg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("unexpected origin requests: want 2, got %d", n)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the expiry is rewritten in place, the entry file isn't replaced.
+	if !os.SameFile(before, info) {
+		t.Error("expected the refreshed entry not to be replaced")
+	}
+
+	// The entry is served with the times of the refreshed response.
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/report", nil))
+	c.bg.Wait()
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" || rw.Body.String() != "report" {
+		t.Errorf("unexpected response: %q %q", state, rw.Body.String())
+	}
+
+	if want := date.Add(2 * time.Second).Format(http.TimeFormat); rw.Header().Get("Date") != want {
+		t.Errorf("unexpected Date: want %q, got %q", want, rw.Header().Get("Date"))
+	}
+
+	if age := rw.Header().Get("Age"); age != "0" && age != "1" {
+		t.Errorf("unexpected Age: want 0, got %s", age)
+	}
+}
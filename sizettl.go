@@ -0,0 +1,43 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"time"
+)
+
+// SizeTTLRule gives responses whose body is at least MinBytes long a TTL of
+// TTL seconds.
+type SizeTTLRule struct {
+	MinBytes int `json:"minBytes" yaml:"minBytes" toml:"minBytes"`
+	TTL      int `json:"ttl" yaml:"ttl" toml:"ttl"`
+}
+
+func validateSizeTTLRules(rules []SizeTTLRule, maxExpiry int) error {
+	for i, rule := range rules {
+		if rule.MinBytes < 0 {
+			return fmt.Errorf("rule %d: minBytes must be greater or equal to 0", i)
+		}
+
+		if i > 0 && rule.MinBytes <= rules[i-1].MinBytes {
+			return fmt.Errorf("rule %d: minBytes must be greater than the previous rule's", i)
+		}
+
+		if rule.TTL < 1 || rule.TTL > maxExpiry {
+			return fmt.Errorf("rule %d: ttl must be between 1 and maxExpiry", i)
+		}
+	}
+
+	return nil
+}
+
+// sizeTTL returns the TTL of the last size rule the body size reaches, if
+// any.
+func (m *cache) sizeTTL(size int) (time.Duration, bool) {
+	for i := len(m.cfg.SizeTTLRules) - 1; i >= 0; i-- {
+		if rule := m.cfg.SizeTTLRules[i]; size >= rule.MinBytes {
+			return time.Duration(rule.TTL) * time.Second, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSizeTTLRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []SizeTTLRule
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "ascending", rules: []SizeTTLRule{{MinBytes: 0, TTL: 10}, {MinBytes: 1024, TTL: 300}}},
+		{name: "negative size", rules: []SizeTTLRule{{MinBytes: -1, TTL: 10}}, wantErr: true},
+		{name: "descending", rules: []SizeTTLRule{{MinBytes: 1024, TTL: 300}, {MinBytes: 0, TTL: 10}}, wantErr: true},
+		{name: "duplicate", rules: []SizeTTLRule{{MinBytes: 1024, TTL: 300}, {MinBytes: 1024, TTL: 10}}, wantErr: true},
+		{name: "zero ttl", rules: []SizeTTLRule{{MinBytes: 0}}, wantErr: true},
+		{name: "above maxExpiry", rules: []SizeTTLRule{{MinBytes: 0, TTL: 301}}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateSizeTTLRules(test.rules, 300); (err != nil) != test.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_SizeTTLRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		statusTTL  bool
+		wantExpiry time.Duration
+	}{
+		{name: "below every rule", size: 10, wantExpiry: 600 * time.Second},
+		{name: "small", size: 100, wantExpiry: 30 * time.Second},
+		{name: "large", size: 4096, wantExpiry: 300 * time.Second},
+		{name: "explicit status TTL wins", size: 4096, statusTTL: true, wantExpiry: 60 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte(strings.Repeat("x", test.size)))
+			}
+
+			cfg := &Config{
+				Backend:   backendMemory,
+				MaxExpiry: 600,
+				Cleanup:   20,
+				SizeTTLRules: []SizeTTLRule{
+					{MinBytes: 100, TTL: 30},
+					{MinBytes: 1024, TTL: 300},
+				},
+			}
+			if test.statusTTL {
+				cfg.StatusTTLs = map[string]int{strconv.Itoa(http.StatusOK): 60}
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/report", nil)
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			stat, ok := c.cache.Stat(c.cacheKey(req))
+			if !ok {
+				t.Fatal("expected the response to be stored")
+			}
+
+			if until := time.Until(stat.Expires); until > test.wantExpiry || until < test.wantExpiry-5*time.Second {
+				t.Errorf("unexpected expiry: want %v, got %v", test.wantExpiry, until)
+			}
+		})
+	}
+}
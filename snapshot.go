@@ -0,0 +1,216 @@
+package plugin_simplecache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// A snapshot is a sequence of frames, each made of the length of an entry as
+// a little-endian uint32 followed by the entry, encoded as in entry files.
+const maxFrameLen = 256 << 20
+
+// frameOverhead is the largest size of an entry besides its value.
+const frameOverhead = 12 + maxMetaLen
+
+// writeFrame writes an entry made of parts as a snapshot frame.
+func writeFrame(w io.Writer, parts ...[]byte) error {
+	var n int
+	for _, part := range parts {
+		n += len(part)
+	}
+
+	if n > maxFrameLen {
+		return errors.New("entry too large")
+	}
+
+	l := make([]byte, 4)
+	binary.LittleEndian.PutUint32(l, uint32(n))
+
+	for _, part := range append([][]byte{l}, parts...) {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var errFrameTooLarge = errors.New("snapshot entry too large")
+
+// readFrame reads the next snapshot frame, returning io.EOF once there are no
+// more frames, and errFrameTooLarge for frames whose value is larger than
+// maxEntry bytes.
+func readFrame(r io.Reader, maxEntry int) (time.Time, entryMeta, []byte, error) {
+	l := make([]byte, 4)
+	if _, err := io.ReadFull(r, l); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return time.Time{}, entryMeta{}, nil, errors.New("truncated frame length")
+		}
+		return time.Time{}, entryMeta{}, nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(l)
+	if n > maxFrameLen {
+		return time.Time{}, entryMeta{}, nil, errors.New("invalid frame length")
+	}
+
+	// A frame too large to be stored is skipped without being kept.
+	if int64(n) > int64(maxEntry)+frameOverhead {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			return time.Time{}, entryMeta{}, nil, errors.New("truncated frame")
+		}
+		return time.Time{}, entryMeta{}, nil, errFrameTooLarge
+	}
+
+	// The buffer grows with the data actually read, rather than with the
+	// length claimed.
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		return time.Time{}, entryMeta{}, nil, errors.New("truncated frame")
+	}
+
+	expires, meta, val, err := decodeEntry(b.Bytes())
+	if err == nil && len(val) > maxEntry {
+		err = errFrameTooLarge
+	}
+
+	return expires, meta, val, err
+}
+
+// importSnapshot reads the frames of a snapshot and puts the entries that are
+// neither expired nor older than the ones indexed, returning their number.
+func importSnapshot(r io.Reader, maxEntry int, idx *index, put func(val []byte, expires time.Time, meta entryMeta) error) (int, error) {
+	br := bufio.NewReader(r)
+
+	var n int
+
+	for {
+		expires, meta, val, err := readFrame(br, maxEntry)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+
+		if errors.Is(err, errFrameTooLarge) {
+			continue
+		}
+
+		if err != nil {
+			return n, fmt.Errorf("invalid snapshot: %w", err)
+		}
+
+		if meta.Key == "" || meta.Version != entryVersion || !expires.After(time.Now()) {
+			continue
+		}
+
+		if e, ok := idx.Get(meta.Key); ok && e.meta.Stored >= meta.Stored {
+			continue
+		}
+
+		if err = put(val, monotonic(expires), meta); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+}
+
+// Export writes a snapshot of the entries that aren't expired, returning
+// their number.
+func (c *fileCache) Export(w io.Writer) (int, error) {
+	var n int
+
+	for _, meta := range c.index.Metas() {
+		b, ok := c.readEntry(meta.Key)
+		if !ok {
+			continue
+		}
+
+		if err := writeFrame(w, b); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// readEntry reads the entry file of key, reporting false if it is missing,
+// expired or written for a colliding key.
+func (c *fileCache) readEntry(key string) ([]byte, bool) {
+	mu := c.pm.MutexAt(key)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	b, err := ioutil.ReadFile(filepath.Clean(keyPath(c.path, key)))
+	if err != nil {
+		return nil, false
+	}
+
+	expires, meta, _, err := decodeEntry(b)
+	if err != nil || meta.Key != key || !expires.After(time.Now()) {
+		return nil, false
+	}
+
+	return b, true
+}
+
+// Import stores the entries of a snapshot, skipping the expired ones, the
+// ones older than those already stored and the ones larger than maxEntry.
+func (c *fileCache) Import(r io.Reader, maxEntry int) (int, error) {
+	// Imports are written like any other entry, see Set.
+	return importSnapshot(r, maxEntry, c.index, func(val []byte, expires time.Time, meta entryMeta) error {
+		if !c.breaker.Allow() {
+			return errWritesSuspended
+		}
+		return c.write(val, expires, meta)
+	})
+}
+
+// Export writes a snapshot of the entries that aren't expired, returning
+// their number.
+func (c *memoryCache) Export(w io.Writer) (int, error) {
+	var n int
+
+	for _, meta := range c.index.Metas() {
+		c.mu.Lock()
+		val, ok := c.values[meta.Key]
+		e, _ := c.index.Get(meta.Key)
+		c.mu.Unlock()
+
+		if !ok || !e.expires.After(time.Now()) {
+			continue
+		}
+
+		h, err := entryHeader(e.expires, e.meta)
+		if err != nil {
+			return n, err
+		}
+
+		if err = writeFrame(w, h, val); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// Import stores the entries of a snapshot, skipping the expired ones, the
+// ones older than those already stored and the ones larger than maxEntry.
+func (c *memoryCache) Import(r io.Reader, maxEntry int) (int, error) {
+	// Entries over the budget are skipped, see readFrame.
+	if c.maxBytes > 0 && c.maxBytes < maxEntry {
+		maxEntry = c.maxBytes
+	}
+
+	return importSnapshot(r, maxEntry, c.index, c.put)
+}
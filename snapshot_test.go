@@ -0,0 +1,196 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	fc, err := newFileCache(createTempDir(t), time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "shared"} {
+		if err = fc.Set(key, []byte("value of "+key), time.Minute, entryMeta{Host: "localhost"}); err != nil {
+			t.Fatalf("unexpected cache set error: %v", err)
+		}
+	}
+
+	if err = fc.Set("expired", []byte("stale"), -time.Second, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+
+	n, err := fc.Export(&snapshot)
+	if err != nil || n != 3 {
+		t.Fatalf("unexpected export result: %d, %v", n, err)
+	}
+
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	// Stored after the exported entry, so newer.
+	if err = mc.Set("shared", []byte("newer"), time.Minute, entryMeta{}); err != nil {
+		t.Fatalf("unexpected cache set error: %v", err)
+	}
+
+	n, err = mc.Import(&snapshot, maxFrameLen)
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected import result: %d, %v", n, err)
+	}
+
+	for key, want := range map[string]string{"a": "value of a", "b": "value of b", "shared": "newer"} {
+		got, err := mc.Get(key)
+		if err != nil || string(got) != want {
+			t.Errorf("unexpected value for %q: want %q, got %q, %v", key, want, got, err)
+		}
+	}
+
+	if stat, ok := mc.Stat("a"); !ok || stat.Host != "localhost" {
+		t.Errorf("expected the metadata to be imported, got %+v", stat)
+	}
+
+	// Back to a file cache.
+	snapshot.Reset()
+
+	if _, err = mc.Export(&snapshot); err != nil {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+
+	restored, err := newFileCache(createTempDir(t), time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatalf("unexpected newFileCache error: %v", err)
+	}
+
+	if n, err = restored.Import(&snapshot, maxFrameLen); err != nil || n != 3 {
+		t.Fatalf("unexpected import result: %d, %v", n, err)
+	}
+
+	if got, err := restored.Get("shared"); err != nil || string(got) != "newer" {
+		t.Errorf("unexpected restored value: %q, %v", got, err)
+	}
+}
+
+func TestSnapshot_ImportSkipsExpired(t *testing.T) {
+	var snapshot bytes.Buffer
+
+	for key, expires := range map[string]time.Time{"expired": time.Now().Add(-time.Minute), "fresh": time.Now().Add(time.Minute)} {
+		h, err := entryHeader(expires, entryMeta{Key: key, Stored: time.Now().Unix(), Version: entryVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = writeFrame(&snapshot, h, []byte("some content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	n, err := mc.Import(&snapshot, maxFrameLen)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected import result: %d, %v", n, err)
+	}
+
+	if _, err = mc.Get("expired"); err != errCacheMiss {
+		t.Errorf("expected the expired entry to be skipped, got: %v", err)
+	}
+}
+
+func TestSnapshot_ImportTruncated(t *testing.T) {
+	h, err := entryHeader(time.Now().Add(time.Minute), entryMeta{Key: "a", Version: entryVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err = writeFrame(&snapshot, h, []byte("some content")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := snapshot.Bytes()
+
+	if _, err = newMemoryCache(time.Minute, 0, 0).Import(bytes.NewReader(b[:len(b)-3]), maxFrameLen); err == nil {
+		t.Error("expected a truncated snapshot to fail")
+	}
+}
+
+func TestSnapshot_ImportSkipsLarge(t *testing.T) {
+	var snapshot bytes.Buffer
+
+	for key, size := range map[string]int{"large": 100, "small": 10} {
+		h, err := entryHeader(time.Now().Add(time.Minute), entryMeta{Key: key, Stored: time.Now().Unix(), Version: entryVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = writeFrame(&snapshot, h, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mc := newMemoryCache(time.Minute, 0, 0)
+
+	n, err := mc.Import(&snapshot, 50)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected import result: %d, %v", n, err)
+	}
+
+	if _, err = mc.Get("large"); err != errCacheMiss {
+		t.Errorf("expected the large entry to be skipped, got: %v", err)
+	}
+}
+
+func TestSnapshot_ImportClaimedLength(t *testing.T) {
+	// A frame claiming more than it holds fails once the data runs out.
+	b := []byte{0, 0, 0, 8, 'x'}
+
+	if _, err := newMemoryCache(time.Minute, 0, 0).Import(bytes.NewReader(b), maxFrameLen); err == nil {
+		t.Error("expected a frame shorter than its length to fail")
+	}
+}
+
+func TestSnapshot_ImportFileGuards(t *testing.T) {
+	h, err := entryHeader(time.Now().Add(time.Minute), entryMeta{Key: "a", Stored: time.Now().Unix(), Version: entryVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err = writeFrame(&snapshot, h, make([]byte, 1536)); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := createTempDir(t)
+
+	fc, err := newFileCache(dir, time.Minute, 0, 0600, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Imports are refused on a disk low on space, like any other write.
+	fc.guard = &diskGuard{minFree: 1024, free: func(string) (uint64, error) { return 2048, nil }}
+
+	if _, err = fc.Import(bytes.NewReader(snapshot.Bytes()), maxFrameLen); !errors.Is(err, errLowDiskSpace) {
+		t.Errorf("expected a low disk space error, got %v", err)
+	}
+
+	if _, err = fc.Get("a"); err == nil {
+		t.Error("expected the entry not to be imported")
+	}
+
+	// And while the write breaker is open.
+	fc.guard = &diskGuard{}
+	for i := 0; i < fc.breaker.threshold; i++ {
+		fc.breaker.Record(&os.PathError{Op: "write", Path: dir, Err: syscall.ENOSPC})
+	}
+
+	if _, err = fc.Import(bytes.NewReader(snapshot.Bytes()), maxFrameLen); !errors.Is(err, errWritesSuspended) {
+		t.Errorf("expected writes to be suspended, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	cacheStaleStatus = "stale"
+	// staleWarning is the Warning header of responses served stale, as
+	// defined by RFC 7234 section 5.5.1.
+	staleWarning = `110 - "Response is Stale"`
+)
+
+// errStale is returned with an entry that is no longer fresh, but may still
+// be served if the origin fails.
+var errStale = fmt.Errorf("stale entry: %w", errCacheMiss)
+
+// staleIfError returns how long entries may be served stale past their
+// expiry when the origin fails.
+func (m *cache) staleIfError() time.Duration {
+	return time.Duration(m.cfg.StaleIfError) * time.Second
+}
+
+// failsStale reports whether a response with the given status should be
+// replaced by the stale entry, if any: any 5xx status, unless the statuses to
+// serve stale on are configured.
+func (m *cache) failsStale(stale *cacheData, status int) bool {
+	if stale == nil {
+		return false
+	}
+
+	if len(m.cfg.StaleOnStatuses) == 0 {
+		return status >= 500
+	}
+
+	for _, s := range m.cfg.StaleOnStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveStale serves the stale entry in place of the failed response held by
+// rw, marking it as stale so that downstream can tell the origin is failing.
+func (m *cache) serveStale(rw *responseWriter, w http.ResponseWriter, r *http.Request, stale *cacheData) {
+	rw.release()
+
+	// Drop the headers of the failed response.
+	for k := range w.Header() {
+		delete(w.Header(), k)
+	}
+
+	m.serveStaleEntry(w, r, stale)
+}
+
+// serveStaleEntry serves the stale entry, marking it as stale.
+func (m *cache) serveStaleEntry(w http.ResponseWriter, r *http.Request, stale *cacheData) {
+	status := stale.Status
+	if m.cfg.StaleStatus != 0 {
+		status = m.cfg.StaleStatus
+	}
+
+	data := *stale
+	data.Status = status
+	data.Headers = make(map[string][]string, len(stale.Headers)+1)
+	for k, vals := range stale.Headers {
+		data.Headers[k] = vals
+	}
+	data.Headers["Warning"] = []string{staleWarning}
+
+	m.serveEntry(w, r, &data, cacheStaleStatus)
+}
@@ -0,0 +1,111 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_StaleIfError(t *testing.T) {
+	tests := []struct {
+		name        string
+		originCode  int
+		staleStatus int
+		staleOn     []int
+		maxServeAge int
+		wantCode    int
+		wantBody    string
+		wantState   string
+		wantWarning string
+	}{
+		{name: "origin fails", originCode: http.StatusBadGateway, wantCode: http.StatusOK, wantBody: "stale content", wantState: "stale", wantWarning: staleWarning},
+		{name: "stale status", originCode: http.StatusServiceUnavailable, staleStatus: http.StatusNonAuthoritativeInfo, wantCode: http.StatusNonAuthoritativeInfo, wantBody: "stale content", wantState: "stale", wantWarning: staleWarning},
+		{name: "origin recovers", originCode: http.StatusOK, wantCode: http.StatusOK, wantBody: "fresh content", wantState: "miss"},
+		{name: "client error", originCode: http.StatusNotFound, wantCode: http.StatusNotFound, wantBody: "fresh content", wantState: "miss"},
+		{name: "listed 429", originCode: http.StatusTooManyRequests, staleOn: []int{429, 503}, wantCode: http.StatusOK, wantBody: "stale content", wantState: "stale", wantWarning: staleWarning},
+		{name: "listed 503", originCode: http.StatusServiceUnavailable, staleOn: []int{429, 503}, wantCode: http.StatusOK, wantBody: "stale content", wantState: "stale", wantWarning: staleWarning},
+		{name: "unlisted 500", originCode: http.StatusInternalServerError, staleOn: []int{429, 503}, wantCode: http.StatusInternalServerError, wantBody: "fresh content", wantState: "miss"},
+		{name: "older than maxServeAge", originCode: http.StatusBadGateway, maxServeAge: 15, wantCode: http.StatusBadGateway, wantBody: "fresh content", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(test.originCode)
+				_, _ = rw.Write([]byte("fresh content"))
+			}
+
+			cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, StaleIfError: 60, StaleStatus: test.staleStatus, StaleOnStatuses: test.staleOn, MaxServeAge: test.maxServeAge}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+
+			c.store(c.cacheKey(req), req, &cacheData{
+				Status:       http.StatusOK,
+				Headers:      map[string][]string{"Content-Type": {"text/plain"}},
+				Body:         []byte("stale content"),
+				ResponseTime: time.Now().Add(-20 * time.Second),
+				Expires:      time.Now().Add(-10 * time.Second),
+			}, time.Minute)
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Errorf("unexpected status: want %d, got %d", test.wantCode, rw.Code)
+			}
+
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected body: want %q, got %q", test.wantBody, body)
+			}
+
+			if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", test.wantState, state)
+			}
+
+			if warning := rw.Header().Get("Warning"); warning != test.wantWarning {
+				t.Errorf("unexpected warning: want %q, got %q", test.wantWarning, warning)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_StaleIfErrorStoresWindow(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, StaleIfError: 60}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	stat, ok := c.cache.Stat(c.cacheKey(req))
+	if !ok {
+		t.Fatal("expected the response to be stored")
+	}
+
+	if until := time.Until(stat.Expires); until < time.Minute {
+		t.Errorf("expected the entry to be kept for the stale window, expires in %v", until)
+	}
+
+	data, err := c.lookup(c.cacheKey(req), req)
+	if err != nil || time.Until(data.Expires) > 10*time.Second {
+		t.Errorf("expected a fresh entry expiring with maxExpiry, got %v, %v", data, err)
+	}
+}
@@ -0,0 +1,278 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// statusFormatSimple sets Cache-Status to the bare cache status, e.g.
+	// hit, replacing any upstream value.
+	statusFormatSimple = "simple"
+	// statusFormatRFC9211 appends a structured member to Cache-Status as
+	// described in RFC 9211, after the members of the upstream caches.
+	statusFormatRFC9211 = "rfc9211"
+)
+
+// defaultStatusHeaderName identifies the cache in RFC 9211 members.
+const defaultStatusHeaderName = "simplecache"
+
+func validateStatusHeader(cfg *Config) error {
+	switch cfg.StatusHeaderFormat {
+	case "", statusFormatSimple, statusFormatRFC9211:
+	default:
+		return fmt.Errorf("invalid statusHeaderFormat %q", cfg.StatusHeaderFormat)
+	}
+
+	for _, c := range cfg.StatusHeaderName {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return fmt.Errorf("invalid statusHeaderName %q", cfg.StatusHeaderName)
+		}
+	}
+
+	return nil
+}
+
+// statusMember describes how the cache handled a request, as reported by
+// the parameters of its RFC 9211 member.
+type statusMember struct {
+	hit bool
+	// fwd is the reason the request was forwarded to the origin, if it was.
+	fwd string
+	// fwdStatus is the status of the origin response, if any.
+	fwdStatus int
+	// entry is the served entry, if any, whose freshness left is reported.
+	entry  *cacheData
+	stored bool
+	// key is the key of the entry, reported to debugging requests only.
+	key string
+	// detail is whatever else is worth reporting, e.g. error.
+	detail string
+}
+
+// setStatusHeader sets the Cache-Status of the response to r, the bare cache
+// status cs or member in the RFC 9211 format.
+func (m *cache) setStatusHeader(w http.ResponseWriter, r *http.Request, cs string, member statusMember) {
+	if m.cfg.StatusHeaderFormat != statusFormatRFC9211 {
+		w.Header().Set(cacheHeader, cs)
+		return
+	}
+
+	name := m.statusHeaderName()
+
+	// Keep the members of the upstream caches, but not the one of this
+	// cache replayed with a stored response.
+	var members []string
+	for _, val := range w.Header().Values(cacheHeader) {
+		for _, member := range splitMembers(val) {
+			if memberName(member) != name {
+				members = append(members, member)
+			}
+		}
+	}
+
+	if !m.cfg.DebugHeader || r.Header.Get(debugHeader) != "1" {
+		member.key = ""
+	}
+
+	var (
+		ttl    time.Duration
+		hasTTL bool
+	)
+
+	if member.entry != nil {
+		ttl, hasTTL = m.entryTTL(member.entry)
+	}
+
+	members = append(members, formatMember(name, member, ttl, hasTTL))
+
+	w.Header().Set(cacheHeader, strings.Join(members, ", "))
+}
+
+func (m *cache) statusHeaderName() string {
+	if m.cfg.StatusHeaderName != "" {
+		return m.cfg.StatusHeaderName
+	}
+
+	return defaultStatusHeaderName
+}
+
+// fwdReason returns the RFC 9211 reason a request with the given key was
+// forwarded to the origin, stale being its stale entry, if any.
+func fwdReason(key string, stale *cacheData) string {
+	switch {
+	case key == "":
+		return "bypass"
+	case stale != nil:
+		return "stale"
+	default:
+		return "miss"
+	}
+}
+
+// entryTTL returns the freshness left to data, reporting false if unknown.
+func (m *cache) entryTTL(data *cacheData) (time.Duration, bool) {
+	// Entries kept to be served stale are stored past their freshness.
+	expires := data.Expires
+	if expires.IsZero() {
+		st, ok := m.cache.Stat(data.key)
+		if !ok {
+			return 0, false
+		}
+
+		expires = st.Expires
+	}
+
+	return time.Until(expires), true
+}
+
+// formatMember returns the RFC 9211 member of the named cache, ttl being the
+// freshness left to the served entry, if hasTTL, negative once stale.
+func formatMember(name string, member statusMember, ttl time.Duration, hasTTL bool) string {
+	parts := []string{sfItem(name)}
+
+	if member.hit {
+		parts = append(parts, "hit")
+	}
+
+	if member.fwd != "" {
+		parts = append(parts, "fwd="+member.fwd)
+	}
+
+	if member.fwdStatus != 0 {
+		parts = append(parts, "fwd-status="+strconv.Itoa(member.fwdStatus))
+	}
+
+	if hasTTL {
+		// Rounded down, so that an entry isn't reported fresh past its
+		// expiry.
+		secs := int64(ttl / time.Second)
+		if ttl < 0 && ttl%time.Second != 0 {
+			secs--
+		}
+
+		parts = append(parts, "ttl="+strconv.FormatInt(secs, 10))
+	}
+
+	if member.stored {
+		parts = append(parts, "stored")
+	}
+
+	if member.key != "" {
+		parts = append(parts, "key="+sfString(member.key))
+	}
+
+	if member.detail != "" {
+		parts = append(parts, "detail="+sfItem(member.detail))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// sfItem returns s as a structured field token if it is a valid one, else as
+// a string.
+func sfItem(s string) string {
+	if s == "" || !isTokenStart(s[0]) {
+		return sfString(s)
+	}
+
+	for i := 1; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return sfString(s)
+		}
+	}
+
+	return s
+}
+
+func isTokenStart(c byte) bool {
+	return c == '*' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
+
+// sfString returns s as a structured field string, percent-encoding the
+// bytes strings can't hold.
+func sfString(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// splitMembers splits a Cache-Status value into its members, leaving the
+// commas of quoted strings alone.
+func splitMembers(val string) []string {
+	var (
+		members []string
+		quoted  bool
+		start   int
+	)
+
+	for i := 0; i < len(val); i++ {
+		switch val[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				members = appendMember(members, val[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return appendMember(members, val[start:])
+}
+
+func appendMember(members []string, member string) []string {
+	if member = strings.TrimSpace(member); member != "" {
+		members = append(members, member)
+	}
+
+	return members
+}
+
+// memberName returns the cache identifier of a Cache-Status member, unquoted.
+func memberName(member string) string {
+	name := member
+	if member != "" && member[0] == '"' {
+		// Names of this cache hold no escapes, see validateStatusHeader.
+		if i := strings.IndexByte(member[1:], '"'); i >= 0 {
+			return member[1 : i+1]
+		}
+	}
+
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,111 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMembers(t *testing.T) {
+	got := splitMembers(`edge; hit, "origin, cache"; fwd=miss; detail="a,b", ,cdn`)
+	want := []string{`edge; hit`, `"origin, cache"; fwd=miss; detail="a,b"`, `cdn`}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected members: want %q, got %q", want, got)
+	}
+
+	for member, want := range map[string]string{
+		`edge; hit`:            "edge",
+		`"origin, cache"; hit`: "origin, cache",
+		`cdn`:                  "cdn",
+	} {
+		if got := memberName(member); got != want {
+			t.Errorf("%q: unexpected name: want %q, got %q", member, want, got)
+		}
+	}
+}
+
+func TestFormatMember(t *testing.T) {
+	tests := []struct {
+		name   string
+		member statusMember
+		ttl    time.Duration
+		hasTTL bool
+		want   string
+	}{
+		{name: "simplecache", member: statusMember{hit: true}, ttl: 120*time.Second + 500*time.Millisecond, hasTTL: true, want: "simplecache; hit; ttl=120"},
+		{name: "simplecache", member: statusMember{fwd: "stale"}, ttl: -1500 * time.Millisecond, hasTTL: true, want: "simplecache; fwd=stale; ttl=-2"},
+		{name: "simplecache", member: statusMember{fwd: "miss", fwdStatus: 200, stored: true}, want: "simplecache; fwd=miss; fwd-status=200; stored"},
+		{name: "cache@file", member: statusMember{fwd: "bypass", key: `GETlocalhost/a"b`}, want: `"cache@file"; fwd=bypass; key="GETlocalhost/a\"b"`},
+		{name: "simplecache", member: statusMember{detail: "origin-busy"}, want: "simplecache; detail=origin-busy"},
+	}
+
+	for _, test := range tests {
+		if got := formatMember(test.name, test.member, test.ttl, test.hasTTL); got != test.want {
+			t.Errorf("unexpected member: want %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_StatusHeaderRFC9211(t *testing.T) {
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		// As set by a cache between the plugin and the origin.
+		rw.Header().Set("Cache-Status", `origin-cache; fwd=uri-miss; stored`)
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{
+		Backend:            backendMemory,
+		MaxExpiry:          300,
+		Cleanup:            600,
+		AddStatusHeader:    true,
+		DebugHeader:        true,
+		StatusHeaderFormat: statusFormatRFC9211,
+	}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	want := "origin-cache; fwd=uri-miss; stored, simplecache; fwd=miss; fwd-status=200; stored"
+	if got := rw.Header().Get("Cache-Status"); got != want {
+		t.Errorf("unexpected miss status: want %q, got %q", want, got)
+	}
+
+	// The member of this cache stored with the response is replaced.
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	got := rw.Header().Get("Cache-Status")
+	if !strings.HasPrefix(got, "origin-cache; fwd=uri-miss; stored, simplecache; hit; ttl=29") || strings.Count(got, "simplecache") != 1 {
+		t.Errorf("unexpected hit status: got %q", got)
+	}
+
+	// The key is only reported to debugging requests.
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+	req.Header.Set(debugHeader, "1")
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+
+	if got := rw.Header().Get("Cache-Status"); !strings.HasSuffix(got, `; key="GETlocalhost/page"`) {
+		t.Errorf("expected the key to be reported, got %q", got)
+	}
+
+	// Requests bypassing the cache are reported as such.
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "http://localhost/page", nil))
+
+	want = "origin-cache; fwd=uri-miss; stored, simplecache; fwd=bypass; fwd-status=200"
+	if got := rw.Header().Get("Cache-Status"); got != want {
+		t.Errorf("unexpected bypass status: want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,51 @@
+package plugin_simplecache
+
+import (
+	"io"
+	"time"
+)
+
+const (
+	backendFile   = "file"
+	backendMemory = "memory"
+)
+
+// store is the storage layer of the cache.
+type store interface {
+	// Get returns the value stored under key, or errCacheMiss.
+	Get(key string) ([]byte, error)
+	// Set stores val under key for the given expiry.
+	Set(key string, val []byte, expiry time.Duration, meta entryMeta) error
+	// Delete removes the entry stored under key.
+	Delete(key string)
+	// Purge deletes up to limit entries whose metadata matches, returning
+	// the keys of the deleted entries.
+	Purge(match func(entryMeta) bool, limit int) []string
+	// PurgeKey deletes the entry stored under key, along with its variants
+	// unless key is a variant's, returning the keys of the deleted entries.
+	PurgeKey(key string) []string
+	// Stat returns the description of the entry stored under key.
+	Stat(key string) (entryStat, bool)
+	// Variants returns the description of the variants of the response
+	// stored under key.
+	Variants(key string) []entryStat
+	// Hit counts a hit on the entry stored under key, returning its hit
+	// count.
+	Hit(key string) uint64
+	// HostUsage returns the total size of the entries of each host.
+	HostUsage() map[string]int
+	// Trim removes the oldest entries of host until their total size
+	// doesn't exceed max, returning the number of removed entries.
+	Trim(host string, max int) int
+	// Cleanup removes the entries expired at now, returning their number.
+	Cleanup(now time.Time) int
+	// Export writes a snapshot of the stored entries, returning their
+	// number.
+	Export(w io.Writer) (int, error)
+	// Import stores the entries of a snapshot that are neither expired nor
+	// older than the stored ones, returning their number. Entries larger
+	// than maxEntry bytes are skipped.
+	Import(r io.Reader, maxEntry int) (int, error)
+	// OnEvict sets the function called when an entry is removed.
+	OnEvict(fn func(key string, size int))
+}
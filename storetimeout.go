@@ -0,0 +1,102 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// maxAbandonedStoreOps bounds the store operations given up on but still
+// running, e.g. stuck on a hung disk. Past it, operations fail fast instead
+// of piling up more goroutines.
+const maxAbandonedStoreOps = 32
+
+var (
+	errStoreTimeout = errors.New("cache store operation timed out")
+	errStoreBusy    = errors.New("too many cache store operations stuck")
+)
+
+// storeTimeout returns the time store operations are given before the cache
+// gives up on them, 0 meaning no limit.
+func (m *cache) storeTimeout() time.Duration {
+	return time.Duration(m.cfg.StoreTimeoutMs) * time.Millisecond
+}
+
+// storeOp runs a store operation, giving up after the store timeout or once
+// ctx is done. The operation itself can't be interrupted, so one given up on
+// keeps running, and counts as abandoned until it completes.
+func (m *cache) storeOp(ctx context.Context, op func() error) error {
+	timeout := m.storeTimeout()
+	if timeout <= 0 {
+		return op()
+	}
+
+	if atomic.LoadInt64(&m.metrics.storeAbandoned) >= maxAbandonedStoreOps {
+		atomic.AddUint64(&m.metrics.storeRejects, 1)
+		return errStoreBusy
+	}
+
+	// state is 0 while running, 1 once completed and 2 once abandoned.
+	var state int32
+
+	// Buffered so the operation can complete after being given up on.
+	done := make(chan error, 1)
+	go func() {
+		err := op()
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			atomic.AddInt64(&m.metrics.storeAbandoned, -1)
+		}
+		done <- err
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var err error
+
+	select {
+	case err = <-done:
+		return err
+	case <-t.C:
+		atomic.AddUint64(&m.metrics.storeTimeouts, 1)
+		err = errStoreTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if atomic.CompareAndSwapInt32(&state, 0, 2) {
+		atomic.AddInt64(&m.metrics.storeAbandoned, 1)
+	}
+
+	return err
+}
+
+// storeGet returns the value stored under key, as a miss if reading it takes
+// longer than the store timeout or ctx is done first, e.g. on a hung disk.
+func (m *cache) storeGet(ctx context.Context, key string) ([]byte, error) {
+	var b []byte
+
+	err := m.storeOp(ctx, func() error {
+		var err error
+		b, err = m.cache.Get(key)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		return b, nil
+	case errors.Is(err, errStoreTimeout), errors.Is(err, errStoreBusy), ctx.Err() != nil:
+		return nil, errCacheMiss
+	default:
+		return nil, err
+	}
+}
+
+// storeSet stores val under key, giving up after the store timeout or once
+// ctx is done. A write given up on may still complete later.
+func (m *cache) storeSet(ctx context.Context, key string, val []byte, expiry time.Duration, meta entryMeta) error {
+	return m.storeOp(ctx, func() error {
+		return m.cache.Set(key, val, expiry, meta)
+	})
+}
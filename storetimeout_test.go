@@ -0,0 +1,162 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore delays the reads of the store it wraps, like a degraded disk.
+type slowStore struct {
+	store
+	delay time.Duration
+}
+
+func (s *slowStore) Get(key string) ([]byte, error) {
+	time.Sleep(s.delay)
+	return s.store.Get(key)
+}
+
+func TestCache_ServeHTTP_StoreTimeout(t *testing.T) {
+	var calls int32
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("fresh"))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, StoreTimeoutMs: 20}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	c.cache = &slowStore{store: c.cache, delay: time.Second}
+
+	start := time.Now()
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil))
+
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected the hung read to be given up on, took %v", d)
+	}
+
+	if state := rw.Header().Get("Cache-Status"); state != "miss" {
+		t.Errorf("unexpected cache state: want %q, got %q", "miss", state)
+	}
+
+	if body := rw.Body.String(); body != "fresh" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("unexpected origin calls: want 2, got %d", n)
+	}
+
+	if n := atomic.LoadUint64(&c.metrics.storeTimeouts); n != 1 {
+		t.Errorf("unexpected store timeouts: want 1, got %d", n)
+	}
+}
+
+// stuckStore blocks the reads of the store it wraps until release is closed,
+// like a hung disk.
+type stuckStore struct {
+	store
+	release chan struct{}
+	running int32
+}
+
+func (s *stuckStore) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+
+	<-s.release
+	return s.store.Get(key)
+}
+
+func TestCache_StoreTimeoutAbandoned(t *testing.T) {
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, StoreTimeoutMs: 1}
+
+	h, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	stuck := &stuckStore{store: c.cache, release: make(chan struct{})}
+	c.cache = stuck
+
+	for i := 0; i < 2*maxAbandonedStoreOps; i++ {
+		if _, err = c.storeGet(context.Background(), "key"); err != errCacheMiss {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Only the first reads were left running, the others failed fast.
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&stuck.running) < maxAbandonedStoreOps && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&stuck.running); n != maxAbandonedStoreOps {
+		t.Errorf("unexpected stuck reads: want %d, got %d", maxAbandonedStoreOps, n)
+	}
+
+	if n := atomic.LoadUint64(&c.metrics.storeTimeouts); n != maxAbandonedStoreOps {
+		t.Errorf("unexpected store timeouts: want %d, got %d", maxAbandonedStoreOps, n)
+	}
+
+	if n := atomic.LoadUint64(&c.metrics.storeRejects); n != maxAbandonedStoreOps {
+		t.Errorf("unexpected store rejects: want %d, got %d", maxAbandonedStoreOps, n)
+	}
+
+	// Once the disk recovers, the store is used again.
+	close(stuck.release)
+
+	deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt64(&c.metrics.storeAbandoned) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = c.storeSet(context.Background(), "key", []byte("value"), time.Minute, entryMeta{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCache_StoreTimeoutCancelled(t *testing.T) {
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, StoreTimeoutMs: 1000}
+
+	h, err := New(context.Background(), http.NotFoundHandler(), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*cache)
+
+	stuck := &stuckStore{store: c.cache, release: make(chan struct{})}
+	defer close(stuck.release)
+	c.cache = stuck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = c.storeGet(ctx, "key"); err != errCacheMiss {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The client going away isn't a store timeout.
+	if n := atomic.LoadUint64(&c.metrics.storeTimeouts); n != 0 {
+		t.Errorf("unexpected store timeouts: want 0, got %d", n)
+	}
+}
@@ -0,0 +1,68 @@
+package plugin_simplecache
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// BodyReplacement replaces every occurrence of From with To in the bodies of
+// the responses that are stored.
+type BodyReplacement struct {
+	From string `json:"from" yaml:"from" toml:"from"`
+	To   string `json:"to" yaml:"to" toml:"to"`
+}
+
+// transformable reports whether the body of a response with the given
+// headers is to be transformed before being stored and served.
+func (m *cache) transformable(h http.Header) bool {
+	if len(m.cfg.BodyReplacements) == 0 {
+		return false
+	}
+
+	// Encoded bodies can't be transformed byte-wise.
+	if enc := h.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for _, t := range m.cfg.BodyReplacementTypes {
+		t = strings.ToLower(t)
+
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transform applies the body replacements in order.
+func (m *cache) transform(body []byte) []byte {
+	for _, rep := range m.cfg.BodyReplacements {
+		body = bytes.ReplaceAll(body, []byte(rep.From), []byte(rep.To))
+	}
+
+	return body
+}
+
+// storedHeaders returns the response headers to store and replay, without
+// the per-request ones the origin was configured to send, which only the
+// original client gets.
+func (m *cache) storedHeaders(h http.Header) http.Header {
+	if len(m.cfg.StripResponseHeaders) == 0 {
+		return h
+	}
+
+	stored := h.Clone()
+	for _, name := range m.cfg.StripResponseHeaders {
+		stored.Del(name)
+	}
+
+	return stored
+}
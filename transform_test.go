@@ -0,0 +1,153 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestCache_BodyReplacements(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		body := `<a href="https://origin.internal/page">link</a>`
+
+		rw.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte(body))
+	}
+
+	cfg := &Config{
+		Path:                 dir,
+		MaxExpiry:            10,
+		Cleanup:              20,
+		AddStatusHeader:      true,
+		BodyReplacements:     []BodyReplacement{{From: "https://origin.internal", To: ""}},
+		BodyReplacementTypes: []string{"text/html"},
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		contentType string
+		wantBody    string
+	}{
+		{contentType: "text/html; charset=utf-8", wantBody: `<a href="/page">link</a>`},
+		{contentType: "text/plain", wantBody: `<a href="https://origin.internal/page">link</a>`},
+	}
+
+	for _, test := range tests {
+		for _, wantState := range []string{"miss", "hit"} {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?type="+url.QueryEscape(test.contentType), nil)
+			rw := httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if state := rw.Header().Get("Cache-Status"); state != wantState {
+				t.Errorf("unexpected cache state: want %q, got %q", wantState, state)
+			}
+
+			if body := rw.Body.String(); body != test.wantBody {
+				t.Errorf("unexpected %s body for %s: want %q, got %q", wantState, test.contentType, test.wantBody, body)
+			}
+
+			if l := rw.Header().Get("Content-Length"); l != strconv.Itoa(len(test.wantBody)) {
+				t.Errorf("unexpected %s Content-Length for %s: got %s", wantState, test.contentType, l)
+			}
+		}
+	}
+}
+
+func TestCache_transformable(t *testing.T) {
+	m := &cache{cfg: &Config{
+		BodyReplacements:     []BodyReplacement{{From: "a", To: "b"}},
+		BodyReplacementTypes: []string{"text/html", "application/*"},
+	}}
+
+	tests := []struct {
+		contentType string
+		encoding    string
+		want        bool
+	}{
+		{contentType: "text/html", want: true},
+		{contentType: "TEXT/HTML; charset=utf-8", want: true},
+		{contentType: "application/json", want: true},
+		{contentType: "text/css", want: false},
+		{contentType: "text/html", encoding: "gzip", want: false},
+		{contentType: "", want: false},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		h.Set("Content-Type", test.contentType)
+
+		if test.encoding != "" {
+			h.Set("Content-Encoding", test.encoding)
+		}
+
+		if got := m.transformable(h); got != test.want {
+			t.Errorf("unexpected transformable for %q %q: want %t, got %t", test.contentType, test.encoding, test.want, got)
+		}
+	}
+}
+
+func TestCache_StripResponseHeaders(t *testing.T) {
+	var n int
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		n++
+		rw.Header().Set("X-Request-Id", strconv.Itoa(n))
+		rw.Header().Add("Set-Cookie", "_ga=GA1."+strconv.Itoa(n))
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("body"))
+	}
+
+	cfg := &Config{
+		Backend:              backendMemory,
+		MaxExpiry:            10,
+		Cleanup:              20,
+		AddStatusHeader:      true,
+		StripResponseHeaders: []string{"x-request-id", "Set-Cookie"},
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if v := rw.Header().Get("X-Request-Id"); v != "1" {
+		t.Errorf("expected the original client to get X-Request-Id, got %q", v)
+	}
+
+	if v := rw.Header().Get("Set-Cookie"); v != "_ga=GA1.1" {
+		t.Errorf("expected the original client to get Set-Cookie, got %q", v)
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/page", nil))
+
+	if state := rw.Header().Get("Cache-Status"); state != "hit" {
+		t.Fatalf("unexpected cache state: want %q, got %q", "hit", state)
+	}
+
+	for _, name := range []string{"X-Request-Id", "Set-Cookie"} {
+		if v := rw.Header().Get(name); v != "" {
+			t.Errorf("unexpected replayed %s: %q", name, v)
+		}
+	}
+
+	if v := rw.Header().Get("Content-Type"); v != "text/plain" {
+		t.Errorf("unexpected replayed Content-Type: %q", v)
+	}
+}
@@ -0,0 +1,202 @@
+package plugin_simplecache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// defaultOriginCacheHeader is the response header the origin uses to control
+// caching by this plugin separately from downstream caches.
+const defaultOriginCacheHeader = "X-Proxy-Cache-Control"
+
+func (m *cache) originCacheHeader() string {
+	if m.cfg.OriginCacheHeader == "" {
+		return defaultOriginCacheHeader
+	}
+
+	return m.cfg.OriginCacheHeader
+}
+
+// blessed reports whether the response carries the header required for it to
+// be cached, with the required value if any.
+func (m *cache) blessed(h http.Header) bool {
+	vals := h.Values(m.cfg.RequireHeader)
+	if m.cfg.RequireHeaderValue == "" {
+		return len(vals) > 0
+	}
+
+	for _, v := range vals {
+		if strings.EqualFold(strings.TrimSpace(v), m.cfg.RequireHeaderValue) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidHeader returns the name of a header whose name or values aren't
+// valid UTF-8, if any.
+func invalidHeader(h http.Header) (string, bool) {
+	for name, vals := range h {
+		if !utf8.ValidString(name) {
+			return strconv.Quote(name), true
+		}
+
+		for _, v := range vals {
+			if !utf8.ValidString(v) {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// retryAfter parses a Retry-After header value, either delta-seconds or an
+// HTTP-date, into the duration to wait from now.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0, false
+		}
+
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	d := t.Sub(now)
+	if d <= 0 {
+		return 0, false
+	}
+
+	return d, true
+}
+
+// expired reports whether the response has an Expires header in the past, as
+// measured against its Date header, if any. Invalid dates, such as the common
+// "0" and "-1", are in the past too (RFC 9111 5.3). Expires is ignored when
+// Cache-Control gives a max-age.
+func expired(h http.Header) bool {
+	if len(h.Values("Expires")) == 0 {
+		return false
+	}
+
+	cd, err := cacheobject.ParseResponseCacheControl(strings.Join(h.Values("Cache-Control"), ","))
+	if err == nil && (cd.MaxAge >= 0 || cd.SMaxAge >= 0) {
+		return false
+	}
+
+	t, err := http.ParseTime(strings.TrimSpace(h.Get("Expires")))
+	if err != nil {
+		return true
+	}
+
+	now := time.Now()
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+
+	return !t.After(now)
+}
+
+// proxyExpiry returns the expiry given by the Cache-Control directives the
+// origin addressed to this cache, capped at maxExpiry.
+func proxyExpiry(v string, maxExpiry time.Duration) (time.Duration, bool) {
+	cd, err := cacheobject.ParseResponseCacheControl(v)
+	if err != nil {
+		return 0, false
+	}
+
+	if cd.NoStore || cd.NoCachePresent || cd.PrivatePresent {
+		return 0, false
+	}
+
+	age := cd.MaxAge
+	if cd.SMaxAge >= 0 {
+		age = cd.SMaxAge
+	}
+
+	if age < 0 {
+		return maxExpiry, true
+	}
+
+	expiry := time.Duration(age) * time.Second
+	if expiry <= 0 {
+		return 0, false
+	}
+
+	if expiry > maxExpiry {
+		expiry = maxExpiry
+	}
+
+	return expiry, true
+}
+
+// understoodStatuses are the status codes whose caching semantics this
+// plugin understands, as required by the must-understand directive.
+var understoodStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusPartialContent:       true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// mustUnderstand reports whether the response carries the must-understand
+// Cache-Control directive (RFC 9111 5.2.2.3).
+func mustUnderstand(h http.Header) bool {
+	cd, err := cacheobject.ParseResponseCacheControl(strings.Join(h.Values("Cache-Control"), ","))
+	if err != nil {
+		return false
+	}
+
+	for _, ext := range cd.Extensions {
+		if strings.EqualFold(ext, "must-understand") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseStatusTTLs parses the configured per-status TTLs. Status codes are
+// given as strings as configuration map keys always are.
+func parseStatusTTLs(ttls map[string]int, maxExpiry int) (map[int]time.Duration, error) {
+	parsed := make(map[int]time.Duration, len(ttls))
+
+	for code, ttl := range ttls {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status code %q", code)
+		}
+
+		if ttl < 1 || ttl > maxExpiry {
+			return nil, fmt.Errorf("ttl of status %d must be between 1 and maxExpiry", status)
+		}
+
+		parsed[status] = time.Duration(ttl) * time.Second
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,519 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProxyExpiry(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantExpiry time.Duration
+		wantOk     bool
+	}{
+		{value: "max-age=60", wantExpiry: time.Minute, wantOk: true},
+		{value: "max-age=60, s-maxage=30", wantExpiry: 30 * time.Second, wantOk: true},
+		{value: "max-age=3600", wantExpiry: 5 * time.Minute, wantOk: true},
+		{value: "public", wantExpiry: 5 * time.Minute, wantOk: true},
+		{value: "max-age=0", wantOk: false},
+		{value: "no-store", wantOk: false},
+		{value: "private", wantOk: false},
+		{value: "max-age=abc", wantOk: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			expiry, ok := proxyExpiry(test.value, 5*time.Minute)
+
+			if ok != test.wantOk || expiry != test.wantExpiry {
+				t.Errorf("unexpected expiry: want %v %t, got %v %t", test.wantExpiry, test.wantOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestCache_Cacheable_MustUnderstand(t *testing.T) {
+	c := &cache{cfg: &Config{MaxExpiry: 300, CacheStatuses: []int{200, 201, 301, 302}}}
+
+	tests := []struct {
+		cacheControl string
+		status       int
+		want         bool
+	}{
+		{cacheControl: "", status: http.StatusFound, want: true},
+		{cacheControl: "must-understand, no-store", status: http.StatusOK, want: true},
+		{cacheControl: "must-understand, no-store", status: http.StatusMovedPermanently, want: true},
+		{cacheControl: "must-understand, no-store", status: http.StatusFound, want: false},
+		{cacheControl: "must-understand, no-store", status: http.StatusCreated, want: false},
+	}
+
+	for _, test := range tests {
+		rw := httptest.NewRecorder()
+		if test.cacheControl != "" {
+			rw.Header().Set("Cache-Control", test.cacheControl)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+		if _, ok := c.cacheable(req, rw, test.status); ok != test.want {
+			t.Errorf("unexpected cacheability of %d with %q: want %t, got %t", test.status, test.cacheControl, test.want, ok)
+		}
+	}
+}
+
+func TestParseStatusTTLs(t *testing.T) {
+	ttls, err := parseStatusTTLs(map[string]int{"301": 300, "404": 30}, 300)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttls[301] != 5*time.Minute || ttls[404] != 30*time.Second {
+		t.Errorf("unexpected ttls: %v", ttls)
+	}
+
+	for _, invalid := range []map[string]int{
+		{"ok": 30},
+		{"99": 30},
+		{"200": 0},
+		{"200": 301},
+	} {
+		if _, err = parseStatusTTLs(invalid, 300); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestCache_Cacheable_StatusTTLs(t *testing.T) {
+	c := &cache{
+		cfg:        &Config{MaxExpiry: 300, TrustOriginCacheHeader: true},
+		statusTTLs: map[int]time.Duration{http.StatusMovedPermanently: time.Minute, http.StatusNotFound: 10 * time.Second},
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		proxy      string
+		wantExpiry time.Duration
+		wantOk     bool
+	}{
+		{name: "default ttl", status: http.StatusOK, wantExpiry: 5 * time.Minute, wantOk: true},
+		{name: "status ttl", status: http.StatusMovedPermanently, wantExpiry: time.Minute, wantOk: true},
+		{name: "negative caching", status: http.StatusNotFound, wantExpiry: 10 * time.Second, wantOk: true},
+		{name: "error without ttl", status: http.StatusInternalServerError, wantOk: false},
+		{name: "overrides computed ttl", status: http.StatusMovedPermanently, proxy: "max-age=5", wantExpiry: time.Minute, wantOk: true},
+		{name: "keeps refusal", status: http.StatusMovedPermanently, proxy: "no-store", wantOk: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			if test.proxy != "" {
+				rw.Header().Set(defaultOriginCacheHeader, test.proxy)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+			expiry, ok := c.cacheable(req, rw, test.status)
+			if ok != test.wantOk || expiry != test.wantExpiry {
+				t.Errorf("unexpected expiry: want %v %t, got %v %t", test.wantExpiry, test.wantOk, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestCache_Cacheable_Expires(t *testing.T) {
+	c := &cache{cfg: &Config{MaxExpiry: 300, TrustOriginCacheHeader: true}}
+
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		expires      string
+		date         string
+		cacheControl string
+		proxy        string
+		want         bool
+	}{
+		{name: "absent", want: true},
+		{name: "zero", expires: "0", want: false},
+		{name: "minus one", expires: "-1", want: false},
+		{name: "malformed", expires: "tomorrow", want: false},
+		{name: "past", expires: now.Add(-time.Hour).UTC().Format(http.TimeFormat), want: false},
+		{name: "future", expires: now.Add(time.Hour).UTC().Format(http.TimeFormat), want: true},
+		{
+			name:    "past of the origin date",
+			expires: now.Add(time.Hour).UTC().Format(http.TimeFormat),
+			date:    now.Add(2 * time.Hour).UTC().Format(http.TimeFormat),
+			want:    false,
+		},
+		{name: "overridden by max-age", expires: "0", cacheControl: "max-age=60", want: true},
+		{name: "overridden by the origin cache header", expires: "0", proxy: "max-age=60", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			if test.expires != "" {
+				rw.Header().Set("Expires", test.expires)
+			}
+			if test.date != "" {
+				rw.Header().Set("Date", test.date)
+			}
+			if test.cacheControl != "" {
+				rw.Header().Set("Cache-Control", test.cacheControl)
+			}
+			if test.proxy != "" {
+				rw.Header().Set(defaultOriginCacheHeader, test.proxy)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+			if _, ok := c.cacheable(req, rw, http.StatusOK); ok != test.want {
+				t.Errorf("unexpected cacheable: want %t, got %t", test.want, ok)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{value: "120", want: 2 * time.Minute, wantOk: true},
+		{value: " 30 ", want: 30 * time.Second, wantOk: true},
+		{value: "Mon, 01 Jan 2024 12:01:30 GMT", want: 90 * time.Second, wantOk: true},
+		{value: "Mon, 01 Jan 2024 11:59:00 GMT", wantOk: false},
+		{value: "0", wantOk: false},
+		{value: "-5", wantOk: false},
+		{value: "soon", wantOk: false},
+		{value: "", wantOk: false},
+	}
+
+	for _, test := range tests {
+		got, ok := retryAfter(test.value, now)
+		if ok != test.wantOk || got != test.want {
+			t.Errorf("unexpected duration for %q: want %v %t, got %v %t", test.value, test.want, test.wantOk, got, ok)
+		}
+	}
+}
+
+func TestCache_Cacheable_RetryAfter(t *testing.T) {
+	c := &cache{
+		cfg: &Config{MaxExpiry: 300},
+		statusTTLs: map[int]time.Duration{
+			http.StatusTooManyRequests:    10 * time.Second,
+			http.StatusServiceUnavailable: 10 * time.Second,
+			http.StatusNotFound:           10 * time.Second,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantExpiry time.Duration
+	}{
+		{name: "429 with delay", status: http.StatusTooManyRequests, retryAfter: "42", wantExpiry: 42 * time.Second},
+		{name: "503 with delay", status: http.StatusServiceUnavailable, retryAfter: "60", wantExpiry: time.Minute},
+		{name: "capped at maxExpiry", status: http.StatusServiceUnavailable, retryAfter: "3600", wantExpiry: 5 * time.Minute},
+		{name: "invalid falls back to status ttl", status: http.StatusServiceUnavailable, retryAfter: "later", wantExpiry: 10 * time.Second},
+		{name: "ignored for other statuses", status: http.StatusNotFound, retryAfter: "42", wantExpiry: 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			rw.Header().Set("Retry-After", test.retryAfter)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+			expiry, ok := c.cacheable(req, rw, test.status)
+			if !ok || expiry != test.wantExpiry {
+				t.Errorf("unexpected expiry: want %v, got %v %t", test.wantExpiry, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestCache_Cacheable_DefaultStatuses(t *testing.T) {
+	c := &cache{
+		cfg:        &Config{MaxExpiry: 300},
+		statusTTLs: map[int]time.Duration{http.StatusFound: time.Minute},
+	}
+
+	tests := []struct {
+		status     int
+		wantExpiry time.Duration
+		wantOk     bool
+	}{
+		{status: http.StatusOK, wantExpiry: 5 * time.Minute, wantOk: true},
+		{status: http.StatusCreated, wantOk: false},
+		{status: http.StatusNonAuthoritativeInfo, wantExpiry: 5 * time.Minute, wantOk: true},
+		{status: http.StatusNoContent, wantExpiry: 5 * time.Minute, wantOk: true},
+		{status: http.StatusMultipleChoices, wantExpiry: 5 * time.Minute, wantOk: true},
+		{status: http.StatusMovedPermanently, wantExpiry: 5 * time.Minute, wantOk: true},
+		{status: http.StatusFound, wantExpiry: time.Minute, wantOk: true},
+		{status: http.StatusSeeOther, wantOk: false},
+		{status: http.StatusNotModified, wantOk: false},
+		{status: http.StatusTemporaryRedirect, wantOk: false},
+		{status: http.StatusPermanentRedirect, wantExpiry: 5 * time.Minute, wantOk: true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+		expiry, ok := c.cacheable(req, httptest.NewRecorder(), test.status)
+		if ok != test.wantOk || expiry != test.wantExpiry {
+			t.Errorf("unexpected expiry of %d: want %v %t, got %v %t", test.status, test.wantExpiry, test.wantOk, expiry, ok)
+		}
+	}
+}
+
+func TestCache_Cacheable_CacheStatuses(t *testing.T) {
+	c := &cache{
+		cfg:        &Config{MaxExpiry: 300, CacheStatuses: []int{http.StatusOK, http.StatusNotFound}},
+		statusTTLs: map[int]time.Duration{http.StatusGone: time.Minute},
+	}
+
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{status: http.StatusOK, want: true},
+		{status: http.StatusNotFound, want: true},
+		{status: http.StatusGone, want: true},
+		{status: http.StatusNoContent, want: false},
+		{status: http.StatusMovedPermanently, want: false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+		if _, ok := c.cacheable(req, httptest.NewRecorder(), test.status); ok != test.want {
+			t.Errorf("unexpected cacheability of %d: want %t, got %t", test.status, test.want, ok)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_TrustOriginCacheHeader(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("X-Proxy-Cache-Control", req.URL.Query().Get("proxy"))
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Path:                   dir,
+		MaxExpiry:              10,
+		Cleanup:                20,
+		AddStatusHeader:        true,
+		TrustOriginCacheHeader: true,
+		OriginCacheHeader:      "X-Proxy-Cache-Control",
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		proxy     string
+		wantState string
+	}{
+		{proxy: "max-age=600", wantState: "hit"},
+		{proxy: "no-store", wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?proxy="+test.proxy, nil)
+			rw := httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if v := rw.Header().Get("X-Proxy-Cache-Control"); v != "" {
+				t.Errorf("unexpected proxy header forwarded: %q", v)
+			}
+
+			if v := rw.Header().Get("Cache-Control"); v != "no-store" {
+				t.Errorf("unexpected client Cache-Control: %q", v)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?proxy="+test.proxy, nil)
+		rw := httptest.NewRecorder()
+
+		c.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %q: want %q, got: %q", test.proxy, test.wantState, state)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_HeuristicMaxTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxy      string
+		statusTTL  bool
+		wantExpiry time.Duration
+	}{
+		{name: "heuristic", wantExpiry: 30 * time.Second},
+		{name: "origin cache header", proxy: "max-age=300", wantExpiry: 300 * time.Second},
+		{name: "explicit status TTL", statusTTL: true, wantExpiry: 120 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				if test.proxy != "" {
+					rw.Header().Set("X-Proxy-Cache-Control", test.proxy)
+				}
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				Backend:                backendMemory,
+				MaxExpiry:              600,
+				Cleanup:                20,
+				HeuristicMaxTTL:        30,
+				TrustOriginCacheHeader: true,
+				OriginCacheHeader:      "X-Proxy-Cache-Control",
+			}
+			if test.statusTTL {
+				cfg.StatusTTLs = map[string]int{"200": 120}
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			c.ServeHTTP(httptest.NewRecorder(), req)
+
+			stat, ok := c.cache.Stat(c.cacheKey(req))
+			if !ok {
+				t.Fatal("expected the response to be stored")
+			}
+
+			if until := time.Until(stat.Expires); until > test.wantExpiry || until < test.wantExpiry-5*time.Second {
+				t.Errorf("unexpected expiry: want %v, got %v", test.wantExpiry, until)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_RequireHeader(t *testing.T) {
+	dir := createTempDir(t)
+
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		if v := req.URL.Query().Get("mark"); v != "" {
+			rw.Header().Set("X-Cacheable", v)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{
+		Path:               dir,
+		MaxExpiry:          10,
+		Cleanup:            20,
+		AddStatusHeader:    true,
+		RequireHeader:      "X-Cacheable",
+		RequireHeaderValue: "true",
+	}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mark      string
+		wantState string
+	}{
+		{mark: "true", wantState: "hit"},
+		{mark: "TRUE", wantState: "hit"},
+		{mark: "false", wantState: "miss"},
+		{wantState: "miss"},
+	}
+
+	for _, test := range tests {
+		var rw *httptest.ResponseRecorder
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path?mark="+test.mark, nil)
+			rw = httptest.NewRecorder()
+
+			c.ServeHTTP(rw, req)
+
+			if v := rw.Header().Get("X-Cacheable"); v != "" {
+				t.Errorf("unexpected marker header forwarded: %q", v)
+			}
+		}
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %q: want %q, got: %q", test.mark, test.wantState, state)
+		}
+	}
+}
+
+func TestCache_ServeHTTP_InvalidUTF8Header(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("X-Token", req.URL.Query().Get("token"))
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token     string
+		wantState string
+		wantCalls int
+	}{
+		{token: "caf%C3%A9", wantState: "hit", wantCalls: 1},
+		{token: "caf%E9", wantState: "miss", wantCalls: 3},
+	}
+
+	for _, test := range tests {
+		var rw *httptest.ResponseRecorder
+
+		for i := 0; i < 2; i++ {
+			rw = httptest.NewRecorder()
+			c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://localhost/some/path?token="+test.token, nil))
+		}
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("unexpected cache state for %s: want %q, got %q", test.token, test.wantState, state)
+		}
+
+		if calls != test.wantCalls {
+			t.Errorf("unexpected origin calls for %s: want %d, got %d", test.token, test.wantCalls, calls)
+		}
+
+		// The response is forwarded unaltered either way.
+		want, _ := url.QueryUnescape(test.token)
+		if got := rw.Header().Get("X-Token"); got != want {
+			t.Errorf("unexpected header: want %q, got %q", want, got)
+		}
+	}
+}
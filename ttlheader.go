@@ -0,0 +1,53 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ttlSecretHeader is the request header carrying the secret required to
+// override the TTL, if configured.
+const ttlSecretHeader = "X-Cache-Secret"
+
+type ttlOverrideKey struct{}
+
+// overrideTTL strips the TTL override headers from r, returning the request
+// carrying the requested TTL, if any, for cacheDecision. It reports the
+// status to reject the request with if the override is invalid or not
+// allowed.
+func (m *cache) overrideTTL(r *http.Request) (*http.Request, int) {
+	if m.cfg.TTLHeader == "" {
+		return r, 0
+	}
+
+	v := r.Header.Get(m.cfg.TTLHeader)
+	secret := r.Header.Get(ttlSecretHeader)
+
+	r.Header.Del(m.cfg.TTLHeader)
+	r.Header.Del(ttlSecretHeader)
+
+	if v == "" {
+		return r, 0
+	}
+
+	if m.cfg.TTLHeaderSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(m.cfg.TTLHeaderSecret)) != 1 {
+		return r, http.StatusForbidden
+	}
+
+	ttl, err := strconv.Atoi(v)
+	if err != nil || ttl < 1 || ttl > m.cfg.MaxExpiry {
+		return r, http.StatusBadRequest
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), ttlOverrideKey{}, time.Duration(ttl)*time.Second)), 0
+}
+
+// requestTTL returns the TTL requested by a trusted caller, if any.
+func requestTTL(r *http.Request) (time.Duration, bool) {
+	ttl, ok := r.Context().Value(ttlOverrideKey{}).(time.Duration)
+
+	return ttl, ok
+}
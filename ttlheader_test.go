@@ -0,0 +1,95 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_TTLHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        string
+		secret     string
+		wantCode   int
+		wantExpiry time.Duration
+	}{
+		{name: "no override", wantCode: http.StatusOK},
+		{name: "override", ttl: "60", secret: "s3cret", wantCode: http.StatusOK, wantExpiry: time.Minute},
+		{name: "secret mismatch", ttl: "60", secret: "guess", wantCode: http.StatusForbidden},
+		{name: "missing secret", ttl: "60", wantCode: http.StatusForbidden},
+		{name: "above maxExpiry", ttl: "3600", secret: "s3cret", wantCode: http.StatusBadRequest},
+		{name: "invalid", ttl: "soon", secret: "s3cret", wantCode: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var forwarded http.Header
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Clone()
+				rw.Header().Set(defaultOriginCacheHeader, "no-store")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				Backend:                backendMemory,
+				MaxExpiry:              600,
+				Cleanup:                20,
+				TrustOriginCacheHeader: true,
+				TTLHeader:              "X-Cache-Set-TTL",
+				TTLHeaderSecret:        "s3cret",
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+			if test.ttl != "" {
+				req.Header.Set("X-Cache-Set-TTL", test.ttl)
+			}
+			if test.secret != "" {
+				req.Header.Set("X-Cache-Secret", test.secret)
+			}
+
+			rw := httptest.NewRecorder()
+			c.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantCode {
+				t.Fatalf("unexpected status: want %d, got %d", test.wantCode, rw.Code)
+			}
+
+			if test.wantCode != http.StatusOK {
+				if forwarded != nil {
+					t.Error("expected the request to be rejected before reaching the origin")
+				}
+				return
+			}
+
+			if forwarded.Get("X-Cache-Set-TTL") != "" || forwarded.Get("X-Cache-Secret") != "" {
+				t.Errorf("expected the override headers to be stripped, got %v", forwarded)
+			}
+
+			stat, ok := c.cache.Stat(c.cacheKey(req))
+			if test.ttl == "" {
+				if ok {
+					t.Error("expected the origin to forbid caching without an override")
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatal("expected the response to be stored")
+			}
+
+			if until := time.Until(stat.Expires); until > test.wantExpiry || until < test.wantExpiry-5*time.Second {
+				t.Errorf("unexpected expiry: want %v, got %v", test.wantExpiry, until)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package plugin_simplecache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+const (
+	// variantOverflowEvict evicts the least recently used variants of a key
+	// to make room for a new one.
+	variantOverflowEvict = "evict"
+	// variantOverflowBypass doesn't cache new variants of a key with too
+	// many variants.
+	variantOverflowBypass = "bypass"
+)
+
+func validateVariantLimit(cfg *Config) error {
+	if cfg.MaxVariantsPerKey < 0 {
+		return errors.New("maxVariantsPerKey must be greater or equal to 0")
+	}
+
+	switch cfg.VariantOverflowPolicy {
+	case "", variantOverflowEvict, variantOverflowBypass:
+	default:
+		return fmt.Errorf("invalid variantOverflowPolicy %q", cfg.VariantOverflowPolicy)
+	}
+
+	return nil
+}
+
+// admitVariant reports whether variant may be stored among the variants of
+// the response stored under key, evicting the least recently used ones if
+// the key would otherwise have more than the maximum.
+func (m *cache) admitVariant(key, variant string) bool {
+	limit := m.cfg.MaxVariantsPerKey
+	if limit <= 0 {
+		return true
+	}
+
+	// Replacing a variant doesn't add any.
+	if _, ok := m.cache.Stat(variant); ok {
+		return true
+	}
+
+	variants := m.cache.Variants(key)
+
+	excess := len(variants) + 1 - limit
+	if excess <= 0 {
+		return true
+	}
+
+	if m.cfg.VariantOverflowPolicy == variantOverflowBypass {
+		log.Printf("Not caching variant of %s: %d variants already stored", key, len(variants))
+		return false
+	}
+
+	sort.Slice(variants, func(a, b int) bool {
+		return variants[a].UsedAt.Before(variants[b].UsedAt)
+	})
+
+	for _, stat := range variants[:excess] {
+		m.cache.Delete(stat.Key)
+	}
+
+	return true
+}
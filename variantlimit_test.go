@@ -0,0 +1,89 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache_ServeHTTP_MaxVariantsPerKey(t *testing.T) {
+	tests := []struct {
+		policy    string
+		wantKept  []string
+		wantEvict []string
+	}{
+		{policy: variantOverflowEvict, wantKept: []string{"en", "de", "it"}, wantEvict: []string{"fr"}},
+		{policy: variantOverflowBypass, wantKept: []string{"en", "fr", "de"}, wantEvict: []string{"it"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Vary", "Accept-Language")
+				rw.WriteHeader(http.StatusOK)
+			}
+
+			cfg := &Config{
+				Backend:               backendMemory,
+				MaxExpiry:             10,
+				Cleanup:               20,
+				AddStatusHeader:       true,
+				VaryMode:              varyModeKey,
+				MaxVariantsPerKey:     3,
+				VariantOverflowPolicy: test.policy,
+			}
+
+			h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := h.(*cache)
+
+			get := func(lang string) string {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+				req.Header.Set("Accept-Language", lang)
+
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, req)
+
+				return rw.Header().Get("Cache-Status")
+			}
+
+			for _, lang := range []string{"en", "fr", "de"} {
+				get(lang)
+			}
+
+			// Use en, leaving fr the least recently used variant.
+			time.Sleep(10 * time.Millisecond)
+			get("en")
+
+			get("it")
+
+			key := "GETlocalhost/page"
+
+			stat, ok := c.cache.Stat(key)
+			if !ok {
+				t.Fatal("expected the variant marker to be stored")
+			}
+
+			if stat.Variants != 3 {
+				t.Errorf("unexpected variant count: want 3, got %d", stat.Variants)
+			}
+
+			for _, lang := range test.wantKept {
+				if _, ok := c.cache.Stat(key + variantSep + "Accept-Language=" + lang); !ok {
+					t.Errorf("expected the %s variant to be kept", lang)
+				}
+			}
+
+			for _, lang := range test.wantEvict {
+				if _, ok := c.cache.Stat(key + variantSep + "Accept-Language=" + lang); ok {
+					t.Errorf("expected the %s variant not to be stored", lang)
+				}
+			}
+		})
+	}
+}
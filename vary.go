@@ -0,0 +1,203 @@
+package plugin_simplecache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// varyModeIgnore stores responses with a Vary header under the plain key,
+	// which can serve a variant to a client it wasn't negotiated for.
+	varyModeIgnore = "ignore"
+	// varyModeBypass doesn't cache responses with a Vary header at all.
+	varyModeBypass = "bypass"
+	// varyModeKey stores one entry per variant of the response.
+	varyModeKey = "key"
+)
+
+const (
+	// varyBlockBypass doesn't cache responses varying on a blocklisted
+	// header.
+	varyBlockBypass = "bypass"
+	// varyBlockIgnore leaves blocklisted headers out of the variant key.
+	varyBlockIgnore = "ignore"
+)
+
+// defaultVaryBlocklist holds the headers with too many distinct values to
+// key variants on.
+var defaultVaryBlocklist = []string{"User-Agent"}
+
+func (m *cache) varyCacheable(h http.Header) bool {
+	names := varyNames(h)
+
+	// Responses varying on credentials are per user: keying on the full
+	// header values would be unsafe and rarely hit.
+	for _, name := range names {
+		switch name {
+		case "Authorization":
+			return false
+		case "Cookie":
+			if m.cfg.VaryMode != varyModeKey || len(m.cfg.VaryCookies) == 0 {
+				return false
+			}
+		}
+	}
+
+	switch m.cfg.VaryMode {
+	case varyModeIgnore:
+		return true
+	case varyModeKey:
+		for _, name := range names {
+			if name == "*" {
+				return false
+			}
+
+			if m.deviceKeyed(name) {
+				continue
+			}
+
+			if m.varyBlocked(name) && m.cfg.VaryBlockPolicy != varyBlockIgnore {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return len(names) == 0
+	}
+}
+
+// varyNames returns the request header names listed in the Vary header,
+// canonicalized, sorted and deduplicated so that equivalent Vary headers
+// select the same variants.
+func varyNames(h http.Header) []string {
+	var names []string
+
+	seen := map[string]bool{}
+	for _, val := range h.Values("Vary") {
+		for _, name := range strings.Split(val, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" || seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// varyBlocked reports whether name is on the Vary blocklist.
+func (m *cache) varyBlocked(name string) bool {
+	blocklist := m.cfg.VaryBlocklist
+	if blocklist == nil {
+		blocklist = defaultVaryBlocklist
+	}
+
+	for _, blocked := range blocklist {
+		if strings.EqualFold(blocked, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deviceKeyed reports whether variants on the request header name are already
+// told apart by the device part of the key.
+func (m *cache) deviceKeyed(name string) bool {
+	return m.cfg.VaryByDevice && name == "User-Agent"
+}
+
+// variantNames returns the request header names selecting the variant of a
+// response, leaving out the blocklisted ones.
+func (m *cache) variantNames(h http.Header) []string {
+	var names []string
+	for _, name := range varyNames(h) {
+		if !m.varyBlocked(name) && !m.deviceKeyed(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// variantSep separates the key of a response stored per variant from the
+// signature of the variant in the variant's key.
+const variantSep = "|vary:"
+
+// splitVariantKey splits the key of a variant into the key of its response
+// and its signature, reporting whether key is a variant's.
+func splitVariantKey(key string) (string, string, bool) {
+	i := strings.LastIndex(key, variantSep)
+	if i < 0 {
+		return key, "", false
+	}
+
+	return key[:i], key[i+len(variantSep):], true
+}
+
+// variantKey returns the key of the variant of key selected by the given
+// request header names.
+func (m *cache) variantKey(key string, names []string, r *http.Request) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		var val string
+		switch name {
+		case "Cookie":
+			val = m.varyCookiesValue(r)
+		case "Accept-Encoding":
+			val = encodingKey(r.Header)
+		default:
+			val = varyHeaderValue(r.Header, name)
+		}
+
+		parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(val))
+	}
+
+	return key + variantSep + strings.Join(parts, "&")
+}
+
+// varyHeaderValue returns the value of the request header name in a variant
+// signature. The elements of its comma-separated values are trimmed and empty
+// ones dropped, so that an absent header, an empty one and a whitespace-only
+// one all give the same empty token, and so do the same values sent on one
+// line or several.
+func varyHeaderValue(h http.Header, name string) string {
+	var vals []string
+	for _, val := range h.Values(name) {
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				vals = append(vals, v)
+			}
+		}
+	}
+
+	return strings.Join(vals, ",")
+}
+
+// varyCookiesValue returns the values of the configured cookies only, so
+// that unrelated cookies don't fragment the cache.
+func (m *cache) varyCookiesValue(r *http.Request) string {
+	names := make([]string, len(m.cfg.VaryCookies))
+	copy(names, m.cfg.VaryCookies)
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		var val string
+		if c, err := r.Cookie(name); err == nil {
+			val = c.Value
+		}
+
+		parts = append(parts, name+"="+val)
+	}
+
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,248 @@
+package plugin_simplecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestVaryCacheable(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		vary    string
+		cookies []string
+		want    bool
+	}{
+		{name: "bypass without vary", mode: varyModeBypass, want: true},
+		{name: "bypass with vary", mode: varyModeBypass, vary: "Accept", want: false},
+		{name: "ignore with vary", mode: varyModeIgnore, vary: "Accept", want: true},
+		{name: "key with vary", mode: varyModeKey, vary: "Accept", want: true},
+		{name: "key with wildcard", mode: varyModeKey, vary: "Accept, *", want: false},
+		{name: "ignore with cookie", mode: varyModeIgnore, vary: "Cookie", want: false},
+		{name: "key with cookie", mode: varyModeKey, vary: "Accept, Cookie", want: false},
+		{name: "key with cookie and vary cookies", mode: varyModeKey, vary: "Cookie", cookies: []string{"lang"}, want: true},
+		{name: "ignore with cookie and vary cookies", mode: varyModeIgnore, vary: "Cookie", cookies: []string{"lang"}, want: false},
+		{name: "key with authorization", mode: varyModeKey, vary: "authorization", cookies: []string{"lang"}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &cache{cfg: &Config{VaryMode: test.mode, VaryCookies: test.cookies}}
+
+			h := http.Header{}
+			if test.vary != "" {
+				h.Set("Vary", test.vary)
+			}
+
+			if got := m.varyCacheable(h); got != test.want {
+				t.Errorf("unexpected cacheable: want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestVaryCacheable_Blocklist(t *testing.T) {
+	tests := []struct {
+		name      string
+		blocklist []string
+		policy    string
+		vary      string
+		want      bool
+	}{
+		{name: "user-agent blocked by default", vary: "Accept-Encoding, User-Agent", want: false},
+		{name: "user-agent ignored", policy: varyBlockIgnore, vary: "Accept-Encoding, User-Agent", want: true},
+		{name: "custom blocklist", blocklist: []string{"x-device-id"}, vary: "X-Device-Id", want: false},
+		{name: "custom blocklist allows user-agent", blocklist: []string{"X-Device-Id"}, vary: "User-Agent", want: true},
+		{name: "not blocklisted", vary: "Accept-Encoding", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &cache{cfg: &Config{VaryMode: varyModeKey, VaryBlocklist: test.blocklist, VaryBlockPolicy: test.policy}}
+
+			if got := m.varyCacheable(http.Header{"Vary": {test.vary}}); got != test.want {
+				t.Errorf("unexpected cacheable: want %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCache_ServeHTTP_VaryBlockIgnore(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Vary", "Accept-Encoding, User-Agent")
+		_, _ = rw.Write([]byte("some content"))
+	}
+
+	cfg := &Config{Path: createTempDir(t), MaxExpiry: 10, Cleanup: 20, VaryMode: varyModeKey, VaryBlockPolicy: varyBlockIgnore}
+
+	c, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ua := range []string{"curl/8.0", "Mozilla/5.0", "Wget/1.21"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		req.Header.Set("User-Agent", ua)
+
+		c.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected user agents to share the variant, got %d calls", calls)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	if calls != 2 {
+		t.Errorf("expected Accept-Encoding to select another variant, got %d calls", calls)
+	}
+}
+
+func TestVaryNames(t *testing.T) {
+	a := http.Header{"Vary": {"Accept-Encoding, Accept"}}
+	b := http.Header{"Vary": {"accept", "accept-encoding, ACCEPT"}}
+
+	want := []string{"Accept", "Accept-Encoding"}
+
+	for _, h := range []http.Header{a, b} {
+		if got := varyNames(h); !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected names for %v: want %v, got %v", h, want, got)
+		}
+	}
+
+	if got := varyNames(http.Header{"Vary": {" , "}}); len(got) != 0 {
+		t.Errorf("expected no names, got %v", got)
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	m := &cache{cfg: &Config{}}
+
+	got := m.variantKey("GETlocalhost/some/path", []string{"Accept-Encoding", "Accept"}, req)
+	want := "GETlocalhost/some/path|vary:Accept-Encoding=gzip&Accept="
+
+	if got != want {
+		t.Errorf("unexpected variant key: want %q, got %q", want, got)
+	}
+}
+
+func TestVariantKey_AbsentHeader(t *testing.T) {
+	m := &cache{cfg: &Config{}}
+	names := []string{"X-Custom"}
+
+	variant := func(vals ...string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		for _, val := range vals {
+			req.Header.Add("X-Custom", val)
+		}
+
+		return m.variantKey("key", names, req)
+	}
+
+	absent := variant()
+	if want := "key|vary:X-Custom="; absent != want {
+		t.Errorf("unexpected variant key: want %q, got %q", want, absent)
+	}
+
+	for _, vals := range [][]string{{""}, {" "}, {" , "}} {
+		if got := variant(vals...); got != absent {
+			t.Errorf("%q: expected the absent header variant, got %q", vals, got)
+		}
+	}
+
+	if variant("a") == absent {
+		t.Error("expected a present header to select another variant")
+	}
+
+	if variant("a, b") != variant("a", "b") || variant(" a,b ") != variant("a,b") {
+		t.Error("expected equivalent values to select the same variant")
+	}
+
+	if variant("a") == variant("b") {
+		t.Error("expected distinct values to select distinct variants")
+	}
+}
+
+func TestCache_ServeHTTP_VaryAbsentHeader(t *testing.T) {
+	var calls int
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Header().Set("Vary", "X-Custom")
+		_, _ = rw.Write([]byte("custom=" + req.Header.Get("X-Custom")))
+	}
+
+	cfg := &Config{Backend: backendMemory, MaxExpiry: 10, Cleanup: 20, AddStatusHeader: true, VaryMode: varyModeKey}
+
+	h, err := New(context.Background(), http.HandlerFunc(next), cfg, "simplecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		custom    string
+		wantState string
+		wantBody  string
+	}{
+		{wantState: "miss", wantBody: "custom="},
+		{wantState: "hit", wantBody: "custom="},
+		{custom: "a", wantState: "miss", wantBody: "custom=a"},
+		{custom: "a", wantState: "hit", wantBody: "custom=a"},
+		{wantState: "hit", wantBody: "custom="},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+		if test.custom != "" {
+			req.Header.Set("X-Custom", test.custom)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if state := rw.Header().Get("Cache-Status"); state != test.wantState {
+			t.Errorf("request %d: unexpected cache state: want %q, got %q", i, test.wantState, state)
+		}
+
+		if body := rw.Body.String(); body != test.wantBody {
+			t.Errorf("request %d: unexpected body: want %q, got %q", i, test.wantBody, body)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("unexpected origin calls: want 2, got %d", calls)
+	}
+}
+
+func TestVariantKey_VaryCookies(t *testing.T) {
+	m := &cache{cfg: &Config{VaryCookies: []string{"lang", "currency"}}}
+
+	a := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	a.Header.Set("Cookie", "session=abc; lang=fr; currency=eur")
+
+	b := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	b.Header.Set("Cookie", "currency=eur; lang=fr; session=def")
+
+	c := httptest.NewRequest(http.MethodGet, "http://localhost/some/path", nil)
+	c.Header.Set("Cookie", "lang=en; currency=eur")
+
+	names := []string{"Cookie"}
+
+	if m.variantKey("key", names, a) != m.variantKey("key", names, b) {
+		t.Error("expected unrelated cookies to be ignored")
+	}
+
+	if m.variantKey("key", names, a) == m.variantKey("key", names, c) {
+		t.Error("expected configured cookies to select the variant")
+	}
+}